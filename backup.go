@@ -0,0 +1,153 @@
+package wallabot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Backup file names are wallabot-<time>.db with this time layout.
+const (
+	backupPrefix = "wallabot-"
+	backupSuffix = ".db"
+	backupLayout = "20060102T150405Z"
+)
+
+// backupLimit is the maximum size of a document sent by a telegram bot.
+const backupLimit = 50 << 20
+
+// backupCheck is the interval between checks for a scheduled backup.
+const backupCheck = time.Minute
+
+// backup handles the /backup command, it sends a snapshot of the database to
+// the admin as a document.
+func (b *bot) backup(user int) {
+	if user != b.admin {
+		b.message(user, "only the admin can backup the database")
+		return
+	}
+	var buf bytes.Buffer
+	n, err := b.db.Backup(&buf)
+	if err != nil {
+		slog.Error("couldn't backup database", "err", err)
+		return
+	}
+	if n > backupLimit {
+		b.message(user, fmt.Sprintf("backup is too big to be sent (%d MB), configure backup.dir to write backups to disk", n>>20))
+		return
+	}
+	doc := tgbot.NewDocumentUpload(int64(user), tgbot.FileBytes{
+		Name:  backupName(time.Now()),
+		Bytes: buf.Bytes(),
+	})
+	doc.Caption = "restore it with: wallabot restore -db <path> <file>"
+	if _, err := b.Send(doc); err != nil {
+		slog.Error("couldn't send backup", "user", user, "err", err)
+		return
+	}
+	slog.Info("backup sent", "user", user, "bytes", n)
+}
+
+// backups writes scheduled backups to the backup directory, keeping only the
+// most recent ones. The schedule continues from the newest backup on disk so
+// restarts don't trigger extra backups.
+func (b *bot) backups(ctx context.Context) {
+	var last time.Time
+	for {
+		b.cfgLock.RLock()
+		dir, interval, keep := b.cfg.BackupDir, b.cfg.BackupInterval, b.cfg.BackupKeep
+		b.cfgLock.RUnlock()
+		if dir != "" {
+			if last.IsZero() {
+				last = latestBackup(dir)
+			}
+			if time.Since(last) >= interval {
+				// Update the last time even on error to avoid retrying every
+				// check
+				last = time.Now()
+				if err := b.backupTo(dir, keep); err != nil {
+					slog.Error("couldn't write scheduled backup", "dir", dir, "err", err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backupCheck):
+		}
+	}
+}
+
+// backupTo writes a backup to the directory and removes the oldest backups
+// exceeding keep.
+func (b *bot) backupTo(dir string, keep int) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("couldn't create backup directory: %w", err)
+	}
+	path := filepath.Join(dir, backupName(time.Now()))
+	n, err := b.db.BackupFile(path)
+	if err != nil {
+		return err
+	}
+	slog.Info("backup written", "path", path, "bytes", n)
+
+	names, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+	for len(names) > keep {
+		old := filepath.Join(dir, names[0])
+		if err := os.Remove(old); err != nil {
+			return fmt.Errorf("couldn't remove old backup: %w", err)
+		}
+		slog.Info("backup removed", "path", old)
+		names = names[1:]
+	}
+	return nil
+}
+
+// backupName returns the file name of a backup created at t.
+func backupName(t time.Time) string {
+	return backupPrefix + t.UTC().Format(backupLayout) + backupSuffix
+}
+
+// listBackups returns the backup file names of the directory, oldest first.
+func listBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read backup directory: %w", err)
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		if _, err := time.Parse(backupLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix)); err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// latestBackup returns the creation time of the newest backup of the
+// directory, zero if there is none.
+func latestBackup(dir string) time.Time {
+	names, err := listBackups(dir)
+	if err != nil || len(names) == 0 {
+		return time.Time{}
+	}
+	name := names[len(names)-1]
+	t, _ := time.Parse(backupLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+	return t
+}
@@ -0,0 +1,375 @@
+package wallabot
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/wallabot/internal/geo"
+)
+
+// Steps of the interactive search builder
+const (
+	stepKeywords = iota
+	stepExcludes
+	stepLocation
+	stepRadius
+	stepPrice
+	stepChat
+	stepConfirm
+)
+
+// draft is a search being composed with the interactive builder.
+type draft struct {
+	step     int
+	keywords []string
+	excludes []string
+	code     int
+	km       int
+	min      int
+	max      int
+	chat     string
+	name     string
+	tags     []string
+	targets  []string
+	replace  string
+}
+
+// newDraft creates a draft prefilled with the values of an existing search.
+func newDraft(parsed parsedArgs) (*draft, error) {
+	d := &draft{
+		chat:    parsed.chat,
+		name:    parsed.name,
+		tags:    parsed.tags,
+		targets: parsed.targets,
+		replace: parsed.id,
+	}
+	query := parsed.query
+	split := strings.SplitN(query, "?", 2)
+	if len(split) > 1 {
+		query = split[0]
+		values, err := url.ParseQuery(split[1])
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse query %s: %w", split[1], err)
+		}
+		for k, v := range map[string]*int{"code": &d.code, "km": &d.km, "min": &d.min, "max": &d.max} {
+			if values.Get(k) == "" {
+				continue
+			}
+			if *v, err = strconv.Atoi(values.Get(k)); err != nil {
+				return nil, fmt.Errorf("couldn't parse %s %s: %w", k, values.Get(k), err)
+			}
+		}
+	}
+	split = strings.SplitN(query, ":", 2)
+	if len(split) > 1 {
+		d.excludes = splitWords(split[1])
+	}
+	d.keywords = splitWords(split[0])
+	return d, nil
+}
+
+func splitWords(s string) []string {
+	var words []string
+	for _, w := range strings.Split(s, "+") {
+		if w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// edit starts the interactive builder with the values of an existing search.
+func (b *bot) edit(user int, parsed parsedArgs) {
+	if _, ok := b.searchs.Load(parsed.id); !ok {
+		b.message(user, fmt.Sprintf("search %s not found", parsed.label()))
+		return
+	}
+	d, err := newDraft(parsed)
+	if err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	b.drafts.SetDefault(strconv.Itoa(user), d)
+	b.prompt(user, d)
+}
+
+func (d *draft) query() string {
+	query := strings.Join(d.keywords, "+")
+	if len(d.excludes) > 0 {
+		query = fmt.Sprintf("%s:%s", query, strings.Join(d.excludes, "+"))
+	}
+	values := url.Values{}
+	for k, v := range map[string]int{"code": d.code, "km": d.km, "min": d.min, "max": d.max} {
+		if v > 0 {
+			values.Set(k, strconv.Itoa(v))
+		}
+	}
+	if len(values) > 0 {
+		query = fmt.Sprintf("%s?%s", query, values.Encode())
+	}
+	return query
+}
+
+// reservedChars can't be used in keywords or chats, they separate the parts
+// of a search key.
+const reservedChars = "/:?&+=#"
+
+// checkWords returns an error if a word contains reserved characters.
+func checkWords(words []string) error {
+	for _, w := range words {
+		if strings.ContainsAny(w, reservedChars) {
+			return fmt.Errorf("invalid word %s, it can't contain any of %s", w, reservedChars)
+		}
+	}
+	return nil
+}
+
+// validate checks the values of the draft before building its search key.
+func (d *draft) validate() error {
+	if err := checkWords(d.keywords); err != nil {
+		return err
+	}
+	if err := checkWords(d.excludes); err != nil {
+		return err
+	}
+	if d.chat == "" || strings.ContainsAny(d.chat, reservedChars) {
+		return fmt.Errorf("invalid chat %q", d.chat)
+	}
+	if d.min < 0 || d.max < 0 || (d.max > 0 && d.min > d.max) {
+		return fmt.Errorf("invalid price range %d-%d", d.min, d.max)
+	}
+	return nil
+}
+
+func (d *draft) parsed() (parsedArgs, error) {
+	if err := d.validate(); err != nil {
+		return parsedArgs{}, err
+	}
+	parsed, err := parseArgs(fmt.Sprintf("%s/%s", d.chat, d.query()), "")
+	if err != nil {
+		return parsedArgs{}, err
+	}
+	parsed.name = d.name
+	parsed.tags = d.tags
+	parsed.targets = d.targets
+	return parsed, nil
+}
+
+// build handles the /new command and its callback actions.
+func (b *bot) build(user int, chat string, action string) {
+	key := strconv.Itoa(user)
+	if action == "" {
+		d := &draft{chat: chat}
+		b.drafts.SetDefault(key, d)
+		b.prompt(user, d)
+		return
+	}
+	v, ok := b.drafts.Get(key)
+	if !ok {
+		b.message(user, "no search in progress or timed out, use /new to start")
+		return
+	}
+	d := v.(*draft)
+	switch action {
+	case "cancel":
+		b.drafts.Delete(key)
+		b.message(user, "search cancelled")
+		return
+	case "skip":
+		if (d.step == stepKeywords && len(d.keywords) == 0) || d.step == stepConfirm {
+			b.prompt(user, d)
+			return
+		}
+		d.step++
+	case "confirm":
+		if d.step != stepConfirm {
+			b.prompt(user, d)
+			return
+		}
+		parsed, err := d.parsed()
+		if err == nil && d.replace != "" && d.replace != parsed.id {
+			var old parsedArgs
+			if old, err = parseArgs(d.replace, ""); err == nil {
+				err = b.replace(old, parsed)
+			}
+		} else if err == nil {
+			err = b.add(parsed)
+		}
+		// The draft is kept so it can be fixed or cancelled
+		if err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		b.drafts.Delete(key)
+		b.message(user, fmt.Sprintf("searching %s", parsed.label()))
+		return
+	default:
+		switch {
+		case d.step == stepRadius && strings.HasPrefix(action, "km="):
+			km, err := strconv.Atoi(strings.TrimPrefix(action, "km="))
+			if err != nil {
+				b.message(user, fmt.Sprintf("invalid radius %s", action))
+				return
+			}
+			d.km = km
+			d.step++
+		case d.step == stepChat && strings.HasPrefix(action, "chat="):
+			d.chat = strings.TrimPrefix(action, "chat=")
+			d.step++
+		default:
+			b.prompt(user, d)
+			return
+		}
+	}
+	if d.step == stepRadius && d.code == 0 {
+		d.step++
+	}
+	b.drafts.SetDefault(key, d)
+	b.prompt(user, d)
+}
+
+// input handles text messages sent while a search is being composed.
+func (b *bot) input(user int, text string) {
+	key := strconv.Itoa(user)
+	v, ok := b.drafts.Get(key)
+	if !ok {
+		return
+	}
+	d := v.(*draft)
+	text = strings.Trim(text, " ")
+	switch d.step {
+	case stepKeywords:
+		words := strings.Fields(text)
+		if err := checkWords(words); err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		d.keywords = words
+	case stepExcludes:
+		words := strings.Fields(text)
+		if err := checkWords(words); err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		d.excludes = words
+	case stepLocation:
+		code, err := strconv.Atoi(text)
+		if err != nil {
+			b.message(user, fmt.Sprintf("invalid postal code %s", text))
+			return
+		}
+		if _, _, ok := geo.LatLong(code); !ok {
+			b.message(user, fmt.Sprintf("postal code %s not found", text))
+			return
+		}
+		d.code = code
+	case stepRadius:
+		km, err := strconv.Atoi(strings.TrimSuffix(text, "km"))
+		if err != nil {
+			b.message(user, fmt.Sprintf("invalid radius %s", text))
+			return
+		}
+		d.km = km
+	case stepPrice:
+		split := strings.SplitN(text, "-", 2)
+		min, err := strconv.Atoi(strings.Trim(split[0], " "))
+		if err != nil && split[0] != "" {
+			b.message(user, fmt.Sprintf("invalid price range %s", text))
+			return
+		}
+		max := 0
+		if len(split) > 1 {
+			max, err = strconv.Atoi(strings.Trim(split[1], " "))
+			if err != nil {
+				b.message(user, fmt.Sprintf("invalid price range %s", text))
+				return
+			}
+		}
+		if min < 0 || max < 0 || (max > 0 && min > max) {
+			b.message(user, fmt.Sprintf("invalid price range %s, min must not be greater than max", text))
+			return
+		}
+		d.min, d.max = min, max
+	case stepChat:
+		if text == "" || strings.ContainsAny(text, reservedChars) {
+			b.message(user, fmt.Sprintf("invalid chat %s", text))
+			return
+		}
+		d.chat = strings.ToLower(text)
+	default:
+		b.prompt(user, d)
+		return
+	}
+	if len(d.keywords) == 0 {
+		b.prompt(user, d)
+		return
+	}
+	d.step++
+	if d.step == stepRadius && d.code == 0 {
+		d.step++
+	}
+	b.drafts.SetDefault(key, d)
+	b.prompt(user, d)
+}
+
+// prompt asks the user for the input of the current step.
+func (b *bot) prompt(user int, d *draft) {
+	skip := tgbot.NewInlineKeyboardButtonData("skip", "/new skip")
+	cancel := tgbot.NewInlineKeyboardButtonData("cancel", "/new cancel")
+	switch d.step {
+	case stepKeywords:
+		btns := []tgbot.InlineKeyboardButton{cancel}
+		if len(d.keywords) > 0 {
+			btns = []tgbot.InlineKeyboardButton{skip, cancel}
+		}
+		b.messageOpts(user, current("send the keywords to search", strings.Join(d.keywords, " ")), false, btns)
+	case stepExcludes:
+		b.messageOpts(user, current("send the words to exclude", strings.Join(d.excludes, " ")), false, []tgbot.InlineKeyboardButton{skip, cancel})
+	case stepLocation:
+		b.messageOpts(user, current("send the postal code of the location", d.code), false, []tgbot.InlineKeyboardButton{skip, cancel})
+	case stepRadius:
+		var btns []tgbot.InlineKeyboardButton
+		for _, km := range []int{5, 10, 30, 50, 100} {
+			btns = append(btns, tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("%dkm", km), fmt.Sprintf("/new km=%d", km)))
+		}
+		b.messageOpts(user, current("choose or send the radius in km", d.km), false, append(btns, skip, cancel))
+	case stepPrice:
+		price := ""
+		if d.min > 0 || d.max > 0 {
+			price = fmt.Sprintf("%d-%d", d.min, d.max)
+		}
+		b.messageOpts(user, current("send the price range as min-max (e.g. 50-300)", price), false, []tgbot.InlineKeyboardButton{skip, cancel})
+	case stepChat:
+		btns := []tgbot.InlineKeyboardButton{
+			tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("current (%s)", d.chat), fmt.Sprintf("/new chat=%s", d.chat)),
+		}
+		if me := strconv.Itoa(user); me != d.chat {
+			btns = append(btns, tgbot.NewInlineKeyboardButtonData("me", fmt.Sprintf("/new chat=%s", me)))
+		}
+		b.messageOpts(user, "choose or send the target chat id", false, append(btns, cancel))
+	case stepConfirm:
+		btns := []tgbot.InlineKeyboardButton{
+			tgbot.NewInlineKeyboardButtonData("confirm", "/new confirm"),
+			cancel,
+		}
+		b.messageOpts(user, fmt.Sprintf("confirm search:\n\n%s/%s", d.chat, d.query()), false, btns)
+	}
+}
+
+// current appends the current value of a step to its prompt.
+func current(text string, value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return text
+		}
+	case int:
+		if v == 0 {
+			return text
+		}
+	}
+	return fmt.Sprintf("%s\n\ncurrent: %v", text, value)
+}
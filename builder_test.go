@@ -0,0 +1,256 @@
+package wallabot
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/notify"
+	"github.com/patrickmn/go-cache"
+)
+
+// newBuilderBot returns a test bot ready to compose searches.
+func newBuilderBot(t *testing.T) *bot {
+	t.Helper()
+	b := newTestBot(t)
+	b.drafts = cache.New(10*time.Minute, time.Minute)
+	return b
+}
+
+// currentDraft returns the draft of the user.
+func currentDraft(t *testing.T, b *bot, user string) *draft {
+	t.Helper()
+	v, ok := b.drafts.Get(user)
+	if !ok {
+		t.Fatalf("no draft of %s", user)
+	}
+	return v.(*draft)
+}
+
+// skipTo skips steps of the draft of the user until the given one.
+func skipTo(t *testing.T, b *bot, user int, step int) {
+	t.Helper()
+	for n := 0; currentDraft(t, b, strconv.Itoa(user)).step < step; n++ {
+		if n > stepConfirm {
+			t.Fatalf("step %d not reached", step)
+		}
+		b.build(user, "", "skip")
+	}
+}
+
+func TestBuilderReplace(t *testing.T) {
+	b := newBuilderBot(t)
+	b.notifier = map[string]notify.Notifier{"hook": &eventRecorder{}}
+	parsed, err := parseArgs("name=bikes to=hook 2/bike", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.add(parsed); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.PutItems("2/bike", map[string]api.Item{"a1": {ID: "a1", Price: 100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.edit(testAlice, parsed)
+	b.input(testAlice, "road bike")
+	skipTo(t, b, testAlice, stepConfirm)
+
+	// The edited search isn't deleted if its replacement can't be added
+	b.notifier = nil
+	b.build(testAlice, "", "confirm")
+	if text := lastMessage(t, b, "2"); text != "notifier hook not found" {
+		t.Errorf("unexpected message %q", text)
+	}
+	if _, ok := b.searchs.Load("2/bike"); !ok {
+		t.Fatal("edited search was deleted")
+	}
+	if items, err := b.db.Items("2/bike"); err != nil || len(items) != 1 {
+		t.Errorf("got items %v (%v), want 1", items, err)
+	}
+	if _, ok := b.searchs.Load("2/road+bike"); ok {
+		t.Error("failed replacement was added")
+	}
+
+	// The draft is kept to be confirmed again
+	b.notifier = map[string]notify.Notifier{"hook": &eventRecorder{}}
+	b.build(testAlice, "", "confirm")
+	if text := lastMessage(t, b, "2"); !strings.HasPrefix(text, "searching bikes") {
+		t.Errorf("unexpected message %q", text)
+	}
+	if _, ok := b.searchs.Load("2/bike"); ok {
+		t.Error("edited search wasn't replaced")
+	}
+	v, ok := b.searchs.Load("2/road+bike")
+	if !ok {
+		t.Fatal("replacement wasn't added")
+	}
+	if info := v.(searchInfo); info.Name != "bikes" || len(info.Targets) != 1 {
+		t.Errorf("replacement = %+v, want the name and targets of the edited search", info)
+	}
+	if _, ok := b.drafts.Get("2"); ok {
+		t.Error("draft wasn't deleted after confirming it")
+	}
+}
+
+// lastButtons returns the callback data of the buttons of the last queued
+// message to the chat.
+func lastButtons(t *testing.T, b *bot, chat string) []string {
+	t.Helper()
+	b.queue.lock.Lock()
+	defer b.queue.lock.Unlock()
+	for n := len(b.queue.pending) - 1; n >= 0; n-- {
+		if o := b.queue.pending[n]; o.Chat == chat {
+			var data []string
+			for _, row := range o.Buttons {
+				for _, btn := range row {
+					data = append(data, *btn.CallbackData)
+				}
+			}
+			return data
+		}
+	}
+	t.Fatalf("no messages to %s", chat)
+	return nil
+}
+
+func TestBuilderSteps(t *testing.T) {
+	b := newBuilderBot(t)
+	b.build(testAlice, "2", "")
+	steps := []struct {
+		input  string
+		action string
+		step   int
+		button string
+	}{
+		// Keywords are required, they can't be skipped
+		{"", "skip", stepKeywords, "/new cancel"},
+		{"lamp", "", stepExcludes, "/new skip"},
+		{"desk", "", stepLocation, "/new skip"},
+		{"28001", "", stepRadius, "/new km=10"},
+		// The radius step can be cancelled too
+		{"", "", stepRadius, "/new cancel"},
+		{"", "/new km=invalid", stepRadius, ""},
+		{"", "km=10", stepPrice, "/new skip"},
+		{"50-20", "", stepPrice, ""},
+		{"20-50", "", stepChat, "/new chat=2"},
+		{"", "chat=2", stepConfirm, "/new confirm"},
+	}
+	for _, s := range steps {
+		switch {
+		case s.input != "":
+			b.input(testAlice, s.input)
+		case s.action != "":
+			b.build(testAlice, "", strings.TrimPrefix(s.action, "/new "))
+		}
+		d := currentDraft(t, b, "2")
+		if d.step != s.step {
+			t.Fatalf("%q %q: step = %d, want %d", s.input, s.action, d.step, s.step)
+		}
+		if s.button == "" {
+			continue
+		}
+		if btns := strings.Join(lastButtons(t, b, "2"), ","); !strings.Contains(btns, s.button) {
+			t.Errorf("%q %q: got buttons %s, want %s", s.input, s.action, btns, s.button)
+		}
+	}
+	if text := lastMessage(t, b, "2"); !strings.HasSuffix(text, "2/lamp:desk?code=28001&km=10&max=50&min=20") {
+		t.Errorf("unexpected confirm message %q", text)
+	}
+	b.build(testAlice, "", "confirm")
+	if _, ok := b.searchs.Load("2/lamp:desk?code=28001&km=10&max=50&min=20"); !ok {
+		t.Error("search wasn't added")
+	}
+
+	// The radius step is skipped without a location
+	b.build(testAlice, "2", "")
+	b.input(testAlice, "lamp")
+	skipTo(t, b, testAlice, stepLocation)
+	b.build(testAlice, "", "skip")
+	if d := currentDraft(t, b, "2"); d.step != stepPrice {
+		t.Errorf("step = %d, want the price step", d.step)
+	}
+	b.build(testAlice, "", "cancel")
+	if _, ok := b.drafts.Get("2"); ok {
+		t.Error("draft wasn't deleted after cancelling it")
+	}
+	b.build(testAlice, "", "skip")
+	if text := lastMessage(t, b, "2"); !strings.HasPrefix(text, "no search in progress") {
+		t.Errorf("unexpected message %q", text)
+	}
+}
+
+func TestBuilderReservedChars(t *testing.T) {
+	b := newBuilderBot(t)
+	b.build(testAlice, "2", "")
+	for _, words := range []string{"lamp/desk", "lamp:desk", "lamp?x", "lamp&x", "lamp+desk", "a=b", "#lamp"} {
+		b.input(testAlice, words)
+		if text := lastMessage(t, b, "2"); !strings.HasPrefix(text, "invalid word") {
+			t.Errorf("%s: unexpected message %q", words, text)
+		}
+		if d := currentDraft(t, b, "2"); d.step != stepKeywords || len(d.keywords) > 0 {
+			t.Errorf("%s: got step %d and keywords %v, want them rejected", words, d.step, d.keywords)
+		}
+	}
+	b.input(testAlice, "lamp")
+	b.input(testAlice, "desk/x")
+	if d := currentDraft(t, b, "2"); d.step != stepExcludes || len(d.excludes) > 0 {
+		t.Errorf("got step %d and excludes %v, want them rejected", d.step, d.excludes)
+	}
+
+	skipTo(t, b, testAlice, stepChat)
+	for _, chat := range []string{"2/x", "@chan:x", ""} {
+		b.input(testAlice, chat)
+		if d := currentDraft(t, b, "2"); d.step != stepChat || d.chat != "2" {
+			t.Errorf("%q: got step %d and chat %s, want it rejected", chat, d.step, d.chat)
+		}
+	}
+
+	// Drafts are validated again before being confirmed
+	d := currentDraft(t, b, "2")
+	d.step, d.chat = stepConfirm, "2?x"
+	b.build(testAlice, "", "confirm")
+	if text := lastMessage(t, b, "2"); text != `invalid chat "2?x"` {
+		t.Errorf("unexpected message %q", text)
+	}
+}
+
+func TestBuilderEdit(t *testing.T) {
+	b := newBuilderBot(t)
+	parsed, err := parseArgs("name=lamps tags=home 2/lamp+desk:red?code=28001&km=30&max=90&min=10", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.add(parsed); err != nil {
+		t.Fatal(err)
+	}
+
+	// The draft is prefilled with the values of the search
+	b.edit(testAlice, parsed)
+	d := currentDraft(t, b, "2")
+	if strings.Join(d.keywords, " ") != "lamp desk" || strings.Join(d.excludes, " ") != "red" ||
+		d.code != 28001 || d.km != 30 || d.min != 10 || d.max != 90 || d.chat != "2" || d.replace != parsed.id {
+		t.Fatalf("unexpected draft %+v", d)
+	}
+	if text := lastMessage(t, b, "2"); !strings.HasSuffix(text, "current: lamp desk") {
+		t.Errorf("unexpected prompt %q", text)
+	}
+
+	// Confirming it unchanged keeps the search
+	skipTo(t, b, testAlice, stepConfirm)
+	b.build(testAlice, "", "confirm")
+	v, ok := b.searchs.Load(parsed.id)
+	if !ok {
+		t.Fatal("edited search was deleted")
+	}
+	if info := v.(searchInfo); info.Name != "lamps" || strings.Join(info.Tags, ",") != "home" {
+		t.Errorf("got search %+v, want the name and tags kept", info)
+	}
+
+	b.edit(testAlice, parsedArgs{id: "2/unknown", chat: "2", query: "unknown"})
+	if text := lastMessage(t, b, "2"); !strings.Contains(text, "not found") {
+		t.Errorf("unexpected message %q", text)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// dbCommand runs the db subcommands, the bot must not be running.
+func dbCommand(args []string) error {
+	sub, args, err := subcommand("db", args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("db "+sub, flag.ExitOnError)
+	path := fs.String("db", "wallabot.db", "database file path")
+	output := fs.String("o", "", "output file (stdout if empty)")
+	backend := fs.String("backend", store.BackendSQLite, "backend of the destination database (migrate only)")
+	_ = fs.Parse(args)
+
+	switch sub {
+	case "check":
+		if err := store.Check(*path); err != nil {
+			return err
+		}
+		fmt.Printf("%s is ok\n", *path)
+		return nil
+	case "compact":
+		before, after, err := store.Compact(*path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("compacted %s: %d → %d bytes\n", *path, before, after)
+		return nil
+	case "migrate":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("db migrate: usage: wallabot db migrate [-db path] [-backend sqlite] <destination>")
+		}
+		return migrate(*path, fs.Arg(0), *backend)
+	}
+
+	// Inspection commands open the database read-only so they don't create
+	// buckets or migrate it
+	var db store.Store
+	if sub == "ls" || sub == "dump" {
+		db, err = store.OpenReadOnly(*path)
+	} else {
+		db, err = store.Open("", *path)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch sub {
+	case "ls":
+		if fs.NArg() > 0 {
+			keys, err := db.Keys(fs.Arg(0))
+			if err != nil {
+				return err
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Println(k)
+			}
+			return nil
+		}
+		buckets, err := db.Buckets()
+		if err != nil {
+			return err
+		}
+		for _, b := range buckets {
+			keys, err := db.Keys(b)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s\t%d\n", b, len(keys))
+		}
+		return nil
+	case "dump":
+		dump, err := db.Dump()
+		if err != nil {
+			return err
+		}
+		return writeOutput(*output, func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(dump)
+		})
+	case "import":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("db import: usage: wallabot db import [-db path] <file>")
+		}
+		data, err := readInput(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		var dump map[string]*store.Bucket
+		if err := json.Unmarshal(data, &dump); err != nil {
+			return fmt.Errorf("db import: couldn't decode %s: %w", fs.Arg(0), err)
+		}
+		if err := db.Import(dump); err != nil {
+			return err
+		}
+		fmt.Printf("imported %d buckets\n", len(dump))
+		return nil
+	default:
+		return fmt.Errorf("db: unknown subcommand %q", sub)
+	}
+}
+
+// migrate copies the database at src to a new database at dst with another
+// backend.
+func migrate(src, dst, backend string) error {
+	if _, err := os.Stat(dst); err == nil {
+		return fmt.Errorf("db migrate: %s already exists", dst)
+	}
+	from, err := store.Open("", src)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := store.Open(backend, dst)
+	if err != nil {
+		return err
+	}
+	if err := store.Copy(to, from); err != nil {
+		to.Close()
+		_ = os.Remove(dst)
+		return err
+	}
+	to.Close()
+	if err := store.Check(dst); err != nil {
+		return err
+	}
+	searches, err := from.Searches()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("migrated %s to %s (%s, %d searches)\n", src, dst, backend, len(searches))
+	return nil
+}
+
+// writeOutput writes to the file or to stdout if it is empty.
+func writeOutput(path string, fn func(io.Writer) error) error {
+	if path == "" {
+		return fn(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("couldn't create %s: %w", path, err)
+	}
+	if err := fn(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readInput reads the file or stdin if it is "-".
+func readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// restore replaces the database with a backup, the bot must not be running.
+func restore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("db", "wallabot.db", "database file path")
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("restore: usage: wallabot restore [-db path] <backup>")
+	}
+	_, err := os.Stat(*path)
+	existed := err == nil
+	if err := store.Restore(fs.Arg(0), *path); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s\n", *path, fs.Arg(0))
+	if existed {
+		fmt.Printf("the previous database was kept as %s.bak\n", *path)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/igolaizola/wallabot/internal/geo"
+)
+
+// geoCommand runs the geo subcommands.
+func geoCommand(args []string) error {
+	sub, args, err := subcommand("geo", args)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "lookup":
+		if len(args) != 1 {
+			return fmt.Errorf("geo lookup: usage: wallabot geo lookup <postal code>")
+		}
+		code, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("geo lookup: invalid postal code %q", args[0])
+		}
+		lat, long, ok := geo.LatLong(code)
+		if !ok {
+			return fmt.Errorf("geo lookup: postal code %s not found", args[0])
+		}
+		fmt.Printf("%.5f,%.5f\n", lat, long)
+		return nil
+	default:
+		return fmt.Errorf("geo: unknown subcommand %q", sub)
+	}
+}
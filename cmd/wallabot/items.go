@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/igolaizola/wallabot"
+)
+
+// itemsCommand runs the items subcommands, the bot must not be running.
+func itemsCommand(args []string) error {
+	sub, args, err := subcommand("items", args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("items "+sub, flag.ExitOnError)
+	path := fs.String("db", "wallabot.db", "database file path")
+	output := fs.String("o", "", "output file (stdout if empty)")
+	format := fs.String("format", wallabot.FormatCSV, "output format (csv or jsonl)")
+	search := fs.String("search", "", "key or name of the search to export, all searches if empty")
+	since := fs.String("since", "", "export items seen since this date, so listed after it (2006-01-02 or RFC 3339)")
+	until := fs.String("until", "", "export items created until this date, so listed before it (2006-01-02 or RFC 3339)")
+	minPrice := fs.String("min-price", "", "minimum current price of the items, positive")
+	maxPrice := fs.String("max-price", "", "maximum current price of the items, positive")
+	_ = fs.Parse(args)
+
+	switch sub {
+	case "export":
+		start, end, err := wallabot.ParseDateRange(*since, *until)
+		if err != nil {
+			return err
+		}
+		min, max, err := wallabot.ParsePriceRange(*minPrice, *maxPrice)
+		if err != nil {
+			return err
+		}
+		filter := wallabot.ItemFilter{
+			Search:   *search,
+			Since:    start,
+			Until:    end,
+			MinPrice: min,
+			MaxPrice: max,
+		}
+		var n int
+		if err := writeOutput(*output, func(w io.Writer) error {
+			var err error
+			n, err = wallabot.ExportItems(*path, w, *format, filter)
+			return err
+		}); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "exported %d items\n", n)
+		return nil
+	default:
+		return fmt.Errorf("items: unknown subcommand %q", sub)
+	}
+}
@@ -2,39 +2,72 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
-	"strconv"
-
-	"github.com/igolaizola/wallabot"
+	"strings"
 )
 
-func main() {
-	// Parse flags
-	token := flag.String("token", "", "telegram bot token")
-	db := flag.String("db", "wallabot.db", "database file path")
-	admin := flag.Int("admin", 0, "admin chat id that controls the bot")
-	var users arrayFlags
-	flag.Var(&users, "user", "user chat id allowed to control the bot")
+const usage = `usage: wallabot <command> [flags] [args]
+
+commands:
+  run                            run the bot (default when no command is given)
+  search <query>                 run a one-shot search and print the matching items
+  db ls [bucket]                 list the buckets or the keys of a bucket
+  db dump                        dump the database as json
+  db import <file>               import a json dump into the database
+  db compact                     rewrite the database to reclaim free space
+  db check                       check the integrity of the database
+  db migrate <destination>       copy the database to a new one with another backend
+  restore <backup>               replace the database with a backup after checking it
+  searches export                export the stored searches as a config file
+  searches import <file>         import the searches of a config file
+  items export                   export the items and their price history as csv or json lines
+  geo lookup <postal code>       print the coordinates of a postal code
+
+run "wallabot <command> -h" to see the flags of a command
+`
 
-	flag.Parse()
-	if *token == "" {
-		log.Fatal("token not provided")
+func main() {
+	log.SetFlags(0)
+	args := os.Args[1:]
+	cmd := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
 	}
-	if *db == "" {
-		log.Fatal("db not provided")
+	var err error
+	switch cmd {
+	case "run":
+		err = run(args)
+	case "search":
+		err = search(args)
+	case "db":
+		err = dbCommand(args)
+	case "restore":
+		err = restore(args)
+	case "searches":
+		err = searchesCommand(args)
+	case "items":
+		err = itemsCommand(args)
+	case "geo":
+		err = geoCommand(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
 	}
-	if *admin <= 0 {
-		log.Fatal("admin provided")
+	if err != nil {
+		log.Fatal(err)
 	}
+}
 
-	// Create signal based context
+// signalContext returns a context that is cancelled on interrupt.
+func signalContext() (context.Context, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, os.Kill)
+	signal.Notify(c, os.Interrupt)
 	go func() {
 		select {
 		case <-c:
@@ -44,27 +77,13 @@ func main() {
 		}
 		signal.Stop(c)
 	}()
-
-	// Run bot
-	if err := wallabot.Run(ctx, *token, *db, *admin, users); err != nil {
-		log.Fatal(err)
-	}
-}
-
-type arrayFlags []int
-
-func (i *arrayFlags) String() string {
-	if i == nil {
-		return ""
-	}
-	return fmt.Sprintf("%v", []int(*i))
+	return ctx, cancel
 }
 
-func (i *arrayFlags) Set(val string) error {
-	num, err := strconv.Atoi(val)
-	if err != nil {
-		return fmt.Errorf("couldn't parse user %s: %w", val, err)
+// subcommand splits the subcommand from its arguments.
+func subcommand(name string, args []string) (string, []string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return "", nil, fmt.Errorf("%s: missing subcommand\n\n%s", name, usage)
 	}
-	*i = append(*i, num)
-	return nil
+	return args[0], args[1:], nil
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/igolaizola/wallabot"
+	"github.com/igolaizola/wallabot/internal/notify"
+)
+
+// run runs the bot.
+func run(args []string) error {
+	// Parse flags
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	config := fs.String("config", "", "configuration file (yaml or toml), WALLABOT_<KEY> environment variables override it and flags override both")
+	token := fs.String("token", "", "telegram bot token")
+	db := fs.String("db", "wallabot.db", "database file path")
+	dbBackend := fs.String("db-backend", "", "database backend (bolt or sqlite), detected from the database file if empty")
+	admin := fs.Int("admin", 0, "admin chat id that controls the bot")
+	var users arrayFlags
+	fs.Var(&users, "user", "user chat id allowed to control the bot")
+	listen := fs.String("listen", "", "http server listen address (e.g. :8080)")
+	publicURL := fs.String("public-url", "", "public base url of the http server (e.g. https://example.com)")
+	tlsCert := fs.String("tls-cert", "", "tls certificate file to serve https")
+	tlsKey := fs.String("tls-key", "", "tls key file to serve https")
+	webhookURL := fs.String("webhook-url", "", "public webhook url for telegram updates, long polling is used if empty")
+	webhookSecret := fs.String("webhook-secret", "", "secret token to verify webhook requests (A-Z, a-z, 0-9, _ and -)")
+	searchStale := fs.Duration("search-stale", 30*time.Minute, "time without a successful search cycle after which the bot is unhealthy (0 to disable)")
+	telegramStale := fs.Duration("telegram-stale", 10*time.Minute, "time without reaching telegram after which the bot is unhealthy (0 to disable)")
+	logLevel := fs.String("log-level", "info", "log level (debug, info, warn or error)")
+	logFormat := fs.String("log-format", "text", "log format (text or json)")
+	backupDir := fs.String("backup-dir", "", "directory where scheduled backups are written, disabled if empty")
+	backupInterval := fs.Duration("backup-interval", 24*time.Hour, "interval between scheduled backups")
+	backupKeep := fs.Int("backup-keep", 7, "number of scheduled backups kept")
+	retentionMaxAge := fs.Duration("retention-max-age", 0, "default max age since last seen of the items of a search, unlimited if 0")
+	retentionMaxItems := fs.Int("retention-max-items", 0, "default max number of items of a search, only gone items are pruned to honour it, unlimited if 0")
+	pruneInterval := fs.Duration("prune-interval", 24*time.Hour, "interval between jobs pruning items and compacting the database")
+	var notifiers notifierFlags
+	fs.Var(&notifiers, "notifier", "notifier with format \"name=type:url [key=value ...]\", can be used by searchs with to=name")
+	dryRun := fs.Bool("dry-run", false, "print the changes needed to reconcile the configured searches with the database and exit")
+	_ = fs.Parse(args)
+
+	// load reads the config file and environment, flags set explicitly
+	// override them
+	load := func() (*wallabot.Config, error) {
+		cfg, err := wallabot.LoadConfig(*config)
+		if err != nil {
+			return nil, err
+		}
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "token":
+				cfg.Token = *token
+			case "db":
+				cfg.DB = *db
+			case "db-backend":
+				cfg.DBBackend = *dbBackend
+			case "admin":
+				cfg.Admin = *admin
+			case "user":
+				cfg.Users = users
+			case "listen":
+				cfg.Listen = *listen
+			case "public-url":
+				cfg.PublicURL = *publicURL
+			case "tls-cert":
+				cfg.TLSCert = *tlsCert
+			case "tls-key":
+				cfg.TLSKey = *tlsKey
+			case "webhook-url":
+				cfg.WebhookURL = *webhookURL
+			case "webhook-secret":
+				cfg.WebhookSecret = *webhookSecret
+			case "search-stale":
+				cfg.SearchStale = *searchStale
+			case "telegram-stale":
+				cfg.TelegramStale = *telegramStale
+			case "log-level":
+				cfg.LogLevel = *logLevel
+			case "log-format":
+				cfg.LogFormat = *logFormat
+			case "backup-dir":
+				cfg.BackupDir = *backupDir
+			case "backup-interval":
+				cfg.BackupInterval = *backupInterval
+			case "backup-keep":
+				cfg.BackupKeep = *backupKeep
+			case "retention-max-age":
+				cfg.RetentionMaxAge = *retentionMaxAge
+			case "retention-max-items":
+				cfg.RetentionMaxItems = *retentionMaxItems
+			case "prune-interval":
+				cfg.PruneInterval = *pruneInterval
+			case "notifier":
+				cfg.Notifiers = append(cfg.Notifiers, notifiers...)
+			}
+		})
+		if err := cfg.Validate(); err != nil {
+			return nil, err
+		}
+		return cfg, nil
+	}
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		report, err := wallabot.DryRun(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Println(report)
+		return nil
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	// Reload config on SIGHUP
+	reload := make(chan *wallabot.Config)
+	cfg.Reload = reload
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+			}
+			cfg, err := load()
+			if err != nil {
+				slog.Error("couldn't reload config", "err", err)
+				continue
+			}
+			select {
+			case reload <- cfg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Run bot
+	return wallabot.Run(ctx, cfg)
+}
+
+type arrayFlags []int
+
+func (i *arrayFlags) String() string {
+	if i == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", []int(*i))
+}
+
+func (i *arrayFlags) Set(val string) error {
+	num, err := strconv.Atoi(val)
+	if err != nil {
+		return fmt.Errorf("couldn't parse user %s: %w", val, err)
+	}
+	*i = append(*i, num)
+	return nil
+}
+
+type notifierFlags []notify.Config
+
+func (n *notifierFlags) String() string {
+	if n == nil {
+		return ""
+	}
+	var names []string
+	for _, c := range *n {
+		names = append(names, c.Name)
+	}
+	return fmt.Sprintf("%v", names)
+}
+
+func (n *notifierFlags) Set(val string) error {
+	cfg, err := notify.Parse(val)
+	if err != nil {
+		return err
+	}
+	*n = append(*n, cfg)
+	return nil
+}
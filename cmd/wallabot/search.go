@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+var errLimit = errors.New("limit reached")
+
+// search runs a one-shot search and prints the matching items.
+func search(args []string) error {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	jsonOutput := fs.Bool("json", false, "print items as json instead of a table")
+	limit := fs.Int("limit", 20, "maximum number of items to print (0 for all)")
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		return errors.New("search: missing query, e.g. wallabot search \"iphone+12:broken?code=28001&km=10&max=300\"")
+	}
+	query := strings.ReplaceAll(strings.Join(fs.Args(), " "), " ", "+")
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	client := api.New(ctx)
+
+	var items []api.Item
+	err := client.Search(query, make(map[string]api.Item), func(i api.Item) error {
+		items = append(items, i)
+		if *limit > 0 && len(items) >= *limit {
+			return errLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimit) {
+		return err
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if items == nil {
+			items = []api.Item{}
+		}
+		return enc.Encode(items)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPRICE\tTITLE\tLINK")
+	for _, i := range items {
+		fmt.Fprintf(w, "%s\t%.2f\t%s\t%s\n", i.ID, i.Price, i.Title, i.Link)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/igolaizola/wallabot"
+	"gopkg.in/yaml.v3"
+)
+
+// searchesCommand runs the searches subcommands, the bot must not be running.
+func searchesCommand(args []string) error {
+	sub, args, err := subcommand("searches", args)
+	if err != nil {
+		return err
+	}
+	fs := flag.NewFlagSet("searches "+sub, flag.ExitOnError)
+	path := fs.String("db", "wallabot.db", "database file path")
+	output := fs.String("o", "", "output file (stdout if empty)")
+	_ = fs.Parse(args)
+
+	switch sub {
+	case "export":
+		searches, err := wallabot.ExportSearches(*path)
+		if err != nil {
+			return err
+		}
+		return writeOutput(*output, func(w io.Writer) error {
+			enc := yaml.NewEncoder(w)
+			enc.SetIndent(2)
+			if err := enc.Encode(map[string]interface{}{"searches": searches}); err != nil {
+				return fmt.Errorf("couldn't encode searches: %w", err)
+			}
+			return enc.Close()
+		})
+	case "import":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("searches import: usage: wallabot searches import [-db path] <file.yaml|file.toml>")
+		}
+		cfg, err := wallabot.LoadConfig(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		n, err := wallabot.ImportSearches(*path, cfg.Searches)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %d of %d searches\n", n, len(cfg.Searches))
+		return nil
+	default:
+		return fmt.Errorf("searches: unknown subcommand %q", sub)
+	}
+}
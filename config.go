@@ -0,0 +1,439 @@
+package wallabot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/igolaizola/wallabot/internal/notify"
+	"github.com/igolaizola/wallabot/internal/store"
+	"gopkg.in/yaml.v3"
+)
+
+// Default values of the configuration.
+const (
+	defaultDB              = "wallabot.db"
+	defaultSearchInterval  = 5 * time.Second
+	defaultRequestInterval = time.Second
+	defaultSearchStale     = 30 * time.Minute
+	defaultTelegramStale   = 10 * time.Minute
+	defaultBackupInterval  = 24 * time.Hour
+	defaultBackupKeep      = 7
+	defaultPruneInterval   = 24 * time.Hour
+)
+
+// SearchConfig is a search defined in the configuration file.
+type SearchConfig struct {
+	Name    string   `yaml:"name,omitempty" toml:"name"`
+	Chat    string   `yaml:"chat" toml:"chat"`
+	Query   string   `yaml:"query" toml:"query"`
+	Tags    []string `yaml:"tags,omitempty" toml:"tags"`
+	Targets []string `yaml:"targets,omitempty" toml:"targets"`
+	Paused  bool     `yaml:"paused,omitempty" toml:"paused"`
+	// MaxAge and MaxItems override the default retention of the items
+	MaxAge   time.Duration `yaml:"max_age,omitempty" toml:"max_age"`
+	MaxItems int           `yaml:"max_items,omitempty" toml:"max_items"`
+}
+
+// fileConfig is the format of the configuration file. Durations are strings
+// so errors can point to the key.
+type fileConfig struct {
+	Token           string `yaml:"token" toml:"token"`
+	DB              string `yaml:"db" toml:"db"`
+	DBBackend       string `yaml:"db_backend" toml:"db_backend"`
+	Admin           int    `yaml:"admin" toml:"admin"`
+	Users           []int  `yaml:"users" toml:"users"`
+	Listen          string `yaml:"listen" toml:"listen"`
+	PublicURL       string `yaml:"public_url" toml:"public_url"`
+	TLSCert         string `yaml:"tls_cert" toml:"tls_cert"`
+	TLSKey          string `yaml:"tls_key" toml:"tls_key"`
+	WebhookURL      string `yaml:"webhook_url" toml:"webhook_url"`
+	WebhookSecret   string `yaml:"webhook_secret" toml:"webhook_secret"`
+	SearchInterval  string `yaml:"search_interval" toml:"search_interval"`
+	RequestInterval string `yaml:"request_interval" toml:"request_interval"`
+	SearchStale     string `yaml:"search_stale" toml:"search_stale"`
+	TelegramStale   string `yaml:"telegram_stale" toml:"telegram_stale"`
+	RateLimits      struct {
+		Global      string `yaml:"global" toml:"global"`
+		Private     string `yaml:"private" toml:"private"`
+		Group       string `yaml:"group" toml:"group"`
+		MaxAttempts int    `yaml:"max_attempts" toml:"max_attempts"`
+	} `yaml:"rate_limits" toml:"rate_limits"`
+	Log struct {
+		Level  string `yaml:"level" toml:"level"`
+		Format string `yaml:"format" toml:"format"`
+	} `yaml:"log" toml:"log"`
+	Backup struct {
+		Dir      string `yaml:"dir" toml:"dir"`
+		Interval string `yaml:"interval" toml:"interval"`
+		Keep     int    `yaml:"keep" toml:"keep"`
+	} `yaml:"backup" toml:"backup"`
+	Retention struct {
+		MaxAge   string `yaml:"max_age" toml:"max_age"`
+		MaxItems int    `yaml:"max_items" toml:"max_items"`
+		Interval string `yaml:"interval" toml:"interval"`
+	} `yaml:"retention" toml:"retention"`
+	Templates struct {
+		New       string `yaml:"new" toml:"new"`
+		PriceDrop string `yaml:"price_drop" toml:"price_drop"`
+	} `yaml:"templates" toml:"templates"`
+	Notifiers []struct {
+		Name    string            `yaml:"name,omitempty" toml:"name"`
+		Type    string            `yaml:"type" toml:"type"`
+		URL     string            `yaml:"url" toml:"url"`
+		Options map[string]string `yaml:"options" toml:"options"`
+	} `yaml:"notifiers" toml:"notifiers"`
+	Searches         []SearchConfig `yaml:"searches" toml:"searches"`
+	ObsoleteSearches string         `yaml:"obsolete_searches" toml:"obsolete_searches"`
+}
+
+// env returns the scalar keys that can be set with environment variables
+// named WALLABOT_<KEY>, dots replaced by underscores.
+func (f *fileConfig) env() map[string]interface{} {
+	return map[string]interface{}{
+		"token":                    &f.Token,
+		"db":                       &f.DB,
+		"db_backend":               &f.DBBackend,
+		"admin":                    &f.Admin,
+		"users":                    &f.Users,
+		"listen":                   &f.Listen,
+		"public_url":               &f.PublicURL,
+		"tls_cert":                 &f.TLSCert,
+		"tls_key":                  &f.TLSKey,
+		"webhook_url":              &f.WebhookURL,
+		"webhook_secret":           &f.WebhookSecret,
+		"search_interval":          &f.SearchInterval,
+		"request_interval":         &f.RequestInterval,
+		"search_stale":             &f.SearchStale,
+		"telegram_stale":           &f.TelegramStale,
+		"rate_limits.global":       &f.RateLimits.Global,
+		"rate_limits.private":      &f.RateLimits.Private,
+		"rate_limits.group":        &f.RateLimits.Group,
+		"rate_limits.max_attempts": &f.RateLimits.MaxAttempts,
+		"log.level":                &f.Log.Level,
+		"log.format":               &f.Log.Format,
+		"backup.dir":               &f.Backup.Dir,
+		"backup.interval":          &f.Backup.Interval,
+		"backup.keep":              &f.Backup.Keep,
+		"retention.max_age":        &f.Retention.MaxAge,
+		"retention.max_items":      &f.Retention.MaxItems,
+		"retention.interval":       &f.Retention.Interval,
+		"obsolete_searches":        &f.ObsoleteSearches,
+	}
+}
+
+// LoadConfig reads the configuration file, yaml or toml depending on its
+// extension, and applies the environment variables on top of it. The file
+// is optional, an empty path only reads the environment.
+func LoadConfig(path string) (*Config, error) {
+	var f fileConfig
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config: couldn't read %s: %w", path, err)
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			dec := yaml.NewDecoder(bytes.NewReader(data))
+			dec.KnownFields(true)
+			if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("config: couldn't parse %s: %w", path, err)
+			}
+		case ".toml":
+			md, err := toml.Decode(string(data), &f)
+			if err != nil {
+				return nil, fmt.Errorf("config: couldn't parse %s: %w", path, err)
+			}
+			if undecoded := md.Undecoded(); len(undecoded) > 0 {
+				return nil, fmt.Errorf("config: %s: unknown key", undecoded[0])
+			}
+		default:
+			return nil, fmt.Errorf("config: unsupported file extension %q, use .yaml, .yml or .toml", filepath.Ext(path))
+		}
+	}
+
+	for key, dst := range f.env() {
+		name := "WALLABOT_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch dst := dst.(type) {
+		case *string:
+			*dst = v
+		case *int:
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("config: %s: invalid integer %q in %s", key, v, name)
+			}
+			*dst = n
+		case *[]int:
+			*dst = nil
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s == "" {
+					continue
+				}
+				n, err := strconv.Atoi(s)
+				if err != nil {
+					return nil, fmt.Errorf("config: %s: invalid integer %q in %s", key, s, name)
+				}
+				*dst = append(*dst, n)
+			}
+		}
+	}
+
+	cfg := &Config{
+		Token:             f.Token,
+		DB:                f.DB,
+		DBBackend:         f.DBBackend,
+		Admin:             f.Admin,
+		Users:             f.Users,
+		Listen:            f.Listen,
+		PublicURL:         f.PublicURL,
+		TLSCert:           f.TLSCert,
+		TLSKey:            f.TLSKey,
+		WebhookURL:        f.WebhookURL,
+		WebhookSecret:     f.WebhookSecret,
+		MaxAttempts:       f.RateLimits.MaxAttempts,
+		LogLevel:          f.Log.Level,
+		LogFormat:         f.Log.Format,
+		BackupDir:         f.Backup.Dir,
+		BackupKeep:        f.Backup.Keep,
+		RetentionMaxItems: f.Retention.MaxItems,
+		NewTemplate:       f.Templates.New,
+		PriceDropTemplate: f.Templates.PriceDrop,
+		Searches:          f.Searches,
+		ObsoleteSearches:  f.ObsoleteSearches,
+	}
+	if cfg.DB == "" {
+		cfg.DB = defaultDB
+	}
+	for _, d := range []struct {
+		key   string
+		value string
+		dst   *time.Duration
+		def   time.Duration
+	}{
+		{"search_interval", f.SearchInterval, &cfg.SearchInterval, 0},
+		{"request_interval", f.RequestInterval, &cfg.RequestInterval, 0},
+		{"search_stale", f.SearchStale, &cfg.SearchStale, defaultSearchStale},
+		{"telegram_stale", f.TelegramStale, &cfg.TelegramStale, defaultTelegramStale},
+		{"rate_limits.global", f.RateLimits.Global, &cfg.GlobalInterval, 0},
+		{"rate_limits.private", f.RateLimits.Private, &cfg.PrivateInterval, 0},
+		{"rate_limits.group", f.RateLimits.Group, &cfg.GroupInterval, 0},
+		{"backup.interval", f.Backup.Interval, &cfg.BackupInterval, 0},
+		{"retention.max_age", f.Retention.MaxAge, &cfg.RetentionMaxAge, 0},
+		{"retention.interval", f.Retention.Interval, &cfg.PruneInterval, 0},
+	} {
+		*d.dst = d.def
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("config: %s: invalid duration %q", d.key, d.value)
+		}
+		*d.dst = v
+	}
+	for _, n := range f.Notifiers {
+		opts := n.Options
+		if opts == nil {
+			opts = make(map[string]string)
+		}
+		cfg.Notifiers = append(cfg.Notifiers, notify.Config{
+			Name:    strings.ToLower(n.Name),
+			Type:    strings.ToLower(n.Type),
+			URL:     n.URL,
+			Options: opts,
+		})
+	}
+	return cfg, nil
+}
+
+// Validate checks the configuration, errors name the offending key.
+func (c *Config) Validate() error {
+	if c.Token == "" {
+		return fmt.Errorf("config: token: required")
+	}
+	if c.DB == "" {
+		return fmt.Errorf("config: db: required")
+	}
+	switch c.DBBackend {
+	case "", store.BackendBolt, store.BackendSQLite:
+	default:
+		return fmt.Errorf("config: db_backend: must be %s or %s", store.BackendBolt, store.BackendSQLite)
+	}
+	if c.Admin <= 0 {
+		return fmt.Errorf("config: admin: must be a positive chat id")
+	}
+	for i, u := range c.Users {
+		if u <= 0 {
+			return fmt.Errorf("config: users[%d]: must be a positive chat id", i)
+		}
+	}
+	if c.WebhookURL != "" && c.Listen == "" {
+		return fmt.Errorf("config: listen: required for webhook_url")
+	}
+	if c.WebhookURL != "" {
+		if _, err := webhookPath(c.WebhookURL); err != nil {
+			return fmt.Errorf("config: webhook_url: %w", err)
+		}
+	}
+	if c.WebhookSecret != "" && !webhookSecret.MatchString(c.WebhookSecret) {
+		return fmt.Errorf("config: webhook_secret: must be 1-256 characters of A-Z, a-z, 0-9, _ and -")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("config: tls_cert, tls_key: both must be provided")
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"search_interval", c.SearchInterval},
+		{"request_interval", c.RequestInterval},
+		{"search_stale", c.SearchStale},
+		{"telegram_stale", c.TelegramStale},
+		{"rate_limits.global", c.GlobalInterval},
+		{"rate_limits.private", c.PrivateInterval},
+		{"rate_limits.group", c.GroupInterval},
+		{"backup.interval", c.BackupInterval},
+		{"retention.max_age", c.RetentionMaxAge},
+		{"retention.interval", c.PruneInterval},
+	} {
+		if d.value < 0 {
+			return fmt.Errorf("config: %s: must not be negative", d.key)
+		}
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("config: rate_limits.max_attempts: must not be negative")
+	}
+	if c.BackupKeep < 0 {
+		return fmt.Errorf("config: backup.keep: must not be negative")
+	}
+	if c.RetentionMaxItems < 0 {
+		return fmt.Errorf("config: retention.max_items: must not be negative")
+	}
+	if c.LogLevel != "" {
+		if _, err := parseLevel(c.LogLevel); err != nil {
+			return fmt.Errorf("config: log.level: %w", err)
+		}
+	}
+	switch c.LogFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("config: log.format: invalid format %q, valid formats are text and json", c.LogFormat)
+	}
+	for key, text := range map[string]string{
+		"templates.new":        c.NewTemplate,
+		"templates.price_drop": c.PriceDropTemplate,
+	} {
+		if _, err := template.New(key).Parse(text); err != nil {
+			return fmt.Errorf("config: %s: %w", key, err)
+		}
+	}
+	switch c.ObsoleteSearches {
+	case "", actionPause, actionRemove:
+	default:
+		return fmt.Errorf("config: obsolete_searches: invalid value %q, valid values are pause and remove", c.ObsoleteSearches)
+	}
+	names := make(map[string]bool)
+	for i, n := range c.Notifiers {
+		if n.Name == "" {
+			return fmt.Errorf("config: notifiers[%d].name: required", i)
+		}
+		if names[n.Name] {
+			return fmt.Errorf("config: notifiers[%d].name: duplicated notifier %s", i, n.Name)
+		}
+		names[n.Name] = true
+		if n.Type == "" {
+			return fmt.Errorf("config: notifiers[%d].type: required", i)
+		}
+	}
+	names = make(map[string]bool)
+	keys := make(map[string]bool)
+	for i, s := range c.Searches {
+		parsed, err := s.parsed()
+		if err != nil {
+			return fmt.Errorf("config: searches[%d].%w", i, err)
+		}
+		if keys[parsed.id] {
+			return fmt.Errorf("config: searches[%d].query: duplicated search %s", i, parsed.id)
+		}
+		keys[parsed.id] = true
+		if s.Name != "" {
+			if names[s.Name] {
+				return fmt.Errorf("config: searches[%d].name: duplicated name %s", i, s.Name)
+			}
+			names[s.Name] = true
+		}
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the configuration with zero intervals and
+// limits replaced by their defaults.
+func (c *Config) withDefaults() *Config {
+	cfg := *c
+	for _, d := range []struct {
+		dst *time.Duration
+		def time.Duration
+	}{
+		{&cfg.SearchInterval, defaultSearchInterval},
+		{&cfg.RequestInterval, defaultRequestInterval},
+		{&cfg.GlobalInterval, globalInterval},
+		{&cfg.PrivateInterval, privateInterval},
+		{&cfg.GroupInterval, groupInterval},
+		{&cfg.BackupInterval, defaultBackupInterval},
+		{&cfg.PruneInterval, defaultPruneInterval},
+	} {
+		if *d.dst == 0 {
+			*d.dst = d.def
+		}
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = maxAttempts
+	}
+	if cfg.BackupKeep == 0 {
+		cfg.BackupKeep = defaultBackupKeep
+	}
+	return &cfg
+}
+
+// parsed returns the search arguments of a configured search.
+func (s SearchConfig) parsed() (parsedArgs, error) {
+	if s.Chat == "" {
+		return parsedArgs{}, fmt.Errorf("chat: required")
+	}
+	if s.Query == "" {
+		return parsedArgs{}, fmt.Errorf("query: required")
+	}
+	if strings.ContainsAny(s.Name, "/? ") {
+		return parsedArgs{}, fmt.Errorf("name: invalid search name %q", s.Name)
+	}
+	if s.MaxAge < 0 {
+		return parsedArgs{}, fmt.Errorf("max_age: must not be negative")
+	}
+	if s.MaxItems < 0 {
+		return parsedArgs{}, fmt.Errorf("max_items: must not be negative")
+	}
+	parsed, err := parseArgs(fmt.Sprintf("%s/%s", s.Chat, s.Query), "")
+	if err != nil {
+		return parsedArgs{}, fmt.Errorf("query: %w", err)
+	}
+	parsed.name = s.Name
+	for _, t := range s.Tags {
+		parsed.tags = append(parsed.tags, strings.ToLower(t))
+	}
+	for _, t := range s.Targets {
+		parsed.targets = append(parsed.targets, strings.ToLower(t))
+	}
+	return parsed, nil
+}
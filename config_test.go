@@ -0,0 +1,166 @@
+package wallabot
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a configuration file with the extension and returns its
+// path.
+func writeConfig(t *testing.T, ext, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "wallabot"+ext)
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigEnv(t *testing.T) {
+	files := map[string]string{
+		".yaml": `
+token: file-token
+admin: 1
+users: [2, 3]
+search_interval: 10s
+rate_limits:
+  private: 2s
+retention:
+  max_items: 10
+searches:
+  - chat: "2"
+    query: bike
+`,
+		".toml": `
+token = "file-token"
+admin = 1
+users = [2, 3]
+search_interval = "10s"
+
+[rate_limits]
+private = "2s"
+
+[retention]
+max_items = 10
+
+[[searches]]
+chat = "2"
+query = "bike"
+`,
+	}
+	for ext, data := range files {
+		t.Run(ext, func(t *testing.T) {
+			path := writeConfig(t, ext, data)
+
+			// Environment variables take precedence over the file
+			t.Setenv("WALLABOT_TOKEN", "env-token")
+			t.Setenv("WALLABOT_USERS", "4, 5")
+			t.Setenv("WALLABOT_RATE_LIMITS_PRIVATE", "3s")
+			t.Setenv("WALLABOT_RETENTION_MAX_ITEMS", "20")
+			cfg, err := LoadConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Token != "env-token" || cfg.PrivateInterval != 3*time.Second || cfg.RetentionMaxItems != 20 {
+				t.Errorf("got token %s, private %s and max items %d, want the environment values",
+					cfg.Token, cfg.PrivateInterval, cfg.RetentionMaxItems)
+			}
+			if len(cfg.Users) != 2 || cfg.Users[0] != 4 || cfg.Users[1] != 5 {
+				t.Errorf("got users %v, want [4 5]", cfg.Users)
+			}
+
+			// Keys not set in the environment keep the file values
+			if cfg.Admin != 1 || cfg.SearchInterval != 10*time.Second || cfg.DB != defaultDB {
+				t.Errorf("got admin %d, search interval %s and db %s, want the file values",
+					cfg.Admin, cfg.SearchInterval, cfg.DB)
+			}
+			if len(cfg.Searches) != 1 || cfg.Searches[0].Query != "bike" {
+				t.Errorf("got searches %+v, want the file ones", cfg.Searches)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		ext  string
+		data string
+		env  map[string]string
+		want string
+	}{
+		{"unknown yaml key", ".yaml", "tokn: x", nil, "tokn"},
+		{"unknown toml key", ".toml", `tokn = "x"`, nil, "tokn: unknown key"},
+		{"extension", ".json", "{}", nil, "unsupported file extension"},
+		{"duration", ".yaml", "search_interval: 5", nil, "search_interval: invalid duration"},
+		{"env integer", ".yaml", "", map[string]string{"WALLABOT_ADMIN": "me"}, "admin: invalid integer"},
+		{"env list", ".yaml", "", map[string]string{"WALLABOT_USERS": "2,me"}, "users: invalid integer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := LoadConfig(writeConfig(t, tt.ext, tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{Token: "token", DB: defaultDB, Admin: 1}
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatal(err)
+	}
+	webhook := valid()
+	webhook.Listen, webhook.WebhookURL, webhook.WebhookSecret = ":8080", "https://example.com/telegram", "s3cret_-"
+	if err := webhook.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		want   string
+		modify func(c *Config)
+	}{
+		{"token: required", func(c *Config) { c.Token = "" }},
+		{"users[1]: must be a positive chat id", func(c *Config) { c.Users = []int{2, 0} }},
+		{"listen: required for webhook_url", func(c *Config) { c.WebhookURL = "https://example.com/telegram" }},
+		{"webhook_url: webhook url https://example.com/ must have a path", func(c *Config) {
+			c.Listen, c.WebhookURL = ":8080", "https://example.com/"
+		}},
+		{"webhook_url: webhook url https://example.com must have a path", func(c *Config) {
+			c.Listen, c.WebhookURL = ":8080", "https://example.com"
+		}},
+		{"webhook_url: webhook path /api/v1/telegram is reserved", func(c *Config) {
+			c.Listen, c.WebhookURL = ":8080", "https://example.com/api/v1/telegram"
+		}},
+		{"webhook_secret: must be 1-256 characters", func(c *Config) { c.WebhookSecret = "my secret" }},
+		{"webhook_secret: must be 1-256 characters", func(c *Config) { c.WebhookSecret = strings.Repeat("s", 257) }},
+		{"tls_cert, tls_key", func(c *Config) { c.TLSCert = "cert.pem" }},
+		{"rate_limits.group: must not be negative", func(c *Config) { c.GroupInterval = -time.Second }},
+		{"obsolete_searches: invalid value", func(c *Config) { c.ObsoleteSearches = "delete" }},
+		{"searches[0].chat: required", func(c *Config) { c.Searches = []SearchConfig{{Query: "bike"}} }},
+		{"searches[1].query: duplicated search 2/bike", func(c *Config) {
+			c.Searches = []SearchConfig{{Chat: "2", Query: "bike"}, {Chat: "2", Query: "bike"}}
+		}},
+		{"searches[1].name: duplicated name bikes", func(c *Config) {
+			c.Searches = []SearchConfig{{Name: "bikes", Chat: "2", Query: "bike"}, {Name: "bikes", Chat: "3", Query: "bike"}}
+		}},
+	}
+	for _, tt := range tests {
+		cfg := valid()
+		tt.modify(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+}
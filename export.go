@@ -0,0 +1,341 @@
+package wallabot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// Item export formats.
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// csvHeader contains the columns of the csv item export.
+var csvHeader = []string{"search", "search_name", "id", "title", "link", "price", "previous_price",
+	"created_at", "seen_at", "gone_at", "history"}
+
+// ItemFilter selects the items to export, zero values disable each filter.
+type ItemFilter struct {
+	// Search is the key or name of a search, all searches if empty
+	Search string
+	// Since and Until select the items listed at some time within the
+	// range: items seen since Since and created before Until
+	Since time.Time
+	Until time.Time
+	// MinPrice and MaxPrice select the items by their current price, they
+	// must be positive to be set
+	MinPrice float64
+	MaxPrice float64
+}
+
+// match returns true if the item passes the filter.
+func (f ItemFilter) match(i api.Item) bool {
+	if !f.Since.IsZero() && i.SeenAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !i.CreatedAt.IsZero() && !i.CreatedAt.Before(f.Until) {
+		return false
+	}
+	if f.MinPrice > 0 && i.Price < f.MinPrice {
+		return false
+	}
+	if f.MaxPrice > 0 && i.Price > f.MaxPrice {
+		return false
+	}
+	return true
+}
+
+// ParsePriceRange parses the bounds of a price range, empty bounds are zero.
+// Bounds must be positive as zero disables them.
+func ParsePriceRange(min, max string) (float64, float64, error) {
+	var bounds [2]float64
+	for n, s := range []string{min, max} {
+		if s == "" {
+			continue
+		}
+		p, err := strconv.ParseFloat(s, 64)
+		if err != nil || p <= 0 {
+			return 0, 0, fmt.Errorf("invalid price %q, it must be positive", s)
+		}
+		bounds[n] = p
+	}
+	if bounds[0] > 0 && bounds[1] > 0 && bounds[0] > bounds[1] {
+		return 0, 0, fmt.Errorf("invalid price range %s - %s", min, max)
+	}
+	return bounds[0], bounds[1], nil
+}
+
+// ParseDateRange parses the bounds of a date range with format 2006-01-02 or
+// RFC 3339, empty bounds are zero. Dates without time include the whole day.
+func ParseDateRange(since, until string) (time.Time, time.Time, error) {
+	var start, end time.Time
+	if since != "" {
+		t, _, err := parseDate(since)
+		if err != nil {
+			return start, end, err
+		}
+		start = t
+	}
+	if until != "" {
+		t, day, err := parseDate(until)
+		if err != nil {
+			return start, end, err
+		}
+		if day {
+			t = t.AddDate(0, 0, 1)
+		}
+		end = t
+	}
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return start, end, fmt.Errorf("invalid date range %s - %s", since, until)
+	}
+	return start, end, nil
+}
+
+// parseDate parses a date and returns whether it is a whole day.
+func parseDate(s string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid date %q, use 2006-01-02 or RFC 3339", s)
+	}
+	return t, false, nil
+}
+
+// ExportItems writes the items of the searches stored in the database to w
+// with the format. The database is opened read-only. It returns the number of
+// items written.
+func ExportItems(path string, w io.Writer, format string, filter ItemFilter) (int, error) {
+	db, err := store.OpenReadOnly(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	keys, err := db.Searches()
+	if err != nil {
+		return 0, err
+	}
+	names := make(map[string]string)
+	for _, k := range keys {
+		var info searchInfo
+		if err := db.Get("meta", k, &info); err != nil {
+			return 0, err
+		}
+		names[k] = info.Name
+	}
+	return writeItems(db, w, format, filter, names)
+}
+
+// exportedItem is an item of the json lines export.
+type exportedItem struct {
+	Search     string `json:"search"`
+	SearchName string `json:"search_name,omitempty"`
+	api.Item
+}
+
+// writeItems writes the items of the searches, a map of keys to names, that
+// pass the filter. It returns the number of items written.
+func writeItems(db store.Store, w io.Writer, format string, filter ItemFilter, searches map[string]string) (int, error) {
+	var keys []string
+	for k, name := range searches {
+		if filter.Search != "" && filter.Search != k && filter.Search != name {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if filter.Search != "" && len(keys) == 0 {
+		return 0, fmt.Errorf("search %s not found", filter.Search)
+	}
+	sort.Strings(keys)
+
+	var write func(key string, i api.Item) error
+	var flush func() error
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return 0, fmt.Errorf("couldn't write csv: %w", err)
+		}
+		write = func(key string, i api.Item) error {
+			return cw.Write(csvRecord(key, searches[key], i))
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		write = func(key string, i api.Item) error {
+			return enc.Encode(exportedItem{Search: key, SearchName: searches[key], Item: i})
+		}
+		flush = func() error { return nil }
+	default:
+		return 0, fmt.Errorf("unknown export format %q, valid formats are %s and %s", format, FormatCSV, FormatJSONL)
+	}
+
+	n := 0
+	for _, k := range keys {
+		items, err := db.Items(k)
+		if err != nil {
+			return n, err
+		}
+		ids := make([]string, 0, len(items))
+		for id := range items {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			i := items[id]
+			if !filter.match(i) {
+				continue
+			}
+			if err := write(k, i); err != nil {
+				return n, fmt.Errorf("couldn't write item %s: %w", id, err)
+			}
+			n++
+		}
+	}
+	if err := flush(); err != nil {
+		return n, fmt.Errorf("couldn't write %s: %w", format, err)
+	}
+	return n, nil
+}
+
+// csvRecord returns the csv columns of an item, the price history is a list
+// of time=price pairs separated by semicolons.
+func csvRecord(key, name string, i api.Item) []string {
+	history := make([]string, 0, len(i.History))
+	for _, p := range i.History {
+		history = append(history, fmt.Sprintf("%s=%s", csvTime(p.Time), csvPrice(p.Price)))
+	}
+	return []string{csvText(key), csvText(name), csvText(i.ID), csvText(i.Title), csvText(i.Link),
+		csvPrice(i.Price), csvPrice(i.PreviousPrice), csvTime(i.CreatedAt), csvTime(i.SeenAt), csvTime(i.GoneAt),
+		strings.Join(history, ";")}
+}
+
+// csvText escapes text taken from users or listings so spreadsheets don't
+// evaluate it as a formula.
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func csvPrice(p float64) string {
+	return strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// exportItems handles the /exportitems command with format
+// [format=csv|jsonl] [since=date] [until=date] [min=price] [max=price] [search]
+// and sends the items as a document. The dates select the items listed at
+// some time within the range, seen since and created before them, and prices
+// must be positive. Only the admin can export the searches of other chats.
+func (b *bot) exportItems(user int, chat string, args string) {
+	format := FormatCSV
+	var filter ItemFilter
+	var since, until, min, max string
+	fields := strings.Fields(args)
+options:
+	for len(fields) > 0 {
+		k, v, _ := strings.Cut(fields[0], "=")
+		switch k {
+		case "format":
+			format = v
+		case "since":
+			since = v
+		case "until":
+			until = v
+		case "min":
+			min = v
+		case "max":
+			max = v
+		default:
+			// The remaining fields are the search
+			break options
+		}
+		fields = fields[1:]
+	}
+	if format != FormatCSV && format != FormatJSONL {
+		b.message(user, fmt.Sprintf("invalid format %q, use %s or %s", format, FormatCSV, FormatJSONL))
+		return
+	}
+	var err error
+	if filter.Since, filter.Until, err = ParseDateRange(since, until); err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	if filter.MinPrice, filter.MaxPrice, err = ParsePriceRange(min, max); err != nil {
+		b.message(user, err.Error())
+		return
+	}
+
+	searches := make(map[string]string)
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		key := k.(string)
+		if user == b.admin || strings.HasPrefix(key, chat+"/") {
+			searches[key] = v.(searchInfo).Name
+		}
+		return true
+	})
+	if len(fields) > 0 {
+		parsed, err := b.lookup(strings.Join(fields, " "), chat)
+		if err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		if _, ok := searches[parsed.id]; !ok {
+			b.message(user, fmt.Sprintf("search %s not found", parsed.label()))
+			return
+		}
+		filter.Search = parsed.id
+	}
+
+	var buf bytes.Buffer
+	n, err := writeItems(b.db, &buf, format, filter, searches)
+	if err != nil {
+		slog.Error("couldn't export items", "user", user, "err", err)
+		b.message(user, fmt.Sprintf("couldn't export items: %v", err))
+		return
+	}
+	if n == 0 {
+		b.message(user, "no items to export")
+		return
+	}
+	if buf.Len() > backupLimit {
+		b.message(user, fmt.Sprintf("export is too big to be sent (%d MB), narrow the filters or use: wallabot items export", buf.Len()>>20))
+		return
+	}
+	doc := tgbot.NewDocumentUpload(int64(user), tgbot.FileBytes{
+		Name:  fmt.Sprintf("wallabot-items-%s.%s", time.Now().UTC().Format(backupLayout), format),
+		Bytes: buf.Bytes(),
+	})
+	doc.Caption = fmt.Sprintf("%d items", n)
+	if _, err := b.Send(doc); err != nil {
+		slog.Error("couldn't send items export", "user", user, "err", err)
+		return
+	}
+	slog.Info("items exported", "user", user, "items", n, "format", format)
+}
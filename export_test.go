@@ -0,0 +1,179 @@
+package wallabot
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+func TestCSVRecord(t *testing.T) {
+	i := api.Item{ID: "@id", Title: "=HYPERLINK(\"http://evil\")", Link: "+link", Price: -1}
+	record := csvRecord("-1/bike", "\tname", i)
+	want := map[int]string{
+		0: "'-1/bike",
+		1: "'\tname",
+		2: "'@id",
+		3: "'=HYPERLINK(\"http://evil\")",
+		4: "'+link",
+		5: "-1",
+	}
+	for n, v := range want {
+		if record[n] != v {
+			t.Errorf("column %s = %q, want %q", csvHeader[n], record[n], v)
+		}
+	}
+	if got := csvRecord("1/bike", "bikes", api.Item{ID: "1", Title: "road bike"}); got[1] != "bikes" || got[3] != "road bike" {
+		t.Errorf("plain text was escaped: %q", got)
+	}
+
+	// The escaped cells are valid csv
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	w.Flush()
+	read, err := csv.NewReader(&buf).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(read, ",") != strings.Join(record, ",") {
+		t.Errorf("got %q, want %q", read, record)
+	}
+}
+
+func TestExportItemsError(t *testing.T) {
+	b := newTestBot(t)
+	b.db = failingStore{Store: b.db}
+
+	b.exportItems(testAlice, "2", "")
+	if n := b.queue.depth(); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	if o := b.queue.pending[0]; o.Chat != "2" || !strings.Contains(o.Text, "disk failure") {
+		t.Errorf("unexpected message to %s: %q", o.Chat, o.Text)
+	}
+}
+
+func TestExportReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := store.NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddSearch("2/bike"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PutItems("2/bike", map[string]api.Item{"a1": {ID: "a1", Title: "bike"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put("meta", "2/bike", searchInfo{Name: "bikes"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The database is locked while the bot is running
+	if _, err := ExportSearches(path); err == nil || !strings.Contains(err.Error(), "is the bot running?") {
+		t.Errorf("got error %v, want a timeout", err)
+	}
+	if _, err := ExportItems(path, io.Discard, FormatJSONL, ItemFilter{}); err == nil {
+		t.Error("export of a locked database didn't fail")
+	}
+	db.Close()
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	searches, err := ExportSearches(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 1 || searches[0].Name != "bikes" {
+		t.Errorf("got searches %+v, want bikes", searches)
+	}
+	if n, err := ExportItems(path, io.Discard, FormatJSONL, ItemFilter{}); err != nil || n != 1 {
+		t.Errorf("exported %d items (%v), want 1", n, err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("export modified the database")
+	}
+
+	// Missing databases aren't created
+	missing := filepath.Join(t.TempDir(), "missing.db")
+	if _, err := ExportSearches(missing); err == nil {
+		t.Error("export of a missing database didn't fail")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("missing database was created: %v", err)
+	}
+}
+
+func TestParsePriceRange(t *testing.T) {
+	for _, tt := range []struct {
+		min, max string
+		want     [2]float64
+		err      bool
+	}{
+		{"", "", [2]float64{}, false},
+		{"50", "", [2]float64{50, 0}, false},
+		{"", "300.5", [2]float64{0, 300.5}, false},
+		{"50", "50", [2]float64{50, 50}, false},
+		{"0", "", [2]float64{}, true},
+		{"", "0", [2]float64{}, true},
+		{"-1", "", [2]float64{}, true},
+		{"cheap", "", [2]float64{}, true},
+		{"300", "50", [2]float64{}, true},
+	} {
+		min, max, err := ParsePriceRange(tt.min, tt.max)
+		if (err != nil) != tt.err || [2]float64{min, max} != tt.want {
+			t.Errorf("%q-%q: got %v %v (%v)", tt.min, tt.max, min, max, err)
+		}
+	}
+
+	// A zero bound isn't accepted silently disabling the filter
+	b := newTestBot(t)
+	b.exportItems(testAlice, "2", "max=0")
+	if o := b.queue.pending[0]; !strings.Contains(o.Text, "must be positive") {
+		t.Errorf("unexpected message %q", o.Text)
+	}
+}
+
+func TestItemFilter(t *testing.T) {
+	since, until, err := ParseDateRange("2024-01-10", "2024-01-20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter := ItemFilter{Since: since, Until: until}
+	day := func(d int) time.Time {
+		return time.Date(2024, 1, d, 12, 0, 0, 0, time.UTC)
+	}
+	// Items listed at some time within the range pass the filter
+	for _, tt := range []struct {
+		created, seen int
+		want          bool
+	}{
+		{1, 5, false},
+		{1, 15, true},
+		{12, 15, true},
+		{15, 25, true},
+		{20, 25, true},
+		{21, 25, false},
+	} {
+		i := api.Item{CreatedAt: day(tt.created), SeenAt: day(tt.seen)}
+		if got := filter.match(i); got != tt.want {
+			t.Errorf("item listed from %d to %d: match = %v, want %v", tt.created, tt.seen, got, tt.want)
+		}
+	}
+}
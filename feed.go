@@ -0,0 +1,300 @@
+package wallabot
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// feedSize is the maximum number of entries of a feed.
+const feedSize = 100
+
+// feedEntry is a feed entry for a new item or a price drop.
+type feedEntry struct {
+	id    string
+	title string
+	text  string
+	link  string
+	image string
+	time  time.Time
+}
+
+// feedToken returns the token that identifies a feed in its url.
+func feedToken(secret []byte, kind, id string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(kind + ":" + id))
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// feedURLs returns the atom and json feed urls of a search or a chat.
+func (b *bot) feedURLs(kind, id string) (string, string, error) {
+	if b.publicURL == "" {
+		return "", "", fmt.Errorf("public url not configured")
+	}
+	secret, err := b.secret("feed_secret")
+	if err != nil {
+		return "", "", err
+	}
+	base := fmt.Sprintf("%s/feeds/%s/%s", b.publicURL, kind, feedToken(secret, kind, id))
+	return base + ".atom", base + ".json", nil
+}
+
+// feedHandler serves atom and json feeds with format
+// /feeds/{search|chat}/<token>.{atom|json}
+func (b *bot) feedHandler(w http.ResponseWriter, r *http.Request) {
+	split := strings.Split(strings.TrimPrefix(r.URL.Path, "/feeds/"), "/")
+	if len(split) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	kind := split[0]
+	token := split[1]
+	var format string
+	for _, f := range []string{"atom", "json"} {
+		if strings.HasSuffix(token, "."+f) {
+			format = f
+			token = strings.TrimSuffix(token, "."+f)
+		}
+	}
+	if format == "" || (kind != "search" && kind != "chat") {
+		http.NotFound(w, r)
+		return
+	}
+	secret, err := b.secret("feed_secret")
+	if err != nil {
+		slog.Error("couldn't get feed secret", "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	// Find the searches that belong to the feed
+	var title string
+	var keys []string
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		key := k.(string)
+		parsed, err := parseArgs(key, "")
+		if err != nil {
+			return true
+		}
+		id := key
+		if kind == "chat" {
+			id = parsed.chat
+		}
+		if hmac.Equal([]byte(feedToken(secret, kind, id)), []byte(token)) {
+			keys = append(keys, key)
+			title = v.(searchInfo).label(key)
+			if kind == "chat" {
+				title = parsed.chat
+			}
+		}
+		return true
+	})
+	if len(keys) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Items matching several searches of a chat are added once
+	var entries []feedEntry
+	added := make(map[string]bool)
+	for _, k := range keys {
+		items, err := b.db.Items(k)
+		if err != nil {
+			slog.Error("couldn't get search items", "search", k, "err", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		for _, i := range items {
+			for _, e := range itemEntries(i) {
+				if !added[e.id] {
+					added[e.id] = true
+					entries = append(entries, e)
+				}
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].time.Equal(entries[j].time) {
+			return entries[i].id > entries[j].id
+		}
+		return entries[i].time.After(entries[j].time)
+	})
+	if len(entries) > feedSize {
+		entries = entries[:feedSize]
+	}
+
+	// Conditional requests
+	var updated time.Time
+	h := sha1.New()
+	for _, e := range entries {
+		fmt.Fprintf(h, "%s/%d\n", e.id, e.time.UnixNano())
+		if e.time.After(updated) {
+			updated = e.time
+		}
+	}
+	etag := fmt.Sprintf(`"%s-%s"`, format, hex.EncodeToString(h.Sum(nil)))
+	w.Header().Set("ETag", etag)
+	if !updated.IsZero() {
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !updated.Truncate(time.Second).After(since) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	self := fmt.Sprintf("%s%s", b.publicURL, r.URL.Path)
+	title = fmt.Sprintf("wallabot: %s", title)
+	switch format {
+	case "atom":
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		writeAtom(w, title, self, updated, entries)
+	case "json":
+		w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+		writeJSONFeed(w, title, self, entries)
+	}
+}
+
+// itemEntries returns the entry of a new item and an entry per price drop.
+func itemEntries(i api.Item) []feedEntry {
+	entries := []feedEntry{{
+		id:    i.ID,
+		title: fmt.Sprintf("‼️ %s - %.2f€", i.Title, i.Price),
+		text:  fmt.Sprintf("%s\n\nPrecio: %.2f€", i.Title, i.Price),
+		link:  i.Link,
+		image: i.Image,
+		time:  i.CreatedAt,
+	}}
+	if len(i.History) > 0 {
+		entries[0].title = fmt.Sprintf("‼️ %s - %.2f€", i.Title, i.History[0].Price)
+		entries[0].text = fmt.Sprintf("%s\n\nPrecio: %.2f€", i.Title, i.History[0].Price)
+	}
+	for n := 1; n < len(i.History); n++ {
+		prev, curr := i.History[n-1], i.History[n]
+		if curr.Price >= prev.Price {
+			continue
+		}
+		entries = append(entries, feedEntry{
+			id:    fmt.Sprintf("%s/%d", i.ID, curr.Time.Unix()),
+			title: fmt.Sprintf("⚡️ %s - %.2f€ (antes %.2f€)", i.Title, curr.Price, prev.Price),
+			text:  fmt.Sprintf("%s\n\nPrecio: %.2f€\nAnterior: %.2f€", i.Title, curr.Price, prev.Price),
+			link:  i.Link,
+			image: i.Image,
+			time:  curr.Time,
+		})
+	}
+	return entries
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  atomAuthor  `xml:"author"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// atomAuthor is the author of the feed, required by atom for entries
+// without their own.
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+func writeAtom(w http.ResponseWriter, title, self string, updated time.Time, entries []feedEntry) {
+	feed := atomFeed{
+		ID:      self,
+		Title:   title,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Author:  atomAuthor{Name: "wallabot"},
+		Link:    atomLink{Href: self, Rel: "self"},
+	}
+	for _, e := range entries {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("urn:wallabot:%s", e.id),
+			Title:   e.title,
+			Updated: e.time.UTC().Format(time.RFC3339),
+			Link:    atomLink{Href: e.link},
+			Summary: e.text,
+		})
+	}
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	_ = enc.Encode(feed)
+}
+
+type jsonFeed struct {
+	Version string         `json:"version"`
+	Title   string         `json:"title"`
+	FeedURL string         `json:"feed_url"`
+	Items   []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title"`
+	ContentText   string `json:"content_text"`
+	Image         string `json:"image,omitempty"`
+	DatePublished string `json:"date_published"`
+}
+
+func writeJSONFeed(w http.ResponseWriter, title, self string, entries []feedEntry) {
+	feed := jsonFeed{
+		Version: "https://jsonfeed.org/version/1.1",
+		Title:   title,
+		FeedURL: self,
+		Items:   []jsonFeedItem{},
+	}
+	for _, e := range entries {
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            e.id,
+			URL:           e.link,
+			Title:         e.title,
+			ContentText:   e.text,
+			Image:         e.image,
+			DatePublished: e.time.UTC().Format(time.RFC3339),
+		})
+	}
+	_ = json.NewEncoder(w).Encode(feed)
+}
+
+// feeds sends the feed urls of a search or a chat to the user.
+func (b *bot) feeds(user int, kind, id, label string) {
+	atom, jsonURL, err := b.feedURLs(kind, id)
+	if err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	b.messageOpts(user, fmt.Sprintf("feeds for %s\n\natom: %s\njson: %s", label, atom, jsonURL), false, nil)
+}
@@ -0,0 +1,166 @@
+package wallabot
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// newFeedBot returns a test bot with an item shared by two searches of the
+// chat of alice, its price dropped after being listed.
+func newFeedBot(t *testing.T) (*bot, time.Time) {
+	t.Helper()
+	b := newTestBot(t)
+	b.publicURL = "https://wallabot.example.com"
+	parsed, err := parseArgs("2/lamp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.add(parsed); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	dropped := created.Add(time.Hour)
+	shared := api.Item{ID: "a1", Title: "lamp bike", Link: "https://example.com/a1", Price: 80, CreatedAt: created,
+		History: []api.Price{{Price: 100, Time: created}, {Price: 80, Time: dropped}}}
+	for key, items := range map[string]map[string]api.Item{
+		"2/bike": {"a1": shared, "a2": {ID: "a2", Title: "bike", Price: 50, CreatedAt: created}},
+		"2/lamp": {"a1": shared},
+		"3/car":  {"c1": {ID: "c1", Title: "car", Price: 5000, CreatedAt: created}},
+	} {
+		if err := b.db.PutItems(key, items); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b, dropped
+}
+
+// feedPath returns the path of the feed with the format.
+func feedPath(t *testing.T, b *bot, kind, id, format string) string {
+	t.Helper()
+	atom, jsonURL, err := b.feedURLs(kind, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := jsonURL
+	if format == "atom" {
+		u = atom
+	}
+	return strings.TrimPrefix(u, b.publicURL)
+}
+
+func getFeed(b *bot, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	b.feedHandler(rec, req)
+	return rec
+}
+
+func TestFeedToken(t *testing.T) {
+	b, _ := newFeedBot(t)
+	search := feedPath(t, b, "search", "2/bike", "json")
+	if rec := getFeed(b, search, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	for _, path := range []string{
+		"/feeds/search/00000000000000000000000000000000.json",
+		strings.Replace(search, "/search/", "/chat/", 1),
+		strings.Replace(search, ".json", ".rss", 1),
+		strings.Replace(search, "/search/", "/user/", 1),
+		"/feeds/search",
+	} {
+		if rec := getFeed(b, path, nil); rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", path, rec.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestFeedChat(t *testing.T) {
+	b, dropped := newFeedBot(t)
+
+	// Items of several searches are added once, with an entry per price drop
+	rec := getFeed(b, feedPath(t, b, "chat", "2", "json"), nil)
+	var feed jsonFeed
+	if err := json.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, i := range feed.Items {
+		ids = append(ids, i.ID)
+	}
+	if strings.Join(ids, ",") != "a1/"+formatUnix(dropped)+",a2,a1" {
+		t.Fatalf("got entries %v, want the price drop of a1, a2 and a1", ids)
+	}
+	if title := feed.Items[0].Title; title != "⚡️ lamp bike - 80.00€ (antes 100.00€)" {
+		t.Errorf("unexpected price drop title %q", title)
+	}
+	if title := feed.Items[2].Title; title != "‼️ lamp bike - 100.00€" {
+		t.Errorf("new item title %q, want the listed price", title)
+	}
+
+	rec = getFeed(b, feedPath(t, b, "chat", "2", "atom"), nil)
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("content type = %q", ct)
+	}
+	var atom atomFeed
+	if err := xml.Unmarshal(rec.Body.Bytes(), &atom); err != nil {
+		t.Fatal(err)
+	}
+	if atom.Author.Name == "" {
+		t.Error("atom feed without author")
+	}
+	if len(atom.Entries) != 3 || atom.Entries[0].ID != "urn:wallabot:a1/"+formatUnix(dropped) {
+		t.Errorf("got atom entries %+v", atom.Entries)
+	}
+	if atom.Updated != dropped.Format(time.RFC3339) {
+		t.Errorf("updated = %s, want %s", atom.Updated, dropped.Format(time.RFC3339))
+	}
+}
+
+func TestFeedConditional(t *testing.T) {
+	b, dropped := newFeedBot(t)
+	path := feedPath(t, b, "search", "2/bike", "atom")
+	rec := getFeed(b, path, nil)
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") != dropped.Format(http.TimeFormat) {
+		t.Fatalf("got etag %q and last modified %q", etag, rec.Header().Get("Last-Modified"))
+	}
+	for _, tt := range []struct {
+		name   string
+		header http.Header
+		want   int
+	}{
+		{"etag", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+		{"other etag", http.Header{"If-None-Match": {`"atom-0"`}}, http.StatusOK},
+		{"modified since", http.Header{"If-Modified-Since": {dropped.Format(http.TimeFormat)}}, http.StatusNotModified},
+		{"modified before", http.Header{"If-Modified-Since": {dropped.Add(-time.Second).Format(http.TimeFormat)}}, http.StatusOK},
+		// The etag takes precedence over the date
+		{"both", http.Header{"If-None-Match": {`"atom-0"`}, "If-Modified-Since": {dropped.Format(http.TimeFormat)}}, http.StatusOK},
+	} {
+		if rec := getFeed(b, path, tt.header); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	// New entries change the etag
+	if err := b.db.PutItems("2/bike", map[string]api.Item{"a3": {ID: "a3", Title: "new bike", Price: 20, CreatedAt: dropped.Add(time.Hour)}}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := getFeed(b, path, http.Header{"If-None-Match": {etag}}); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("status = %d with etag %s, want a new etag", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func formatUnix(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
@@ -0,0 +1,199 @@
+package wallabot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Subsystems whose health is tracked.
+const (
+	healthSearch   = "search"
+	healthTelegram = "telegram"
+)
+
+// healthInterval is the interval between health checks.
+const healthInterval = time.Minute
+
+// subsystem contains the last success and error of a subsystem.
+type subsystem struct {
+	LastSuccess time.Time `json:"last_success"`
+	LastError   string    `json:"last_error,omitempty"`
+	ErrorAt     time.Time `json:"error_at"`
+	Stale       string    `json:"stale_after"`
+	Healthy     bool      `json:"healthy"`
+}
+
+// health tracks the last success and error of each subsystem. A subsystem is
+// unhealthy when it hasn't succeeded within its staleness threshold.
+type health struct {
+	lock       sync.Mutex
+	start      time.Time
+	ready      bool
+	degraded   bool
+	thresholds map[string]time.Duration
+	subsystems map[string]*subsystem
+}
+
+func newHealth(searchStale, telegramStale time.Duration) *health {
+	h := &health{
+		start: time.Now(),
+		thresholds: map[string]time.Duration{
+			healthSearch:   searchStale,
+			healthTelegram: telegramStale,
+		},
+		subsystems: make(map[string]*subsystem),
+	}
+	for name := range h.thresholds {
+		h.subsystems[name] = &subsystem{}
+	}
+	return h
+}
+
+// success records a success of the subsystem.
+func (h *health) success(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subsystems[name].LastSuccess = time.Now()
+}
+
+// failure records an error of the subsystem.
+func (h *health) failure(name string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subsystems[name].LastError = err.Error()
+	h.subsystems[name].ErrorAt = time.Now()
+}
+
+// setThresholds changes the staleness thresholds of the subsystems.
+func (h *health) setThresholds(searchStale, telegramStale time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.thresholds[healthSearch] = searchStale
+	h.thresholds[healthTelegram] = telegramStale
+}
+
+// setReady marks the bot as ready to process updates.
+func (h *health) setReady() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.ready = true
+}
+
+// report returns the state of each subsystem and whether all are healthy.
+// Subsystems that never succeeded are measured from the start of the bot.
+func (h *health) report() (map[string]subsystem, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	now := time.Now()
+	healthy := true
+	report := make(map[string]subsystem)
+	for name, s := range h.subsystems {
+		r := *s
+		last := r.LastSuccess
+		if last.IsZero() {
+			last = h.start
+		}
+		stale := h.thresholds[name]
+		r.Stale = stale.String()
+		r.Healthy = stale <= 0 || now.Sub(last) < stale
+		if !r.Healthy {
+			healthy = false
+		}
+		report[name] = r
+	}
+	return report, healthy
+}
+
+// healthHandler serves /healthz, which fails when a subsystem is stale.
+func (b *bot) healthHandler(w http.ResponseWriter, r *http.Request) {
+	report, healthy := b.health.report()
+	status := http.StatusOK
+	if !healthy {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"healthy":    healthy,
+		"subsystems": report,
+	})
+}
+
+// readyHandler serves /readyz, which fails until the bot is processing
+// updates or while telegram is unreachable.
+func (b *bot) readyHandler(w http.ResponseWriter, r *http.Request) {
+	report, _ := b.health.report()
+	b.health.lock.Lock()
+	ready := b.health.ready
+	b.health.lock.Unlock()
+	ready = ready && report[healthTelegram].Healthy
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]interface{}{"ready": ready})
+}
+
+// monitor checks telegram periodically and alerts the admin once when health
+// degrades and again when it recovers.
+func (b *bot) monitor(ctx context.Context) {
+	for {
+		if _, err := b.GetMe(); err != nil {
+			b.health.failure(healthTelegram, fmt.Errorf("couldn't get bot info: %w", err))
+		} else {
+			b.health.success(healthTelegram)
+		}
+
+		report, healthy := b.health.report()
+		b.health.lock.Lock()
+		changed := b.health.degraded == healthy
+		b.health.degraded = !healthy
+		b.health.lock.Unlock()
+		if changed {
+			text := "✅ wallabot recovered"
+			if healthy {
+				slog.Info("health recovered")
+			} else {
+				text = fmt.Sprintf("🚨 wallabot degraded\n\n%s", healthText(report))
+				slog.Warn("health degraded", "report", healthText(report))
+			}
+			b.queue.push(&outbound{Chat: strconv.Itoa(b.admin), Text: text})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthInterval):
+		}
+	}
+}
+
+// healthText returns a line per unhealthy subsystem.
+func healthText(report map[string]subsystem) string {
+	var names []string
+	for name := range report {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	text := ""
+	for _, name := range names {
+		s := report[name]
+		if s.Healthy {
+			continue
+		}
+		last := "never"
+		if !s.LastSuccess.IsZero() {
+			last = time.Since(s.LastSuccess).Round(time.Second).String() + " ago"
+		}
+		text += fmt.Sprintf("%s: last success %s", name, last)
+		if s.LastError != "" {
+			text += fmt.Sprintf(", last error: %s", s.LastError)
+		}
+		text += "\n"
+	}
+	return text
+}
@@ -14,15 +14,29 @@ import (
 	"time"
 
 	"github.com/igolaizola/wallabot/internal/geo"
+	"github.com/igolaizola/wallabot/internal/metrics"
 )
 
+var requests = metrics.NewCounter("wallabot_api_requests_total",
+	"Requests made to the wallapop api by status code.", "code")
+
 type Item struct {
 	ID            string    `json:"id"`
 	Link          string    `json:"link"`
 	Title         string    `json:"title"`
 	Price         float64   `json:"price"`
 	PreviousPrice float64   `json:"previous_price"`
+	Image         string    `json:"image,omitempty"`
+	History       []Price   `json:"history,omitempty"`
 	CreatedAt     time.Time `json:"created_at"`
+	SeenAt        time.Time `json:"seen_at"`
+	GoneAt        time.Time `json:"gone_at"`
+}
+
+// Price is a price of an item at a given time.
+type Price struct {
+	Price float64   `json:"price"`
+	Time  time.Time `json:"time"`
 }
 
 type response struct {
@@ -37,6 +51,13 @@ type object struct {
 	Description string  `json:"description"`
 	Distance    float64 `json:"distance"`
 	WebSlug     string  `json:"web_slug"`
+	Images      []image `json:"images"`
+}
+
+type image struct {
+	Small    string `json:"small"`
+	Medium   string `json:"medium"`
+	Original string `json:"original"`
 }
 
 type Client struct {
@@ -50,7 +71,8 @@ func New(ctx context.Context) *Client {
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 			Transport: &transport{
-				ctx: ctx,
+				ctx:      ctx,
+				interval: time.Second,
 			},
 		},
 	}
@@ -157,8 +179,10 @@ func (c *Client) search(keywords string, includes, excludes []string, code, km,
 	u := fmt.Sprintf("https://api.wallapop.com/api/v3/general/search?%s", values.Encode())
 	r, err := c.client.Get(u)
 	if err != nil {
+		requests.Inc("error")
 		return 0, fmt.Errorf("api: get request failed: %w", err)
 	}
+	requests.Inc(strconv.Itoa(r.StatusCode))
 	if r.StatusCode == 502 {
 		return 0, errBadGateway
 	}
@@ -191,17 +215,33 @@ func (c *Client) search(keywords string, includes, excludes []string, code, km,
 		}
 		split := strings.Split(obj.WebSlug, "-")
 		id := split[len(split)-1]
+		now := time.Now().UTC()
 		item := Item{
 			ID:            id,
 			Link:          fmt.Sprintf("http://p.wallapop.com/i/%s", id),
 			Title:         obj.Title,
 			Price:         obj.Price,
 			PreviousPrice: -1,
-			CreatedAt:     time.Now().UTC(),
+			CreatedAt:     now,
+			SeenAt:        now,
+		}
+		if len(obj.Images) > 0 {
+			item.Image = obj.Images[0].Medium
+			if item.Image == "" {
+				item.Image = obj.Images[0].Original
+			}
 		}
 		prev, ok := items[item.ID]
 		if ok {
 			item.PreviousPrice = prev.Price
+			item.History = prev.History
+			// Items stored by older versions don't have history
+			if !prev.CreatedAt.IsZero() && len(prev.History) > 0 {
+				item.CreatedAt = prev.CreatedAt
+			}
+		}
+		if !ok || len(item.History) == 0 || item.Price != prev.Price {
+			item.History = append(item.History, Price{Price: item.Price, Time: now})
 		}
 		items[item.ID] = item
 		if !ok || item.Price < prev.Price {
@@ -226,9 +266,18 @@ func fromValues(values url.Values, key string) (int, error) {
 	return v, nil
 }
 
+// SetInterval sets the minimum interval between requests.
+func (c *Client) SetInterval(d time.Duration) {
+	t := c.client.Transport.(*transport)
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.interval = d
+}
+
 type transport struct {
-	lock sync.Mutex
-	ctx  context.Context
+	lock     sync.Mutex
+	ctx      context.Context
+	interval time.Duration
 }
 
 func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -236,7 +285,7 @@ func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
 	defer func() {
 		select {
 		case <-t.ctx.Done():
-		case <-time.After(1000 * time.Millisecond):
+		case <-time.After(t.interval):
 		}
 		t.lock.Unlock()
 	}()
@@ -0,0 +1,246 @@
+// Package metrics implements counters, gauges and histograms exposed with the
+// prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	lock       sync.Mutex
+	names      []string
+	collectors = make(map[string]collector)
+)
+
+// register adds a collector, replacing the previous one with the same name.
+func register(name string, c collector) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := collectors[name]; !ok {
+		names = append(names, name)
+	}
+	collectors[name] = c
+}
+
+// Handler serves all the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+// WriteTo writes all the registered metrics in text format.
+func WriteTo(w io.Writer) {
+	lock.Lock()
+	var cs []collector
+	for _, n := range names {
+		cs = append(cs, collectors[n])
+	}
+	lock.Unlock()
+	bw := bufio.NewWriter(w)
+	for _, c := range cs {
+		c.write(bw)
+	}
+	_ = bw.Flush()
+}
+
+// series stores values by their label values.
+type series struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	lock   sync.Mutex
+	keys   map[string][]string
+}
+
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", s.name, len(s.labels), len(values)))
+	}
+	k := strings.Join(values, "\xff")
+	if _, ok := s.keys[k]; !ok {
+		s.keys[k] = append([]string{}, values...)
+	}
+	return k
+}
+
+// sorted returns the stored keys sorted.
+func (s *series) sorted() []string {
+	var keys []string
+	for k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *series) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", s.name, s.help, s.name, s.kind)
+}
+
+var escape = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelString formats label pairs, extra pairs are appended at the end.
+func (s *series) labelString(values []string, extra ...string) string {
+	var pairs []string
+	for i, l := range s.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escape.Replace(values[i])))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escape.Replace(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	series
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the given label names.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		series: series{name: name, help: help, kind: "counter", labels: labels, keys: make(map[string][]string)},
+		values: make(map[string]float64),
+	}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increments the counter by the given value.
+func (c *Counter) Add(v float64, values ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.values[c.key(values)] += v
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.header(w)
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.name)
+	}
+	for _, k := range c.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelString(c.keys[k]), formatFloat(c.values[k]))
+	}
+}
+
+// GaugeFunc is a value obtained when metrics are collected.
+type GaugeFunc struct {
+	name string
+	help string
+	f    func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by f.
+func NewGaugeFunc(name, help string, f func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, f: f}
+	register(name, g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.f()))
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	series
+	buckets []float64
+	values  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given buckets and label names.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		series:  series{name: name, help: help, kind: "histogram", labels: labels, keys: make(map[string][]string)},
+		buckets: buckets,
+		values:  make(map[string]*histogram),
+	}
+	register(name, h)
+	return h
+}
+
+// Observe adds a value to the histogram.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	k := h.key(values)
+	s, ok := h.values[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.values[k] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+// Since observes the seconds elapsed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.header(w)
+	for _, k := range h.sorted() {
+		values := h.keys[k]
+		s := h.values[k]
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", formatFloat(b)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelString(values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelString(values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelString(values), s.count)
+	}
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
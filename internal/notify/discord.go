@@ -0,0 +1,119 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+func init() {
+	Register("discord", NewDiscord)
+}
+
+// Discord allows 5 requests every 2 seconds per webhook
+const discordInterval = 400 * time.Millisecond
+
+type discordMessage struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []discordEmbed `json:"embeds"`
+}
+
+type discordEmbed struct {
+	Title     string            `json:"title"`
+	URL       string            `json:"url,omitempty"`
+	Color     int               `json:"color"`
+	Fields    []discordField    `json:"fields,omitempty"`
+	Thumbnail *discordThumbnail `json:"thumbnail,omitempty"`
+	Footer    *discordFooter    `json:"footer,omitempty"`
+	Timestamp string            `json:"timestamp,omitempty"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordThumbnail struct {
+	URL string `json:"url"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+// Discord sends events as embeds to a discord webhook.
+type Discord struct {
+	*hook
+}
+
+// NewDiscord creates a discord notifier using a webhook url.
+func NewDiscord(cfg Config) (Notifier, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", cfg.URL, err)
+	}
+	return &Discord{hook: newHook(cfg.Name, cfg.URL, discordInterval, discordRetryAfter)}, nil
+}
+
+func (d *Discord) Send(ctx context.Context, e Event) error {
+	if e.Kind == KindGone {
+		return ErrSkipped
+	}
+	color := 0x13c1ac
+	if e.Kind == KindPriceDrop {
+		color = 0xf5a623
+	}
+	embed := discordEmbed{
+		Title: truncate(e.Item.Title, discordTitleLimit),
+		URL:   e.Item.Link,
+		Color: color,
+		Fields: []discordField{
+			{Name: "Precio", Value: fmt.Sprintf("%.2f€", e.Item.Price), Inline: true},
+		},
+		Footer:    &discordFooter{Text: truncate(fmt.Sprintf("🔎 %s", e.Search.label()), discordFooterLimit)},
+		Timestamp: e.Time.Format(time.RFC3339),
+	}
+	if e.Kind == KindPriceDrop {
+		embed.Fields = append(embed.Fields, discordField{
+			Name: "Anterior", Value: fmt.Sprintf("~~%.2f€~~", e.Item.PreviousPrice), Inline: true,
+		})
+	}
+	if e.Item.Image != "" {
+		embed.Thumbnail = &discordThumbnail{URL: e.Item.Image}
+	}
+	return d.push(discordMessage{
+		Content: Badge(e.Item),
+		Embeds:  []discordEmbed{embed},
+	})
+}
+
+// Discord embed limits, longer embeds are rejected, see
+// https://discord.com/developers/docs/resources/message#embed-object-embed-limits
+const (
+	discordTitleLimit  = 256
+	discordFooterLimit = 2048
+)
+
+// truncate shortens s to at most n characters, ending it with an ellipsis
+// if it is cut.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
+
+// discordRetryAfter reads the retry_after seconds from the response body.
+func discordRetryAfter(resp *http.Response, body []byte) time.Duration {
+	var v struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if err := json.Unmarshal(body, &v); err == nil && v.RetryAfter > 0 {
+		return time.Duration(v.RetryAfter * float64(time.Second))
+	}
+	return retryAfterHeader(resp, body)
+}
@@ -0,0 +1,145 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// hook posts json messages to a chat incoming webhook respecting its rate
+// limits. Messages are queued in memory and sent by Run.
+type hook struct {
+	name     string
+	url      string
+	interval time.Duration
+	client   *http.Client
+	queue    chan []byte
+	// retryAfter extracts the wait time from a 429 response
+	retryAfter func(*http.Response, []byte) time.Duration
+}
+
+func newHook(name, u string, interval time.Duration, retryAfter func(*http.Response, []byte) time.Duration) *hook {
+	return &hook{
+		name:       name,
+		url:        u,
+		interval:   interval,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		queue:      make(chan []byte, 1000),
+		retryAfter: retryAfter,
+	}
+}
+
+func (h *hook) push(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("notify: couldn't encode message: %w", err)
+	}
+	select {
+	case h.queue <- body:
+		return nil
+	default:
+		return errors.New("notify: queue is full")
+	}
+}
+
+// Run sends queued messages until the context is cancelled.
+func (h *hook) Run(ctx context.Context) {
+	for {
+		var body []byte
+		select {
+		case <-ctx.Done():
+			return
+		case body = <-h.queue:
+		}
+		for attempt := 1; ; attempt++ {
+			wait, err := h.post(ctx, body)
+			if err == nil {
+				break
+			}
+			if wait == 0 {
+				if attempt >= 5 {
+					slog.Error("notify: message dropped", "notifier", h.name, "err", err)
+					break
+				}
+				wait = time.Duration(attempt) * 2 * time.Second
+			}
+			slog.Warn("notify: couldn't post, retrying", "notifier", h.name, "retry_after", wait, "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(h.interval):
+		}
+	}
+}
+
+// post sends a message and returns the time to wait if rate limited.
+func (h *hook) post(ctx context.Context, body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	respBody, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		wait := h.retryAfter(resp, respBody)
+		if wait <= 0 {
+			wait = time.Second
+		}
+		return wait, errors.New("notify: rate limited")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("notify: invalid status code %s: %s", resp.Status, respBody)
+	}
+	return 0, nil
+}
+
+// retryAfterHeader parses the standard Retry-After header in seconds.
+func retryAfterHeader(resp *http.Response, _ []byte) time.Duration {
+	secs, err := strconv.ParseFloat(resp.Header.Get("Retry-After"), 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(secs * float64(time.Second))
+}
+
+// Badge returns a short text highlighting the deal of an item.
+func Badge(i api.Item) string {
+	if i.PreviousPrice <= i.Price || i.PreviousPrice <= 0 {
+		return "🆕 NEW"
+	}
+	discount := math.Round((i.PreviousPrice - i.Price) / i.PreviousPrice * 100)
+	if discount >= 20 {
+		return fmt.Sprintf("🔥 -%.0f%%", discount)
+	}
+	return fmt.Sprintf("⚡️ -%.0f%%", discount)
+}
+
+// label returns the name of the search or its query.
+func (s Search) label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.Query
+}
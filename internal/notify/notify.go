@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// Kind is the type of event notified.
+type Kind string
+
+const (
+	KindNew       Kind = "new"
+	KindPriceDrop Kind = "price_drop"
+	KindGone      Kind = "gone"
+)
+
+// Search describes the search that generated an event.
+type Search struct {
+	ID    string   `json:"id"`
+	Name  string   `json:"name,omitempty"`
+	Tags  []string `json:"tags,omitempty"`
+	Chat  string   `json:"chat"`
+	Query string   `json:"query"`
+}
+
+// Event is sent to notifiers when something happens to an item.
+type Event struct {
+	Kind   Kind      `json:"type"`
+	Item   api.Item  `json:"item"`
+	Search Search    `json:"search"`
+	Time   time.Time `json:"time"`
+}
+
+// ErrSkipped is returned by notifiers that don't deliver the kind of event.
+var ErrSkipped = errors.New("notify: event skipped")
+
+// Notifier delivers events to a destination.
+type Notifier interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Runner is implemented by notifiers with background work, like sending
+// digests, that must run while the bot is running.
+type Runner interface {
+	Run(ctx context.Context)
+}
+
+// Config defines a notifier.
+type Config struct {
+	// Name is used by searches to reference the notifier, either as a
+	// target or instead of the telegram chat
+	Name string
+	// Type selects the registered implementation
+	Type string
+	// URL is the destination, its format depends on the type
+	URL string
+	// Options are type specific settings
+	Options map[string]string
+	// DB is used by notifiers to persist their state
+	DB store.Store
+	// Secret is a random key persisted by the bot, used to sign links when
+	// the notifier has no secret option
+	Secret []byte
+}
+
+// Parse parses a notifier config with format name=type:url [key=value ...]
+func Parse(s string) (Config, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return Config{}, fmt.Errorf("notify: empty notifier")
+	}
+	split := strings.SplitN(fields[0], "=", 2)
+	if len(split) != 2 {
+		return Config{}, fmt.Errorf("notify: invalid notifier %q, format is name=type:url", s)
+	}
+	name := strings.ToLower(split[0])
+	split = strings.SplitN(split[1], ":", 2)
+	if name == "" || len(split) != 2 {
+		return Config{}, fmt.Errorf("notify: invalid notifier %q, format is name=type:url", s)
+	}
+	cfg := Config{
+		Name:    name,
+		Type:    strings.ToLower(split[0]),
+		URL:     split[1],
+		Options: make(map[string]string),
+	}
+	for _, f := range fields[1:] {
+		split := strings.SplitN(f, "=", 2)
+		if len(split) != 2 {
+			return Config{}, fmt.Errorf("notify: invalid option %q for %s, format is key=value", f, name)
+		}
+		cfg.Options[split[0]] = split[1]
+	}
+	return cfg, nil
+}
+
+// Factory creates a notifier from its config.
+type Factory func(cfg Config) (Notifier, error)
+
+var (
+	lock      sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a notifier type available.
+func Register(typ string, f Factory) {
+	lock.Lock()
+	defer lock.Unlock()
+	factories[typ] = f
+}
+
+// New creates a notifier using the factory registered for its type.
+func New(cfg Config) (Notifier, error) {
+	lock.Lock()
+	f, ok := factories[cfg.Type]
+	lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("notify: unknown notifier type %s", cfg.Type)
+	}
+	n, err := f(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("notify: couldn't create %s notifier %s: %w", cfg.Type, cfg.Name, err)
+	}
+	return n, nil
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("slack", NewSlack)
+}
+
+// Slack allows 1 message per second per incoming webhook
+const slackInterval = time.Second
+
+type slackMessage struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type      string        `json:"type"`
+	Text      *slackText    `json:"text,omitempty"`
+	Accessory *slackImage   `json:"accessory,omitempty"`
+	Elements  []interface{} `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackImage struct {
+	Type     string `json:"type"`
+	ImageURL string `json:"image_url"`
+	AltText  string `json:"alt_text"`
+}
+
+// Slack sends events as blocks to a slack incoming webhook.
+type Slack struct {
+	*hook
+}
+
+// NewSlack creates a slack notifier using an incoming webhook url.
+func NewSlack(cfg Config) (Notifier, error) {
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, fmt.Errorf("invalid url %s: %w", cfg.URL, err)
+	}
+	return &Slack{hook: newHook(cfg.Name, cfg.URL, slackInterval, retryAfterHeader)}, nil
+}
+
+func (s *Slack) Send(ctx context.Context, e Event) error {
+	if e.Kind == KindGone {
+		return ErrSkipped
+	}
+	price := fmt.Sprintf("*%.2f€*", e.Item.Price)
+	if e.Kind == KindPriceDrop {
+		price = fmt.Sprintf("%s ~%.2f€~", price, e.Item.PreviousPrice)
+	}
+	section := slackBlock{
+		Type: "section",
+		Text: &slackText{
+			Type: "mrkdwn",
+			Text: fmt.Sprintf("%s\n*<%s|%s>*\n%s", Badge(e.Item), slackURL(e.Item.Link), slackLinkText(e.Item.Title), price),
+		},
+	}
+	if e.Item.Image != "" {
+		section.Accessory = &slackImage{Type: "image", ImageURL: e.Item.Image, AltText: e.Item.Title}
+	}
+	return s.push(slackMessage{
+		Text: fmt.Sprintf("%s %s %.2f€", Badge(e.Item), slackEscape(e.Item.Title), e.Item.Price),
+		Blocks: []slackBlock{
+			section,
+			{
+				Type:     "context",
+				Elements: []interface{}{slackText{Type: "mrkdwn", Text: fmt.Sprintf("🔎 %s", slackEscape(e.Search.label()))}},
+			},
+		},
+	})
+}
+
+// slackEscaper escapes the control characters of slack mrkdwn, see
+// https://api.slack.com/reference/surfaces/formatting#escaping
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackEscape escapes text written in mrkdwn.
+func slackEscape(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// slackLinkText escapes the text of a link, a pipe would end the url.
+func slackLinkText(s string) string {
+	return strings.ReplaceAll(slackEscape(s), "|", "¦")
+}
+
+// slackURL encodes the characters that would break a mrkdwn link.
+func slackURL(s string) string {
+	return strings.NewReplacer("|", "%7C", "<", "%3C", ">", "%3E").Replace(s)
+}
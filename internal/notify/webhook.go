@@ -0,0 +1,227 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+func init() {
+	Register("webhook", NewWebhook)
+}
+
+// WebhookVersion is the version of the payload sent by the webhook notifier.
+const WebhookVersion = 1
+
+// Headers sent by the webhook notifier. The signature is the hex encoded
+// HMAC-SHA256 of "<timestamp>.<body>" using the secret of the notifier.
+const (
+	SignatureHeader = "X-Wallabot-Signature"
+	TimestampHeader = "X-Wallabot-Timestamp"
+	EventHeader     = "X-Wallabot-Event"
+)
+
+// WebhookPayload is the json body sent by the webhook notifier.
+type WebhookPayload struct {
+	Version int    `json:"version"`
+	ID      string `json:"id"`
+	Event
+}
+
+// DeadLetter is stored when an event couldn't be delivered.
+type DeadLetter struct {
+	Notifier string         `json:"notifier"`
+	URL      string         `json:"url"`
+	Payload  WebhookPayload `json:"payload"`
+	Error    string         `json:"error"`
+	Attempts int            `json:"attempts"`
+	FailedAt time.Time      `json:"failed_at"`
+}
+
+// Webhook posts events as signed json to an url.
+type Webhook struct {
+	name     string
+	url      string
+	secret   []byte
+	attempts int
+	backoff  time.Duration
+	client   *http.Client
+	db       store.Store
+	// drain is the time Close waits for pending deliveries
+	drain  time.Duration
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// webhookDrain is the time pending deliveries have to finish on shutdown.
+const webhookDrain = 30 * time.Second
+
+// NewWebhook creates a webhook notifier. Supported options are secret,
+// which is required to sign the events, attempts and backoff.
+func NewWebhook(cfg Config) (Notifier, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %s", cfg.URL)
+	}
+	if cfg.Options["secret"] == "" {
+		return nil, errors.New("secret option is required to sign the events")
+	}
+	w := &Webhook{
+		name:     cfg.Name,
+		url:      cfg.URL,
+		secret:   []byte(cfg.Options["secret"]),
+		attempts: 5,
+		backoff:  time.Second,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		db:       cfg.DB,
+		drain:    webhookDrain,
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	if v, ok := cfg.Options["attempts"]; ok {
+		if w.attempts, err = strconv.Atoi(v); err != nil || w.attempts < 1 {
+			return nil, fmt.Errorf("invalid attempts %s", v)
+		}
+	}
+	if v, ok := cfg.Options["backoff"]; ok {
+		if w.backoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid backoff %s: %w", v, err)
+		}
+	}
+	return w, nil
+}
+
+// Send delivers the event in background, retrying with exponential backoff.
+// Events that can't be delivered are stored in the deadletter bucket.
+// Deliveries don't depend on ctx, they are drained by Run or Close.
+func (w *Webhook) Send(ctx context.Context, e Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Errorf("notify: couldn't generate event id: %w", err)
+	}
+	p := WebhookPayload{
+		Version: WebhookVersion,
+		ID:      hex.EncodeToString(id),
+		Event:   e,
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("notify: couldn't encode event: %w", err)
+	}
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		attempts, err := w.deliver(w.ctx, p, body)
+		if err == nil {
+			return
+		}
+		w.dead(p, attempts, err)
+	}()
+	return nil
+}
+
+// Run waits until the context is cancelled and closes the notifier.
+func (w *Webhook) Run(ctx context.Context) {
+	<-ctx.Done()
+	w.Close()
+}
+
+// Close waits for the pending deliveries. Deliveries still retrying after
+// the drain time are cancelled and stored as dead letters.
+func (w *Webhook) Close() {
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.drain):
+		w.cancel()
+		<-done
+	}
+}
+
+func (w *Webhook) deliver(ctx context.Context, p WebhookPayload, body []byte) (int, error) {
+	var err error
+	backoff := w.backoff
+	for i := 1; i <= w.attempts; i++ {
+		if err = w.post(ctx, p, body); err == nil {
+			return i, nil
+		}
+		if i == w.attempts {
+			return i, err
+		}
+		select {
+		case <-ctx.Done():
+			return i, fmt.Errorf("%v: %w", err, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return w.attempts, err
+}
+
+func (w *Webhook) post(ctx context.Context, p WebhookPayload, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("notify: couldn't create request: %w", err)
+	}
+	req = req.WithContext(ctx)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(EventHeader, string(p.Kind))
+	req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, ts, body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("notify: webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("notify: invalid status code: " + resp.Status)
+	}
+	return nil
+}
+
+func (w *Webhook) dead(p WebhookPayload, attempts int, err error) {
+	slog.Error("notify: webhook delivery failed", "notifier", w.name, "event", p.ID, "attempts", attempts, "err", err)
+	if w.db == nil {
+		return
+	}
+	d := DeadLetter{
+		Notifier: w.name,
+		URL:      w.url,
+		Payload:  p,
+		Error:    err.Error(),
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	}
+	key := fmt.Sprintf("%s/%s", w.name, p.ID)
+	if err := w.db.Put("deadletter", key, d); err != nil {
+		slog.Error("notify: couldn't store dead letter", "key", key, "err", err)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 signature of a webhook body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
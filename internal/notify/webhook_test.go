@@ -0,0 +1,215 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// receiver records the requests of a webhook and answers with the status
+// codes in order, the last one is repeated.
+type receiver struct {
+	lock     sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, body)
+	r.times = append(r.times, time.Now())
+	status := r.statuses[len(r.statuses)-1]
+	if n := len(r.requests); n <= len(r.statuses) {
+		status = r.statuses[n-1]
+	}
+	w.WriteHeader(status)
+}
+
+func (r *receiver) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.requests)
+}
+
+// newTestWebhook creates a webhook with the options, signed with the secret
+// s3cret unless other one is given.
+func newTestWebhook(t *testing.T, url string, options map[string]string) (*Webhook, store.Store) {
+	t.Helper()
+	opts := map[string]string{"secret": "s3cret"}
+	for k, v := range options {
+		opts[k] = v
+	}
+	db, err := store.NewBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	n, err := NewWebhook(Config{Name: "hook", Type: "webhook", URL: url, Options: opts, DB: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n.(*Webhook), db
+}
+
+func testEvent() Event {
+	return Event{
+		Kind:   KindNew,
+		Item:   api.Item{ID: "1", Title: "bike", Price: 100},
+		Search: Search{ID: "1/bike", Chat: "1", Query: "bike"},
+		Time:   time.Now().UTC(),
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	w, _ := newTestWebhook(t, srv.URL, nil)
+
+	if err := w.Send(context.Background(), testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if r.count() != 1 {
+		t.Fatalf("got %d requests, want 1", r.count())
+	}
+	req, body := r.requests[0], r.bodies[0]
+	if got := req.Header.Get(EventHeader); got != string(KindNew) {
+		t.Errorf("event header = %q, want %q", got, KindNew)
+	}
+	ts := req.Header.Get(TimestampHeader)
+	want := "sha256=" + Sign([]byte("s3cret"), ts, body)
+	if got := req.Header.Get(SignatureHeader); got != want {
+		t.Errorf("signature header = %q, want %q", got, want)
+	}
+	var p WebhookPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Version != WebhookVersion || p.ID == "" || p.Item.ID != "1" {
+		t.Errorf("unexpected payload %+v", p)
+	}
+}
+
+func TestWebhookUnsigned(t *testing.T) {
+	// Events can't be sent unsigned
+	for _, options := range []map[string]string{nil, {"secret": ""}} {
+		if _, err := NewWebhook(Config{Name: "hook", Type: "webhook", URL: "https://example.com", Options: options}); err == nil {
+			t.Errorf("expected error creating a webhook with options %v", options)
+		}
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	w, db := newTestWebhook(t, srv.URL, map[string]string{"attempts": "5", "backoff": "20ms"})
+
+	if err := w.Send(context.Background(), testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if r.count() != 3 {
+		t.Fatalf("got %d requests, want 3", r.count())
+	}
+	// Backoff doubles after each attempt
+	if d := r.times[1].Sub(r.times[0]); d < 20*time.Millisecond {
+		t.Errorf("first backoff = %s, want at least 20ms", d)
+	}
+	if d := r.times[2].Sub(r.times[1]); d < 40*time.Millisecond {
+		t.Errorf("second backoff = %s, want at least 40ms", d)
+	}
+	keys, err := db.Keys("deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("got dead letters %v, want none", keys)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	w, db := newTestWebhook(t, srv.URL, map[string]string{"attempts": "3", "backoff": "1ms"})
+
+	if err := w.Send(context.Background(), testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if r.count() != 3 {
+		t.Fatalf("got %d requests, want 3", r.count())
+	}
+	keys, err := db.Keys("deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !strings.HasPrefix(keys[0], "hook/") {
+		t.Fatalf("got dead letters %v, want one for hook", keys)
+	}
+	var d DeadLetter
+	if err := db.Get("deadletter", keys[0], &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Attempts != 3 || d.URL != srv.URL || d.Payload.Item.ID != "1" || d.Error == "" {
+		t.Errorf("unexpected dead letter %+v", d)
+	}
+}
+
+func TestWebhookDrain(t *testing.T) {
+	r := &receiver{statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+	w, db := newTestWebhook(t, srv.URL, map[string]string{"attempts": "10", "backoff": "1h"})
+	w.drain = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	// The context of the send is cancelled right away, like a search
+	// cycle interrupted by a shutdown
+	sendCtx, sendCancel := context.WithCancel(context.Background())
+	if err := w.Send(sendCtx, testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	sendCancel()
+	for r.count() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run didn't return after the drain time")
+	}
+	keys, err := db.Keys("deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("got dead letters %v, want one", keys)
+	}
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Backup writes a consistent snapshot of the database to w, it can be used
+// while the database is in use.
+func (s *Bolt) Backup(w io.Writer) (int64, error) {
+	defer opDuration.Since(time.Now(), "backup")
+	var n int64
+	if err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	}); err != nil {
+		return 0, fmt.Errorf("store: couldn't backup: %w", err)
+	}
+	return n, nil
+}
+
+// BackupFile writes a snapshot of the database to path. The snapshot is
+// written to a temporary file first so path is never left incomplete.
+func (s *Bolt) BackupFile(path string) (int64, error) {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("store: couldn't create %s: %w", tmp, err)
+	}
+	n, err := s.Backup(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("store: couldn't rename %s: %w", tmp, err)
+	}
+	return n, nil
+}
+
+// checkBolt verifies the integrity of the bolt database file at path: its
+// pages must be consistent and it must contain the search buckets.
+func checkBolt(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("store: couldn't open bolt db %s: %w", path, err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("store: %s is corrupted: %w", path, errors.Join(errs...))
+		}
+		// Legacy databases only have the db bucket, the meta bucket is
+		// created when they are migrated
+		if tx.Bucket([]byte("db")) != nil {
+			return nil
+		}
+		if tx.Bucket([]byte("meta")) == nil || tx.Bucket([]byte(searchesBucket)) == nil {
+			return fmt.Errorf("store: %s is not a wallabot database, search buckets not found", path)
+		}
+		return nil
+	})
+}
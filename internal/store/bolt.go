@@ -0,0 +1,407 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// buckets are the buckets created when the store is opened.
+var buckets = []string{searchesBucket, itemsBucket, "config", "meta", "queue", "deadletter", "digest", "unsubscribe", "tokens", "shares", schemaBucket}
+
+// NewBolt opens a bolt database, creating it if it doesn't exist, and
+// migrates it to the current schema version. It fails after a second if the
+// database is locked by a running bot.
+func NewBolt(path string) (*Bolt, error) {
+	_, err := os.Stat(path)
+	exists := err == nil
+
+	// Open the my.db data file in your current directory.
+	// It will be created if it doesn't exist.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't open bolt db %s, is the bot running?: %w", path, err)
+	}
+	s := &Bolt{db: db}
+	backup := ""
+	if exists {
+		backup = fmt.Sprintf("%s.pre-migration-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+	}
+	// Buckets are created after migrating so the backup is a copy of the
+	// database before any write
+	if err := s.migrate(backup); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, bucket := range buckets {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+			return nil
+		}); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("store: couldn't create bucket %s: %w", bucket, err)
+		}
+	}
+	return s, nil
+}
+
+// openBoltReadOnly opens a bolt database without modifying it.
+func openBoltReadOnly(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't open bolt db %s, is the bot running?: %w", path, err)
+	}
+	return &Bolt{db: db}, nil
+}
+
+// Bolt is a store backed by a bolt database file.
+type Bolt struct {
+	// lock is held for writing while the database file is swapped
+	lock sync.RWMutex
+	db   *bolt.DB
+	// writes counts the write transactions, so compaction knows if the
+	// database changed while it was copied
+	writes atomic.Int64
+	// compact serializes compactions, they share the temporary file
+	compact sync.Mutex
+}
+
+func (s *Bolt) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.db.Close()
+}
+
+// view runs a read-only transaction.
+func (s *Bolt) view(fn func(*bolt.Tx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.db.View(fn)
+}
+
+// update runs a read-write transaction.
+func (s *Bolt) update(fn func(*bolt.Tx) error) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	defer s.writes.Add(1)
+	return s.db.Update(fn)
+}
+
+// Size returns the size of the database in bytes.
+func (s *Bolt) Size() int64 {
+	var size int64
+	_ = s.view(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	return size
+}
+
+// Free returns the bytes of the free pages reclaimed by Compact.
+func (s *Bolt) Free() int64 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	stats := s.db.Stats()
+	return int64(stats.FreePageN+stats.PendingPageN) * int64(s.db.Info().PageSize)
+}
+
+func (s *Bolt) Keys(bucket string) ([]string, error) {
+	defer opDuration.Since(time.Now(), "keys")
+	var keys []string
+	if err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", bucket)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("store: couldn't get keys: %w", err)
+	}
+	return keys, nil
+}
+
+func (s *Bolt) Get(bucket, key string, val interface{}) error {
+	defer opDuration.Since(time.Now(), "get")
+	if err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if v := b.Get([]byte(key)); len(v) > 0 {
+			if err := json.Unmarshal(v, val); err != nil {
+				return fmt.Errorf("couldn't decode: %w", err)
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("store: couldn't get %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Bolt) Put(bucket, key string, val interface{}) error {
+	defer opDuration.Since(time.Now(), "put")
+	if err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		byt, err := json.Marshal(val)
+		if err != nil {
+			return fmt.Errorf("couldn't encode: %w", err)
+		}
+		return b.Put([]byte(key), byt)
+	}); err != nil {
+		return fmt.Errorf("store: couldn't put %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Bolt) Delete(bucket, key string) error {
+	defer opDuration.Since(time.Now(), "delete")
+	if err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		return b.Delete([]byte(key))
+	}); err != nil {
+		return fmt.Errorf("store: couldn't delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Buckets returns the names of the buckets.
+func (s *Bolt) Buckets() ([]string, error) {
+	var buckets []string
+	if err := s.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets = append(buckets, string(name))
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("store: couldn't get buckets: %w", err)
+	}
+	return buckets, nil
+}
+
+// Dump returns a copy of all buckets.
+func (s *Bolt) Dump() (map[string]*Bucket, error) {
+	dump := make(map[string]*Bucket)
+	if err := s.view(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			d, err := dumpBucket(b)
+			if err != nil {
+				return err
+			}
+			dump[string(name)] = d
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("store: couldn't dump: %w", err)
+	}
+	return dump, nil
+}
+
+func dumpBucket(b *bolt.Bucket) (*Bucket, error) {
+	d := &Bucket{
+		Values:  make(map[string]json.RawMessage),
+		Buckets: make(map[string]*Bucket),
+	}
+	if err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nested, err := dumpBucket(b.Bucket(k))
+			if err != nil {
+				return err
+			}
+			d.Buckets[string(k)] = nested
+			return nil
+		}
+		d.Values[string(k)] = append(json.RawMessage(nil), v...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Import puts the values of a dump, existing keys are overwritten.
+func (s *Bolt) Import(dump map[string]*Bucket) error {
+	if err := s.update(func(tx *bolt.Tx) error {
+		for name, d := range dump {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return fmt.Errorf("couldn't create bucket %s: %w", name, err)
+			}
+			if err := importBucket(b, d); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("store: couldn't import: %w", err)
+	}
+	return nil
+}
+
+func importBucket(b *bolt.Bucket, d *Bucket) error {
+	if d == nil {
+		return nil
+	}
+	for k, v := range d.Values {
+		if err := b.Put([]byte(k), v); err != nil {
+			return err
+		}
+	}
+	for name, nested := range d.Buckets {
+		nb, err := b.CreateBucketIfNotExists([]byte(name))
+		if err != nil {
+			return fmt.Errorf("couldn't create bucket %s: %w", name, err)
+		}
+		if err := importBucket(nb, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compactBolt rewrites the bolt database file at path to reclaim free pages
+// and returns its size before and after. The database must not be open.
+func compactBolt(path string) (int64, int64, error) {
+	src, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: couldn't open bolt db %s: %w", path, err)
+	}
+	defer src.Close()
+	before, err := fileSize(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	tmp := path + ".compact"
+	if err := compactTo(src, tmp); err != nil {
+		return 0, 0, err
+	}
+	src.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, 0, fmt.Errorf("store: couldn't replace %s: %w", path, err)
+	}
+	after, err := fileSize(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// compactAttempts is the number of times the database is copied while it
+// can still be written, the last copy blocks writes.
+const compactAttempts = 3
+
+// Compact rewrites the open database into a new file and swaps them, it
+// returns the size before and after. The copy runs in a read transaction,
+// it is repeated if the database is written meanwhile, and operations only
+// wait while the files are swapped.
+func (s *Bolt) Compact() (int64, int64, error) {
+	defer opDuration.Since(time.Now(), "compact")
+	s.compact.Lock()
+	defer s.compact.Unlock()
+	s.lock.RLock()
+	path := s.db.Path()
+	s.lock.RUnlock()
+	tmp := path + ".compact"
+	for attempt := 1; ; attempt++ {
+		s.lock.RLock()
+		writes := s.writes.Load()
+		err := compactTo(s.db, tmp)
+		s.lock.RUnlock()
+		if err != nil {
+			return 0, 0, err
+		}
+		s.lock.Lock()
+		if s.writes.Load() == writes {
+			break
+		}
+		if attempt == compactAttempts {
+			// The database is busy, copy it again while writes wait
+			if err := compactTo(s.db, tmp); err != nil {
+				s.lock.Unlock()
+				return 0, 0, err
+			}
+			break
+		}
+		s.lock.Unlock()
+	}
+	defer s.lock.Unlock()
+
+	before, err := fileSize(path)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, err
+	}
+	if err := s.db.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return 0, 0, fmt.Errorf("store: couldn't close %s: %w", path, err)
+	}
+	renameErr := os.Rename(tmp, path)
+	if renameErr != nil {
+		_ = os.Remove(tmp)
+	}
+	// Reopen the database even if the swap failed, the original file is
+	// still in place then
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("store: couldn't reopen bolt db %s: %w", path, err)
+	}
+	s.db = db
+	if renameErr != nil {
+		return 0, 0, fmt.Errorf("store: couldn't replace %s: %w", path, renameErr)
+	}
+	after, err := fileSize(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// compactTo copies the buckets of src into a new database file.
+func compactTo(src *bolt.DB, path string) error {
+	_ = os.Remove(path)
+	dst, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return fmt.Errorf("store: couldn't open bolt db %s: %w", path, err)
+	}
+	if err := src.View(func(stx *bolt.Tx) error {
+		return dst.Update(func(dtx *bolt.Tx) error {
+			return stx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nb, err := dtx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(b, nb)
+			})
+		})
+	}); err != nil {
+		dst.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("store: couldn't compact into %s: %w", path, err)
+	}
+	if err := dst.Close(); err != nil {
+		_ = os.Remove(path)
+		return fmt.Errorf("store: couldn't close %s: %w", path, err)
+	}
+	return nil
+}
+
+// copyBucket copies the keys and nested buckets of src into dst.
+func copyBucket(src, dst *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			nb, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), nb)
+		}
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBoltCompactWrites(t *testing.T) {
+	s, err := NewBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// Free pages to reclaim
+	for n := 0; n < 1000; n++ {
+		if err := s.Put("config", fmt.Sprintf("old%04d", n), "some value to fill the pages"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n := 0; n < 1000; n++ {
+		if err := s.Delete("config", fmt.Sprintf("old%04d", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Free() == 0 {
+		t.Error("no free pages after deleting keys")
+	}
+
+	// Writes made while the database is compacted aren't lost
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 200; n++ {
+			if err := s.Put("config", fmt.Sprintf("new%04d", n), n); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	before, after, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if after > before {
+		t.Errorf("size after compacting = %d, want at most %d", after, before)
+	}
+	keys, err := s.Keys("config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 200 {
+		t.Errorf("got %d keys, want 200", len(keys))
+	}
+}
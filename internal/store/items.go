@@ -0,0 +1,305 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// Items are stored in two buckets:
+//   - searches: a nested bucket per search key with a ref per item id, the
+//     small state that changes on every search.
+//   - items: the item data shared by all the searches that found it, only
+//     written when it changes.
+const (
+	searchesBucket = "searches"
+	itemsBucket    = "items"
+)
+
+// seenResolution is the resolution of the stored seen time, refs aren't
+// rewritten only because their item was seen again within it.
+const seenResolution = time.Hour
+
+// ref is the state of an item in a search.
+type ref struct {
+	Price         float64   `json:"price"`
+	PreviousPrice float64   `json:"previous_price"`
+	CreatedAt     time.Time `json:"created_at"`
+	SeenAt        time.Time `json:"seen_at"`
+	GoneAt        time.Time `json:"gone_at"`
+}
+
+// Searches returns the keys of the stored searches.
+func (s *Bolt) Searches() ([]string, error) {
+	return s.Keys(searchesBucket)
+}
+
+// AddSearch stores a search without items if it doesn't exist.
+func (s *Bolt) AddSearch(key string) error {
+	defer opDuration.Since(time.Now(), "add_search")
+	if err := s.update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket([]byte(searchesBucket)).CreateBucketIfNotExists([]byte(key))
+		return err
+	}); err != nil {
+		return fmt.Errorf("store: couldn't add search %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteSearch deletes a search and the shared items no other search
+// references.
+func (s *Bolt) DeleteSearch(key string) error {
+	defer opDuration.Since(time.Now(), "delete_search")
+	if err := s.update(func(tx *bolt.Tx) error {
+		searches := tx.Bucket([]byte(searchesBucket))
+		b := searches.Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		orphans := make(map[string]bool)
+		if err := b.ForEach(func(k, _ []byte) error {
+			orphans[string(k)] = true
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := searches.DeleteBucket([]byte(key)); err != nil {
+			return err
+		}
+		return deleteOrphans(tx, orphans)
+	}); err != nil {
+		return fmt.Errorf("store: couldn't delete search %s: %w", key, err)
+	}
+	return nil
+}
+
+// Prune removes the items of a search not seen within maxAge and the least
+// recently seen gone ones exceeding maxItems, zero values disable each
+// limit. Listed items count first towards maxItems but aren't removed to
+// honour it, they would be notified again as new on the next search. Items
+// without seen time never expire by age. It returns the number of items
+// removed.
+func (s *Bolt) Prune(key string, maxAge time.Duration, maxItems int) (int, error) {
+	defer opDuration.Since(time.Now(), "prune")
+	var removed int
+	if err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(searchesBucket)).Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		type seen struct {
+			id   string
+			at   time.Time
+			gone bool
+		}
+		var refs []seen
+		if err := b.ForEach(func(k, v []byte) error {
+			var r ref
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("couldn't decode ref %s: %w", k, err)
+			}
+			refs = append(refs, seen{id: string(k), at: r.SeenAt, gone: !r.GoneAt.IsZero()})
+			return nil
+		}); err != nil {
+			return err
+		}
+		// Listed items first, then the most recently seen
+		sort.Slice(refs, func(i, j int) bool {
+			if refs[i].gone != refs[j].gone {
+				return !refs[i].gone
+			}
+			return refs[i].at.After(refs[j].at)
+		})
+
+		orphans := make(map[string]bool)
+		for n, r := range refs {
+			expired := maxAge > 0 && !r.at.IsZero() && time.Since(r.at) > maxAge
+			exceeded := maxItems > 0 && n >= maxItems && r.gone
+			if expired || exceeded {
+				orphans[r.id] = true
+			}
+		}
+		for id := range orphans {
+			if err := b.Delete([]byte(id)); err != nil {
+				return err
+			}
+		}
+		removed = len(orphans)
+		return deleteOrphans(tx, orphans)
+	}); err != nil {
+		return 0, fmt.Errorf("store: couldn't prune %s: %w", key, err)
+	}
+	return removed, nil
+}
+
+// deleteOrphans deletes the shared items of the ids no search references.
+func deleteOrphans(tx *bolt.Tx, ids map[string]bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	searches := tx.Bucket([]byte(searchesBucket))
+	if err := searches.ForEach(func(k, _ []byte) error {
+		b := searches.Bucket(k)
+		for id := range ids {
+			if b.Get([]byte(id)) != nil {
+				delete(ids, id)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	items := tx.Bucket([]byte(itemsBucket))
+	for id := range ids {
+		if err := items.Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Items returns the items of a search.
+func (s *Bolt) Items(key string) (map[string]api.Item, error) {
+	defer opDuration.Since(time.Now(), "items")
+	items := make(map[string]api.Item)
+	if err := s.view(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(searchesBucket)).Bucket([]byte(key))
+		if b == nil {
+			return nil
+		}
+		shared := tx.Bucket([]byte(itemsBucket))
+		return b.ForEach(func(k, v []byte) error {
+			var r ref
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("couldn't decode ref %s: %w", k, err)
+			}
+			var i api.Item
+			if raw := shared.Get(k); len(raw) > 0 {
+				if err := json.Unmarshal(raw, &i); err != nil {
+					return fmt.Errorf("couldn't decode item %s: %w", k, err)
+				}
+			}
+			i.ID = string(k)
+			i.Price = r.Price
+			i.PreviousPrice = r.PreviousPrice
+			i.CreatedAt = r.CreatedAt
+			i.SeenAt = r.SeenAt
+			i.GoneAt = r.GoneAt
+			items[i.ID] = i
+			return nil
+		})
+	}); err != nil {
+		return nil, fmt.Errorf("store: couldn't get items of %s: %w", key, err)
+	}
+	return items, nil
+}
+
+// PutItems upserts the items of an existing search. Values are only written
+// when they change, so a search cycle usually only rewrites the refs of the
+// items it has seen.
+func (s *Bolt) PutItems(key string, items map[string]api.Item) error {
+	defer opDuration.Since(time.Now(), "put_items")
+	if err := s.update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(searchesBucket)).Bucket([]byte(key))
+		if b == nil {
+			return fmt.Errorf("search not found")
+		}
+		return putItems(tx, b, items)
+	}); err != nil {
+		return fmt.Errorf("store: couldn't put items of %s: %w", key, err)
+	}
+	return nil
+}
+
+// putItems upserts the items in the search bucket and the shared items
+// bucket.
+func putItems(tx *bolt.Tx, b *bolt.Bucket, items map[string]api.Item) error {
+	shared := tx.Bucket([]byte(itemsBucket))
+	for id, i := range items {
+		r := ref{
+			Price:         i.Price,
+			PreviousPrice: i.PreviousPrice,
+			CreatedAt:     i.CreatedAt,
+			SeenAt:        i.SeenAt,
+			GoneAt:        i.GoneAt,
+		}
+		if raw := b.Get([]byte(id)); len(raw) > 0 {
+			var stored ref
+			if err := json.Unmarshal(raw, &stored); err == nil && r.SeenAt.Sub(stored.SeenAt) < seenResolution {
+				r.SeenAt = stored.SeenAt
+			}
+		}
+		v, err := json.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("couldn't encode ref %s: %w", id, err)
+		}
+		if err := putChanged(b, []byte(id), v); err != nil {
+			return err
+		}
+
+		// Most of the times the shared item hasn't changed since it was read
+		raw := shared.Get([]byte(id))
+		v, err = json.Marshal(api.Item{
+			ID:      id,
+			Link:    i.Link,
+			Title:   i.Title,
+			Price:   i.Price,
+			Image:   i.Image,
+			History: i.History,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't encode item %s: %w", id, err)
+		}
+		if bytes.Equal(raw, v) {
+			continue
+		}
+
+		// Other searches may have updated the shared item since it was
+		// read, keep its history and append the newer prices
+		var stored api.Item
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &stored); err != nil {
+				return fmt.Errorf("couldn't decode item %s: %w", id, err)
+			}
+		}
+		history := stored.History
+		for _, p := range i.History {
+			if n := len(history); n > 0 && (!p.Time.After(history[n-1].Time) || p.Price == history[n-1].Price) {
+				continue
+			}
+			history = append(history, p)
+		}
+		price := i.Price
+		if len(history) > 0 {
+			price = history[len(history)-1].Price
+		}
+		v, err = json.Marshal(api.Item{
+			ID:      id,
+			Link:    i.Link,
+			Title:   i.Title,
+			Price:   price,
+			Image:   i.Image,
+			History: history,
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't encode item %s: %w", id, err)
+		}
+		if err := putChanged(shared, []byte(id), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putChanged puts the value only if it differs from the stored one.
+func putChanged(b *bolt.Bucket, k, v []byte) error {
+	if bytes.Equal(b.Get(k), v) {
+		return nil
+	}
+	return b.Put(k, v)
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// benchItems is the number of items of the benchmarked search and drops the
+// items whose price changes on each search cycle.
+const (
+	benchItems = 5000
+	benchDrops = 5
+)
+
+// benchCreated is the creation time of the benchmarked items, it doesn't
+// change between cycles.
+var benchCreated = time.Now().UTC().Add(-24 * time.Hour)
+
+func benchSearch(cycle int) map[string]api.Item {
+	now := time.Now().UTC()
+	items := make(map[string]api.Item, benchItems)
+	for n := 0; n < benchItems; n++ {
+		id := fmt.Sprintf("item%05d", n)
+		price := 100.0
+		if n < benchDrops {
+			price -= float64(cycle % 50)
+		}
+		items[id] = api.Item{
+			ID:        id,
+			Link:      "https://es.wallapop.com/item/" + id,
+			Title:     "item " + id,
+			Price:     price,
+			Image:     "https://cdn.wallapop.com/images/" + id + ".jpg",
+			CreatedAt: benchCreated.Add(time.Duration(n) * time.Second),
+			SeenAt:    now,
+		}
+	}
+	return items
+}
+
+// reportWrites reports the bytes of the pages allocated and the number of
+// writes of the bolt database per operation.
+func reportWrites(b *testing.B, db *bolt.DB, fn func(i int) error) {
+	b.Helper()
+	prev := db.Stats()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := fn(i); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	stats := db.Stats()
+	diff := stats.TxStats.Sub(&prev.TxStats)
+	b.ReportMetric(float64(diff.PageAlloc)/float64(b.N), "bytes/cycle")
+	b.ReportMetric(float64(diff.Write)/float64(b.N), "writes/cycle")
+}
+
+// BenchmarkPutItems measures the writes of a search cycle with the items
+// stored in nested search buckets and a shared items bucket.
+func BenchmarkPutItems(b *testing.B) {
+	s, err := NewBolt(filepath.Join(b.TempDir(), "bench.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.AddSearch("1/bike"); err != nil {
+		b.Fatal(err)
+	}
+	if err := s.PutItems("1/bike", benchSearch(0)); err != nil {
+		b.Fatal(err)
+	}
+	reportWrites(b, s.db, func(i int) error {
+		return s.PutItems("1/bike", benchSearch(i+1))
+	})
+}
+
+// BenchmarkPutItemsLegacy measures the writes of a search cycle with the
+// legacy layout, a json map of all the items of the search in a single key.
+func BenchmarkPutItemsLegacy(b *testing.B) {
+	db, err := bolt.Open(filepath.Join(b.TempDir(), "bench.db"), 0600, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+	put := func(items map[string]api.Item) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			bucket, err := tx.CreateBucketIfNotExists([]byte("db"))
+			if err != nil {
+				return err
+			}
+			v, err := json.Marshal(items)
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte("1/bike"), v)
+		})
+	}
+	if err := put(benchSearch(0)); err != nil {
+		b.Fatal(err)
+	}
+	reportWrites(b, db, func(i int) error {
+		return put(benchSearch(i + 1))
+	})
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// schemaBucket and versionKey store the schema version of the database.
+const (
+	schemaBucket = "schema"
+	versionKey   = "version"
+)
+
+// migration upgrades the database schema from the previous version.
+type migration struct {
+	description string
+	migrate     func(tx *bolt.Tx) error
+}
+
+// migrations are applied in order, the schema version is the number of
+// migrations applied. New migrations must be appended, never reordered.
+var migrations = []migration{
+	{
+		description: "initial schema with json values in the db, meta and config buckets",
+		migrate:     func(tx *bolt.Tx) error { return nil },
+	},
+	{
+		description: "items moved from a json map per search to nested search buckets and a shared items bucket",
+		migrate:     migrateItems,
+	},
+}
+
+// migrateItems moves the items of each search in the db bucket to the
+// searches and items buckets. Legacy items don't have a seen time, they are
+// considered seen at the migration so retention doesn't prune them at once.
+func migrateItems(tx *bolt.Tx) error {
+	now := time.Now().UTC()
+	old := tx.Bucket([]byte("db"))
+	if old == nil {
+		return nil
+	}
+	searches, err := tx.CreateBucketIfNotExists([]byte(searchesBucket))
+	if err != nil {
+		return err
+	}
+	if _, err := tx.CreateBucketIfNotExists([]byte(itemsBucket)); err != nil {
+		return err
+	}
+	if err := old.ForEach(func(k, v []byte) error {
+		items := make(map[string]api.Item)
+		if len(v) > 0 {
+			if err := json.Unmarshal(v, &items); err != nil {
+				return fmt.Errorf("couldn't decode items of %s: %w", k, err)
+			}
+		}
+		for id, i := range items {
+			if i.SeenAt.IsZero() {
+				i.SeenAt = now
+				items[id] = i
+			}
+		}
+		b, err := searches.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return putItems(tx, b, items)
+	}); err != nil {
+		return err
+	}
+	return tx.DeleteBucket([]byte("db"))
+}
+
+// SchemaVersion is the schema version supported by this version of the store.
+var SchemaVersion = len(migrations)
+
+// Version returns the schema version of the database.
+func (s *Bolt) Version() (int, error) {
+	var v int
+	if err := s.view(func(tx *bolt.Tx) error {
+		var err error
+		v, err = version(tx)
+		return err
+	}); err != nil {
+		return 0, fmt.Errorf("store: couldn't get schema version: %w", err)
+	}
+	return v, nil
+}
+
+// version returns the schema version stored in the transaction, zero for
+// databases created before versioning.
+func version(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket([]byte(schemaBucket))
+	if b == nil {
+		return 0, nil
+	}
+	var v int
+	if raw := b.Get([]byte(versionKey)); len(raw) > 0 {
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return 0, fmt.Errorf("couldn't decode version: %w", err)
+		}
+	}
+	return v, nil
+}
+
+// migrate applies the pending migrations, each one in its own transaction
+// together with the version update. Existing databases are backed up to
+// backup before the first migration.
+func (s *Bolt) migrate(backup string) error {
+	current, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("store: database schema version %d is newer than the supported version %d", current, SchemaVersion)
+	}
+	if current == SchemaVersion {
+		return nil
+	}
+	if backup != "" {
+		if _, err := s.BackupFile(backup); err != nil {
+			return fmt.Errorf("store: couldn't backup before migrating: %w", err)
+		}
+		slog.Info("store backed up before migrating", "path", backup, "version", current)
+	}
+	for v := current; v < SchemaVersion; v++ {
+		m := migrations[v]
+		if err := s.update(func(tx *bolt.Tx) error {
+			if err := m.migrate(tx); err != nil {
+				return err
+			}
+			b, err := tx.CreateBucketIfNotExists([]byte(schemaBucket))
+			if err != nil {
+				return err
+			}
+			raw, err := json.Marshal(v + 1)
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte(versionKey), raw)
+		}); err != nil {
+			return fmt.Errorf("store: couldn't migrate to version %d (%s): %w", v+1, m.description, err)
+		}
+		slog.Info("store migrated", "version", v+1, "migration", m.description)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// testdata/legacy.db is a database created before the schema versions, it
+// only has the db bucket with a json map of items per search.
+func TestMigrateLegacy(t *testing.T) {
+	legacy, err := os.ReadFile(filepath.Join("testdata", "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallabot.db")
+	if err := os.WriteFile(path, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	s, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != SchemaVersion {
+		t.Errorf("version = %d, want %d", v, SchemaVersion)
+	}
+	searches, err := s.Searches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(searches) != 2 || searches[0] != "1/bike" || searches[1] != "2/lamp" {
+		t.Errorf("got searches %v, want [1/bike 2/lamp]", searches)
+	}
+	items, err := s.Items("1/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	a1 := items["a1"]
+	created := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC)
+	if a1.Title != "road bike" || a1.Price != 300 || a1.PreviousPrice != 350 || !a1.CreatedAt.Equal(created) ||
+		a1.Link != "https://es.wallapop.com/item/a1" {
+		t.Errorf("unexpected migrated item %+v", a1)
+	}
+	// Items without seen time are seen at the migration so they aren't
+	// pruned right away
+	for id, i := range items {
+		if i.SeenAt.Before(start.Truncate(time.Second)) {
+			t.Errorf("item %s seen at %s, want migration time", id, i.SeenAt)
+		}
+	}
+	if n, err := s.Prune("1/bike", 24*time.Hour, 0); err != nil || n != 0 {
+		t.Errorf("pruned %d items (%v), want 0", n, err)
+	}
+	buckets, err := s.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range buckets {
+		if b == "db" {
+			t.Error("legacy db bucket wasn't deleted")
+		}
+	}
+
+	s.Close()
+
+	// The backup is a copy of the database before the migration
+	backups, err := filepath.Glob(path + ".pre-migration-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("got backups %v, want one", backups)
+	}
+	db, err := bolt.Open(backups[0], 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var names, keys []string
+	if err := db.View(func(tx *bolt.Tx) error {
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		}); err != nil {
+			return err
+		}
+		if b := tx.Bucket([]byte("db")); b != nil {
+			return b.ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			})
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "db" {
+		t.Errorf("got backup buckets %v, want [db]", names)
+	}
+	if len(keys) != 2 {
+		t.Errorf("got backup searches %v, want 2", keys)
+	}
+
+	// Opening it again doesn't take another backup
+	if s, err = NewBolt(path); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	if backups, _ = filepath.Glob(path + ".pre-migration-*"); len(backups) != 1 {
+		t.Errorf("got backups %v, want one", backups)
+	}
+}
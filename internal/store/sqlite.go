@@ -0,0 +1,847 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteTime is the layout of the times stored in sqlite, fixed width so
+// they sort as text and sqlite date functions understand them.
+const sqliteTime = "2006-01-02 15:04:05.000000000"
+
+// sqliteMigrations are the statements of each schema version of the sqlite
+// backend, the schema version is stored in the user_version pragma. New
+// migrations must be appended, never reordered.
+var sqliteMigrations = []string{
+	`CREATE TABLE searches (
+		key TEXT PRIMARY KEY
+	);
+	CREATE TABLE items (
+		id TEXT PRIMARY KEY,
+		link TEXT NOT NULL,
+		title TEXT NOT NULL,
+		image TEXT NOT NULL
+	);
+	CREATE TABLE search_items (
+		search TEXT NOT NULL REFERENCES searches(key),
+		item TEXT NOT NULL REFERENCES items(id),
+		price REAL NOT NULL,
+		previous_price REAL NOT NULL,
+		created_at TEXT,
+		seen_at TEXT,
+		gone_at TEXT,
+		PRIMARY KEY (search, item)
+	);
+	CREATE INDEX search_items_item ON search_items(item);
+	CREATE TABLE price_history (
+		item TEXT NOT NULL REFERENCES items(id),
+		time TEXT NOT NULL,
+		price REAL NOT NULL,
+		PRIMARY KEY (item, time)
+	);
+	CREATE VIEW item_prices AS
+		SELECT i.id, i.title, i.link,
+			(SELECT h.price FROM price_history h WHERE h.item = i.id ORDER BY h.time DESC LIMIT 1) AS price
+		FROM items i;
+	CREATE TABLE kv (
+		bucket TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (bucket, key)
+	);`,
+}
+
+// SQLite is a store backed by a sqlite database file. Items, searches and
+// price history have their own tables so they can be analysed with sql, the
+// generic buckets like config, meta or queue are stored in the kv table.
+type SQLite struct {
+	db   *sql.DB
+	path string
+}
+
+// NewSQLite opens a sqlite database, creating it if it doesn't exist, and
+// migrates it to the current schema version.
+func NewSQLite(path string) (*SQLite, error) {
+	_, err := os.Stat(path)
+	exists := err == nil
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't open sqlite db %s: %w", path, err)
+	}
+	// A single connection serializes writes and avoids busy errors
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: couldn't open sqlite db %s: %w", path, err)
+	}
+	s := &SQLite{db: db, path: path}
+	backup := ""
+	if exists {
+		backup = fmt.Sprintf("%s.pre-migration-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+	}
+	if err := s.migrate(backup); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// openSQLiteReadOnly opens a sqlite database without modifying it.
+func openSQLiteReadOnly(path string) (*SQLite, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(1000)")
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't open sqlite db %s: %w", path, err)
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: couldn't open sqlite db %s: %w", path, err)
+	}
+	return &SQLite{db: db, path: path}, nil
+}
+
+func (s *SQLite) Close() {
+	s.db.Close()
+}
+
+// migrate applies the pending migrations, each one in its own transaction
+// together with the version update. Existing databases are backed up to
+// backup before the first migration.
+func (s *SQLite) migrate(backup string) error {
+	current, err := s.Version()
+	if err != nil {
+		return err
+	}
+	if current > len(sqliteMigrations) {
+		return fmt.Errorf("store: database schema version %d is newer than the supported version %d", current, len(sqliteMigrations))
+	}
+	if current == len(sqliteMigrations) {
+		return nil
+	}
+	if backup != "" && current > 0 {
+		if _, err := s.BackupFile(backup); err != nil {
+			return fmt.Errorf("store: couldn't backup before migrating: %w", err)
+		}
+	}
+	for v := current; v < len(sqliteMigrations); v++ {
+		if err := s.tx(func(tx *sql.Tx) error {
+			if _, err := tx.Exec(sqliteMigrations[v]); err != nil {
+				return err
+			}
+			_, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", v+1))
+			return err
+		}); err != nil {
+			return fmt.Errorf("store: couldn't migrate to version %d: %w", v+1, err)
+		}
+	}
+	return nil
+}
+
+// tx runs fn in a transaction, committed if it doesn't return an error.
+func (s *SQLite) tx(fn func(*sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Version returns the schema version of the database.
+func (s *SQLite) Version() (int, error) {
+	var v int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&v); err != nil {
+		return 0, fmt.Errorf("store: couldn't get schema version: %w", err)
+	}
+	return v, nil
+}
+
+// Size returns the size of the database in bytes.
+func (s *SQLite) Size() int64 {
+	var size int64
+	_ = s.db.QueryRow("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&size)
+	return size
+}
+
+// Free returns the bytes of the free pages reclaimed by Compact.
+func (s *SQLite) Free() int64 {
+	var free int64
+	_ = s.db.QueryRow("SELECT freelist_count * page_size FROM pragma_freelist_count(), pragma_page_size()").Scan(&free)
+	return free
+}
+
+// Buckets returns the names of the buckets, searches and items are the
+// tables of the same name.
+func (s *SQLite) Buckets() ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, b := range buckets {
+		if b == schemaBucket {
+			continue
+		}
+		names = append(names, b)
+		seen[b] = true
+	}
+	kv, err := s.strings("SELECT DISTINCT bucket FROM kv ORDER BY bucket")
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't get buckets: %w", err)
+	}
+	for _, b := range kv {
+		if !seen[b] {
+			names = append(names, b)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *SQLite) Keys(bucket string) ([]string, error) {
+	defer opDuration.Since(time.Now(), "keys")
+	var keys []string
+	var err error
+	switch bucket {
+	case searchesBucket:
+		keys, err = s.strings("SELECT key FROM searches ORDER BY key")
+	case itemsBucket:
+		keys, err = s.strings("SELECT id FROM items ORDER BY id")
+	default:
+		keys, err = s.strings("SELECT key FROM kv WHERE bucket = ? ORDER BY key", bucket)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("store: couldn't get keys: %w", err)
+	}
+	return keys, nil
+}
+
+// strings returns the first column of the rows of a query.
+func (s *SQLite) strings(query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+func (s *SQLite) Get(bucket, key string, val interface{}) error {
+	defer opDuration.Since(time.Now(), "get")
+	var v string
+	err := s.db.QueryRow("SELECT value FROM kv WHERE bucket = ? AND key = ?", bucket, key).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("store: couldn't get %s: %w", key, err)
+	}
+	if err := json.Unmarshal([]byte(v), val); err != nil {
+		return fmt.Errorf("store: couldn't get %s: couldn't decode: %w", key, err)
+	}
+	return nil
+}
+
+func (s *SQLite) Put(bucket, key string, val interface{}) error {
+	defer opDuration.Since(time.Now(), "put")
+	byt, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("store: couldn't put %s: couldn't encode: %w", key, err)
+	}
+	if _, err := s.db.Exec(`INSERT INTO kv (bucket, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value`, bucket, key, string(byt)); err != nil {
+		return fmt.Errorf("store: couldn't put %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *SQLite) Delete(bucket, key string) error {
+	defer opDuration.Since(time.Now(), "delete")
+	if _, err := s.db.Exec("DELETE FROM kv WHERE bucket = ? AND key = ?", bucket, key); err != nil {
+		return fmt.Errorf("store: couldn't delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Searches returns the keys of the stored searches.
+func (s *SQLite) Searches() ([]string, error) {
+	return s.Keys(searchesBucket)
+}
+
+// AddSearch stores a search without items if it doesn't exist.
+func (s *SQLite) AddSearch(key string) error {
+	defer opDuration.Since(time.Now(), "add_search")
+	if _, err := s.db.Exec("INSERT OR IGNORE INTO searches (key) VALUES (?)", key); err != nil {
+		return fmt.Errorf("store: couldn't add search %s: %w", key, err)
+	}
+	return nil
+}
+
+// DeleteSearch deletes a search and the items no other search references.
+func (s *SQLite) DeleteSearch(key string) error {
+	defer opDuration.Since(time.Now(), "delete_search")
+	if err := s.tx(func(tx *sql.Tx) error {
+		if _, err := tx.Exec("DELETE FROM search_items WHERE search = ?", key); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM searches WHERE key = ?", key); err != nil {
+			return err
+		}
+		return deleteSQLiteOrphans(tx)
+	}); err != nil {
+		return fmt.Errorf("store: couldn't delete search %s: %w", key, err)
+	}
+	return nil
+}
+
+// Prune removes the items of a search not seen within maxAge and the least
+// recently seen gone ones exceeding maxItems, zero values disable each
+// limit. Listed items count first towards maxItems but aren't removed to
+// honour it, they would be notified again as new on the next search. Items
+// without seen time never expire by age. It returns the number of items
+// removed.
+func (s *SQLite) Prune(key string, maxAge time.Duration, maxItems int) (int, error) {
+	defer opDuration.Since(time.Now(), "prune")
+	var removed int64
+	if err := s.tx(func(tx *sql.Tx) error {
+		if maxAge > 0 {
+			cutoff := time.Now().Add(-maxAge).UTC().Format(sqliteTime)
+			res, err := tx.Exec("DELETE FROM search_items WHERE search = ? AND seen_at < ?", key, cutoff)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			removed += n
+		}
+		if maxItems > 0 {
+			res, err := tx.Exec(`DELETE FROM search_items WHERE search = ?1 AND gone_at IS NOT NULL AND item NOT IN (
+				SELECT item FROM search_items WHERE search = ?1 ORDER BY gone_at IS NOT NULL, seen_at DESC LIMIT ?2)`, key, maxItems)
+			if err != nil {
+				return err
+			}
+			n, _ := res.RowsAffected()
+			removed += n
+		}
+		if removed == 0 {
+			return nil
+		}
+		return deleteSQLiteOrphans(tx)
+	}); err != nil {
+		return 0, fmt.Errorf("store: couldn't prune %s: %w", key, err)
+	}
+	return int(removed), nil
+}
+
+// deleteSQLiteOrphans deletes the items and price history no search
+// references.
+func deleteSQLiteOrphans(tx *sql.Tx) error {
+	if _, err := tx.Exec("DELETE FROM price_history WHERE item NOT IN (SELECT item FROM search_items)"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM items WHERE id NOT IN (SELECT item FROM search_items)")
+	return err
+}
+
+// Items returns the items of a search.
+func (s *SQLite) Items(key string) (map[string]api.Item, error) {
+	defer opDuration.Since(time.Now(), "items")
+	items := make(map[string]api.Item)
+	if err := s.tx(func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT s.item, s.price, s.previous_price, s.created_at, s.seen_at, s.gone_at,
+				COALESCE(i.link, ''), COALESCE(i.title, ''), COALESCE(i.image, '')
+			FROM search_items s LEFT JOIN items i ON i.id = s.item
+			WHERE s.search = ?`, key)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var i api.Item
+			var created, seen, gone sql.NullString
+			if err := rows.Scan(&i.ID, &i.Price, &i.PreviousPrice, &created, &seen, &gone, &i.Link, &i.Title, &i.Image); err != nil {
+				return err
+			}
+			if i.CreatedAt, err = parseSQLiteTime(created); err != nil {
+				return err
+			}
+			if i.SeenAt, err = parseSQLiteTime(seen); err != nil {
+				return err
+			}
+			if i.GoneAt, err = parseSQLiteTime(gone); err != nil {
+				return err
+			}
+			items[i.ID] = i
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		history, err := sqliteHistory(tx, "SELECT h.item, h.time, h.price FROM price_history h JOIN search_items s ON s.item = h.item WHERE s.search = ? ORDER BY h.item, h.time", key)
+		if err != nil {
+			return err
+		}
+		for id, h := range history {
+			i := items[id]
+			i.History = h
+			items[id] = i
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("store: couldn't get items of %s: %w", key, err)
+	}
+	return items, nil
+}
+
+// sqliteHistory returns the price history per item of a query returning
+// item, time and price ordered by time.
+func sqliteHistory(tx *sql.Tx, query string, args ...interface{}) (map[string][]api.Price, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	history := make(map[string][]api.Price)
+	for rows.Next() {
+		var id, t string
+		var p api.Price
+		if err := rows.Scan(&id, &t, &p.Price); err != nil {
+			return nil, err
+		}
+		if p.Time, err = time.Parse(sqliteTime, t); err != nil {
+			return nil, fmt.Errorf("couldn't parse time %q: %w", t, err)
+		}
+		history[id] = append(history[id], p)
+	}
+	return history, rows.Err()
+}
+
+// PutItems upserts the items of an existing search. Rows are only written
+// when they change and the seen time is stored with the same resolution as
+// the bolt backend.
+func (s *SQLite) PutItems(key string, items map[string]api.Item) error {
+	defer opDuration.Since(time.Now(), "put_items")
+	if err := s.tx(func(tx *sql.Tx) error {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM searches WHERE key = ?", key).Scan(&exists); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return fmt.Errorf("search not found")
+		}
+		seen := make(map[string]time.Time)
+		rows, err := tx.Query("SELECT item, seen_at FROM search_items WHERE search = ?", key)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			var t sql.NullString
+			if err := rows.Scan(&id, &t); err != nil {
+				rows.Close()
+				return err
+			}
+			if seen[id], err = parseSQLiteTime(t); err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return putSQLiteItems(tx, key, items, seen)
+	}); err != nil {
+		return fmt.Errorf("store: couldn't put items of %s: %w", key, err)
+	}
+	return nil
+}
+
+// putSQLiteItems upserts the items of a search, seen contains the stored
+// seen times of the search items.
+func putSQLiteItems(tx *sql.Tx, key string, items map[string]api.Item, seen map[string]time.Time) error {
+	refStmt, err := tx.Prepare(`INSERT INTO search_items (search, item, price, previous_price, created_at, seen_at, gone_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (search, item) DO UPDATE SET
+			price = excluded.price, previous_price = excluded.previous_price, created_at = excluded.created_at,
+			seen_at = excluded.seen_at, gone_at = excluded.gone_at
+		WHERE price IS NOT excluded.price OR previous_price IS NOT excluded.previous_price
+			OR created_at IS NOT excluded.created_at OR seen_at IS NOT excluded.seen_at OR gone_at IS NOT excluded.gone_at`)
+	if err != nil {
+		return err
+	}
+	defer refStmt.Close()
+	itemStmt, err := tx.Prepare(`INSERT INTO items (id, link, title, image) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET link = excluded.link, title = excluded.title, image = excluded.image
+		WHERE link IS NOT excluded.link OR title IS NOT excluded.title OR image IS NOT excluded.image`)
+	if err != nil {
+		return err
+	}
+	defer itemStmt.Close()
+	// Prices are appended if they are newer than the last stored one and
+	// change it, other searches may have stored them already
+	historyStmt, err := tx.Prepare(`INSERT INTO price_history (item, time, price)
+		SELECT ?1, ?2, ?3
+		WHERE NOT EXISTS (SELECT 1 FROM price_history WHERE item = ?1 AND time >= ?2)
+			AND (SELECT price FROM price_history WHERE item = ?1 ORDER BY time DESC LIMIT 1) IS NOT ?3`)
+	if err != nil {
+		return err
+	}
+	defer historyStmt.Close()
+
+	for id, i := range items {
+		seenAt := i.SeenAt
+		if stored, ok := seen[id]; ok && seenAt.Sub(stored) < seenResolution {
+			seenAt = stored
+		}
+		if _, err := refStmt.Exec(key, id, i.Price, i.PreviousPrice, sqliteValue(i.CreatedAt), sqliteValue(seenAt), sqliteValue(i.GoneAt)); err != nil {
+			return fmt.Errorf("couldn't put ref %s: %w", id, err)
+		}
+		if _, err := itemStmt.Exec(id, i.Link, i.Title, i.Image); err != nil {
+			return fmt.Errorf("couldn't put item %s: %w", id, err)
+		}
+		for _, p := range i.History {
+			if _, err := historyStmt.Exec(id, p.Time.UTC().Format(sqliteTime), p.Price); err != nil {
+				return fmt.Errorf("couldn't put price history %s: %w", id, err)
+			}
+		}
+	}
+	return nil
+}
+
+// sqliteValue returns the stored value of a time, null if it is zero.
+func sqliteValue(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC().Format(sqliteTime)
+}
+
+// parseSQLiteTime parses a stored time, null is the zero time.
+func parseSQLiteTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(sqliteTime, s.String)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("couldn't parse time %q: %w", s.String, err)
+	}
+	return t, nil
+}
+
+// Dump returns a copy of the database with the bucket layout of the bolt
+// backend.
+func (s *SQLite) Dump() (map[string]*Bucket, error) {
+	dump := make(map[string]*Bucket)
+	if err := s.tx(func(tx *sql.Tx) error {
+		for _, b := range buckets {
+			dump[b] = &Bucket{Values: make(map[string]json.RawMessage), Buckets: make(map[string]*Bucket)}
+		}
+		rows, err := tx.Query("SELECT bucket, key, value FROM kv")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var bucket, key, value string
+			if err := rows.Scan(&bucket, &key, &value); err != nil {
+				return err
+			}
+			if dump[bucket] == nil {
+				dump[bucket] = &Bucket{Values: make(map[string]json.RawMessage)}
+			}
+			dump[bucket].Values[key] = json.RawMessage(value)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		dump[schemaBucket].Values[versionKey] = json.RawMessage(fmt.Sprint(SchemaVersion))
+
+		keys, err := txStrings(tx, "SELECT key FROM searches")
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			dump[searchesBucket].Buckets[k] = &Bucket{Values: make(map[string]json.RawMessage)}
+		}
+		refs, err := tx.Query("SELECT search, item, price, previous_price, created_at, seen_at, gone_at FROM search_items")
+		if err != nil {
+			return err
+		}
+		defer refs.Close()
+		for refs.Next() {
+			var search, item string
+			var r ref
+			var created, seen, gone sql.NullString
+			if err := refs.Scan(&search, &item, &r.Price, &r.PreviousPrice, &created, &seen, &gone); err != nil {
+				return err
+			}
+			if r.CreatedAt, err = parseSQLiteTime(created); err != nil {
+				return err
+			}
+			if r.SeenAt, err = parseSQLiteTime(seen); err != nil {
+				return err
+			}
+			if r.GoneAt, err = parseSQLiteTime(gone); err != nil {
+				return err
+			}
+			v, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			b := dump[searchesBucket].Buckets[search]
+			if b == nil {
+				continue
+			}
+			b.Values[item] = v
+		}
+		if err := refs.Err(); err != nil {
+			return err
+		}
+
+		history, err := sqliteHistory(tx, "SELECT item, time, price FROM price_history ORDER BY item, time")
+		if err != nil {
+			return err
+		}
+		items, err := tx.Query("SELECT id, link, title, image FROM items")
+		if err != nil {
+			return err
+		}
+		defer items.Close()
+		for items.Next() {
+			var i api.Item
+			if err := items.Scan(&i.ID, &i.Link, &i.Title, &i.Image); err != nil {
+				return err
+			}
+			i.History = history[i.ID]
+			if n := len(i.History); n > 0 {
+				i.Price = i.History[n-1].Price
+			}
+			v, err := json.Marshal(i)
+			if err != nil {
+				return err
+			}
+			dump[itemsBucket].Values[i.ID] = v
+		}
+		return items.Err()
+	}); err != nil {
+		return nil, fmt.Errorf("store: couldn't dump: %w", err)
+	}
+	return dump, nil
+}
+
+// txStrings returns the first column of the rows of a query.
+func txStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// Import puts the values of a dump with the bucket layout of the bolt
+// backend, existing keys are overwritten.
+func (s *SQLite) Import(dump map[string]*Bucket) error {
+	if err := s.tx(func(tx *sql.Tx) error {
+		for name, b := range dump {
+			if b == nil {
+				continue
+			}
+			switch name {
+			case schemaBucket:
+			case "db":
+				return fmt.Errorf("bucket db has the format of an older version, open the dump with the bolt backend first to migrate it")
+			case searchesBucket:
+				if err := importSQLiteSearches(tx, b); err != nil {
+					return err
+				}
+			case itemsBucket:
+				if err := importSQLiteItems(tx, b); err != nil {
+					return err
+				}
+			default:
+				if len(b.Buckets) > 0 {
+					return fmt.Errorf("nested buckets in %s aren't supported", name)
+				}
+				for k, v := range b.Values {
+					if _, err := tx.Exec(`INSERT INTO kv (bucket, key, value) VALUES (?, ?, ?)
+						ON CONFLICT (bucket, key) DO UPDATE SET value = excluded.value`, name, k, string(v)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("store: couldn't import: %w", err)
+	}
+	return nil
+}
+
+// importSQLiteSearches imports the nested search buckets of the refs of
+// their items.
+func importSQLiteSearches(tx *sql.Tx, b *Bucket) error {
+	for key, nested := range b.Buckets {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO searches (key) VALUES (?)", key); err != nil {
+			return err
+		}
+		if nested == nil {
+			continue
+		}
+		for id, v := range nested.Values {
+			var r ref
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("couldn't decode ref %s: %w", id, err)
+			}
+			if _, err := tx.Exec(`INSERT INTO search_items (search, item, price, previous_price, created_at, seen_at, gone_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT (search, item) DO UPDATE SET
+					price = excluded.price, previous_price = excluded.previous_price, created_at = excluded.created_at,
+					seen_at = excluded.seen_at, gone_at = excluded.gone_at`,
+				key, id, r.Price, r.PreviousPrice, sqliteValue(r.CreatedAt), sqliteValue(r.SeenAt), sqliteValue(r.GoneAt)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// importSQLiteItems imports the shared items and their price history.
+func importSQLiteItems(tx *sql.Tx, b *Bucket) error {
+	for id, v := range b.Values {
+		var i api.Item
+		if err := json.Unmarshal(v, &i); err != nil {
+			return fmt.Errorf("couldn't decode item %s: %w", id, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO items (id, link, title, image) VALUES (?, ?, ?, ?)
+			ON CONFLICT (id) DO UPDATE SET link = excluded.link, title = excluded.title, image = excluded.image`,
+			id, i.Link, i.Title, i.Image); err != nil {
+			return err
+		}
+		for _, p := range i.History {
+			if _, err := tx.Exec("INSERT OR REPLACE INTO price_history (item, time, price) VALUES (?, ?, ?)",
+				id, p.Time.UTC().Format(sqliteTime), p.Price); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Backup writes a consistent snapshot of the database to w, it can be used
+// while the database is in use.
+func (s *SQLite) Backup(w io.Writer) (int64, error) {
+	defer opDuration.Since(time.Now(), "backup")
+	tmp := fmt.Sprintf("%s.backup-%d", s.path, time.Now().UnixNano())
+	defer os.Remove(tmp)
+	if _, err := s.db.Exec("VACUUM INTO ?", tmp); err != nil {
+		return 0, fmt.Errorf("store: couldn't backup: %w", err)
+	}
+	f, err := os.Open(tmp)
+	if err != nil {
+		return 0, fmt.Errorf("store: couldn't backup: %w", err)
+	}
+	defer f.Close()
+	n, err := io.Copy(w, f)
+	if err != nil {
+		return 0, fmt.Errorf("store: couldn't backup: %w", err)
+	}
+	return n, nil
+}
+
+// BackupFile writes a snapshot of the database to path. The snapshot is
+// written to a temporary file first so path is never left incomplete.
+func (s *SQLite) BackupFile(path string) (int64, error) {
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp)
+	if _, err := s.db.Exec("VACUUM INTO ?", tmp); err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("store: couldn't backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return 0, fmt.Errorf("store: couldn't rename %s: %w", tmp, err)
+	}
+	return fileSize(path)
+}
+
+// Compact rebuilds the database to reclaim free pages and returns its size
+// before and after.
+func (s *SQLite) Compact() (int64, int64, error) {
+	defer opDuration.Since(time.Now(), "compact")
+	before, err := fileSize(s.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err := s.db.Exec("VACUUM"); err != nil {
+		return 0, 0, fmt.Errorf("store: couldn't compact %s: %w", s.path, err)
+	}
+	after, err := fileSize(s.path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return before, after, nil
+}
+
+// checkSQLite verifies the integrity of the sqlite database file at path and
+// that it contains the wallabot tables.
+func checkSQLite(path string) error {
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("store: couldn't open sqlite db %s: %w", path, err)
+	}
+	defer db.Close()
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("store: couldn't check %s: %w", path, err)
+	}
+	var errs []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return fmt.Errorf("store: couldn't check %s: %w", path, err)
+		}
+		if msg != "ok" {
+			errs = append(errs, msg)
+		}
+	}
+	rows.Close()
+	if len(errs) > 0 {
+		return fmt.Errorf("store: %s is corrupted: %s", path, strings.Join(errs, "; "))
+	}
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('searches', 'search_items', 'kv')").Scan(&tables); err != nil {
+		return fmt.Errorf("store: couldn't check %s: %w", path, err)
+	}
+	if tables != 3 {
+		return fmt.Errorf("store: %s is not a wallabot database, search tables not found", path)
+	}
+	return nil
+}
@@ -1,89 +1,241 @@
 package store
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"time"
 
 	"github.com/boltdb/bolt"
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/metrics"
 )
 
-func New(path string) (*Store, error) {
-	// Open the my.db data file in your current directory.
-	// It will be created if it doesn't exist.
-	db, err := bolt.Open(path, 0600, nil)
+var opDuration = metrics.NewHistogram("wallabot_store_operation_duration_seconds",
+	"Duration of store operations.", metrics.DefBuckets, "op")
+
+// Store backends.
+const (
+	BackendBolt   = "bolt"
+	BackendSQLite = "sqlite"
+)
+
+// Store contains the operations on the database of the bot. Values of the
+// generic buckets are encoded as json, items are stored per search.
+type Store interface {
+	Close()
+	// Size returns the size of the database in bytes.
+	Size() int64
+	// Free returns the bytes of the free pages reclaimed by Compact.
+	Free() int64
+	// Version returns the schema version of the database.
+	Version() (int, error)
+
+	Buckets() ([]string, error)
+	Keys(bucket string) ([]string, error)
+	Get(bucket, key string, val interface{}) error
+	Put(bucket, key string, val interface{}) error
+	Delete(bucket, key string) error
+
+	Searches() ([]string, error)
+	AddSearch(key string) error
+	DeleteSearch(key string) error
+	Items(key string) (map[string]api.Item, error)
+	PutItems(key string, items map[string]api.Item) error
+	Prune(key string, maxAge time.Duration, maxItems int) (int, error)
+
+	// Dump and Import copy the database with the bucket layout of the bolt
+	// backend, so dumps can be imported in any backend.
+	Dump() (map[string]*Bucket, error)
+	Import(dump map[string]*Bucket) error
+
+	Backup(w io.Writer) (int64, error)
+	BackupFile(path string) (int64, error)
+	Compact() (int64, int64, error)
+}
+
+// Bucket is a copy of the raw values of a bucket and its nested buckets.
+type Bucket struct {
+	Values  map[string]json.RawMessage `json:"values,omitempty"`
+	Buckets map[string]*Bucket         `json:"buckets,omitempty"`
+}
+
+// Open opens the database with the backend, which is detected from the file
+// if empty. Existing files must match the backend.
+func Open(backend, path string) (Store, error) {
+	detected, err := Detect(path)
 	if err != nil {
-		return nil, fmt.Errorf("store: couldn't open bold db %s: %w", path, err)
-	}
-	for _, bucket := range []string{"db", "config"} {
-		if err := db.Update(func(tx *bolt.Tx) error {
-			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
-				return err
-			}
-			return nil
-		}); err != nil {
-			return nil, fmt.Errorf("store: couldn't create bucket %s: %w", bucket, err)
-		}
+		return nil, err
+	}
+	switch {
+	case backend == "":
+		backend = detected
+	case detected != "" && detected != backend:
+		return nil, fmt.Errorf("store: %s is a %s database, not %s", path, detected, backend)
+	}
+	switch backend {
+	case "", BackendBolt:
+		return NewBolt(path)
+	case BackendSQLite:
+		return NewSQLite(path)
+	default:
+		return nil, fmt.Errorf("store: unknown backend %q, valid backends are bolt and sqlite", backend)
 	}
-	return &Store{db: db}, nil
 }
 
-type Store struct {
-	db *bolt.DB
+// OpenReadOnly opens an existing database to inspect it. Buckets aren't
+// created and migrations aren't applied, so the file isn't modified. It fails
+// after a second if the database is locked by a running bot.
+func OpenReadOnly(path string) (Store, error) {
+	backend, err := Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case BackendBolt:
+		return openBoltReadOnly(path)
+	case BackendSQLite:
+		return openSQLiteReadOnly(path)
+	default:
+		return nil, fmt.Errorf("store: %s not found", path)
+	}
 }
 
-func (s *Store) Close() {
-	s.db.Close()
+// sqliteHeader is the beginning of every sqlite database file.
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Detect returns the backend of the database file, empty if it doesn't
+// exist.
+func Detect(path string) (string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("store: couldn't open %s: %w", path, err)
+	}
+	defer f.Close()
+	header := make([]byte, len(sqliteHeader))
+	n, _ := io.ReadFull(f, header)
+	if n == 0 {
+		return "", nil
+	}
+	if bytes.Equal(header[:n], sqliteHeader) {
+		return BackendSQLite, nil
+	}
+	return BackendBolt, nil
 }
 
-func (s *Store) Keys(bucket string) ([]string, error) {
-	var keys []string
-	if err := s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		return b.ForEach(func(k, v []byte) error {
-			keys = append(keys, string(k))
-			return nil
-		})
-	}); err != nil {
-		return nil, fmt.Errorf("store: couldn't get keys: %w", err)
-	}
-	return keys, nil
+// Check verifies the integrity of the database file at path.
+func Check(path string) error {
+	backend, err := Detect(path)
+	if err != nil {
+		return err
+	}
+	switch backend {
+	case BackendSQLite:
+		return checkSQLite(path)
+	case BackendBolt:
+		return checkBolt(path)
+	default:
+		return fmt.Errorf("store: %s not found", path)
+	}
 }
 
-func (s *Store) Get(bucket, key string, val interface{}) error {
-	if err := s.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		if v := b.Get([]byte(key)); len(v) > 0 {
-			if err := json.Unmarshal(v, val); err != nil {
-				return fmt.Errorf("couldn't decode: %w", err)
-			}
+// Compact rewrites the database file at path to reclaim free space and
+// returns its size before and after. The database must not be open.
+func Compact(path string) (int64, int64, error) {
+	backend, err := Detect(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if backend == BackendSQLite {
+		s, err := NewSQLite(path)
+		if err != nil {
+			return 0, 0, err
 		}
-		return nil
-	}); err != nil {
-		return fmt.Errorf("store: couldn't get %s: %w", key, err)
+		defer s.Close()
+		return s.Compact()
 	}
-	return nil
+	return compactBolt(path)
 }
 
-func (s *Store) Put(bucket, key string, val interface{}) error {
-	if err := s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		byt, err := json.Marshal(val)
+// Copy copies the contents of src into dst.
+func Copy(dst, src Store) error {
+	dump, err := src.Dump()
+	if err != nil {
+		return err
+	}
+	return dst.Import(dump)
+}
+
+// Restore replaces the database at path with the backup after checking its
+// integrity. The database must not be in use, the previous one is kept with
+// the .bak suffix.
+func Restore(backup, path string) error {
+	if err := Check(backup); err != nil {
+		return err
+	}
+	if backend, _ := Detect(path); backend == BackendBolt {
+		// Fail if the database is locked by a running bot
+		db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
 		if err != nil {
-			return fmt.Errorf("couldn't encode: %w", err)
+			return fmt.Errorf("store: couldn't open %s, is the bot running?: %w", path, err)
+		}
+		db.Close()
+	}
+
+	tmp := path + ".restore"
+	if err := copyFile(backup, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := Check(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		if err := os.Rename(path, path+".bak"); err != nil {
+			_ = os.Remove(tmp)
+			return fmt.Errorf("store: couldn't keep previous database: %w", err)
 		}
-		return b.Put([]byte(key), byt)
-	}); err != nil {
-		return fmt.Errorf("store: couldn't put %s: %w", key, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("store: couldn't replace %s: %w", path, err)
 	}
 	return nil
 }
 
-func (s *Store) Delete(bucket, key string) error {
-	if err := s.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		return b.Delete([]byte(key))
-	}); err != nil {
-		return fmt.Errorf("store: couldn't delete %s: %w", key, err)
+// copyFile copies src to dst and syncs it to disk.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("store: couldn't open %s: %w", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("store: couldn't create %s: %w", dst, err)
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("store: couldn't copy %s: %w", src, err)
 	}
 	return nil
 }
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, fmt.Errorf("store: couldn't stat %s: %w", path, err)
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,568 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// newLegacyBolt creates a bolt database with only the db bucket, like the
+// ones created before the schema versions.
+func newLegacyBolt(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("db"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("1/bike"), []byte("{}"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	for _, backend := range []string{BackendBolt, BackendSQLite} {
+		path := filepath.Join(dir, backend+".db")
+		s, err := Open(backend, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+		if err := Check(path); err != nil {
+			t.Errorf("%s: %v", backend, err)
+		}
+	}
+	if err := Check(newLegacyBolt(t)); err != nil {
+		t.Errorf("legacy: %v", err)
+	}
+
+	// Bolt databases of other applications aren't accepted
+	path := filepath.Join(dir, "other.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("other"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if err := Check(path); err == nil {
+		t.Error("expected error for a database without wallabot buckets")
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	// A legacy database with only the db bucket isn't modified
+	path := newLegacyBolt(t)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := s.Buckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 || buckets[0] != "db" {
+		t.Errorf("got buckets %v, want [db]", buckets)
+	}
+	if _, err := s.Keys("meta"); err == nil {
+		t.Error("expected error for a missing bucket")
+	}
+	if err := s.Put("db", "2/car", "{}"); err == nil {
+		t.Error("expected error writing to a read-only database")
+	}
+	s.Close()
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("database file was modified")
+	}
+
+	// A database in use by the bot can't be opened
+	rw, err := NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+	if _, err := OpenReadOnly(path); err == nil {
+		t.Error("expected timeout opening a locked database")
+	}
+	if _, err := NewBolt(path); err == nil || !strings.Contains(err.Error(), "is the bot running?") {
+		t.Errorf("got error %v, want a timeout opening a locked database", err)
+	}
+
+	if _, err := OpenReadOnly(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Error("expected error for a missing database")
+	}
+}
+
+// backends are the store implementations checked by the conformance tests.
+var backends = []struct {
+	name string
+	open func(path string) (Store, error)
+}{
+	{BackendBolt, func(path string) (Store, error) { return NewBolt(path) }},
+	{BackendSQLite, func(path string) (Store, error) { return NewSQLite(path) }},
+}
+
+// conformance are the behaviours every backend must implement, each test
+// receives a function that opens a new store of the backend.
+var conformance = []struct {
+	name string
+	test func(t *testing.T, open func(t *testing.T) (Store, string))
+}{
+	{"kv", testKV},
+	{"searches", testSearches},
+	{"items", testItems},
+	{"prune by age", testPruneAge},
+	{"prune by count", testPruneCount},
+	{"prune without seen time", testPruneUnseen},
+	{"dump and import", testDumpImport},
+	{"compact", testCompact},
+	{"copy", testCopy},
+	{"restore", testRestore},
+}
+
+func TestConformance(t *testing.T) {
+	for _, b := range backends {
+		b := b
+		open := func(t *testing.T) (Store, string) {
+			t.Helper()
+			path := filepath.Join(t.TempDir(), "test.db")
+			s, err := b.open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s, path
+		}
+		for _, c := range conformance {
+			t.Run(b.name+"/"+c.name, func(t *testing.T) {
+				c.test(t, open)
+			})
+		}
+	}
+}
+
+// testTime is a time without monotonic clock and with the precision of both
+// backends.
+var testTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func testItem(id string, price float64, seen time.Time) api.Item {
+	return api.Item{
+		ID:            id,
+		Link:          "https://es.wallapop.com/item/" + id,
+		Title:         "title " + id,
+		Price:         price,
+		PreviousPrice: price + 10,
+		Image:         "https://cdn.wallapop.com/" + id + ".jpg",
+		History: []api.Price{
+			{Price: price + 10, Time: testTime},
+			{Price: price, Time: testTime.Add(time.Hour)},
+		},
+		CreatedAt: testTime,
+		SeenAt:    seen,
+	}
+}
+
+// putTestItems adds the search and its items.
+func putTestItems(t *testing.T, s Store, key string, items ...api.Item) {
+	t.Helper()
+	if err := s.AddSearch(key); err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]api.Item)
+	for _, i := range items {
+		m[i.ID] = i
+	}
+	if err := s.PutItems(key, m); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkItem(t *testing.T, got, want api.Item) {
+	t.Helper()
+	if got.ID != want.ID || got.Link != want.Link || got.Title != want.Title || got.Image != want.Image ||
+		got.Price != want.Price || got.PreviousPrice != want.PreviousPrice ||
+		!got.CreatedAt.Equal(want.CreatedAt) || !got.SeenAt.Equal(want.SeenAt) || !got.GoneAt.Equal(want.GoneAt) {
+		t.Errorf("got item %+v, want %+v", got, want)
+	}
+	if len(got.History) != len(want.History) {
+		t.Fatalf("got history %v, want %v", got.History, want.History)
+	}
+	for n := range got.History {
+		if got.History[n].Price != want.History[n].Price || !got.History[n].Time.Equal(want.History[n].Time) {
+			t.Errorf("got history %v, want %v", got.History, want.History)
+		}
+	}
+}
+
+func checkKeys(t *testing.T, s Store, bucket string, want ...string) {
+	t.Helper()
+	keys, err := s.Keys(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("got %s keys %v, want %v", bucket, keys, want)
+	}
+}
+
+func testKV(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+
+	type value struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+	for _, k := range []string{"b", "a", "c"} {
+		if err := s.Put("config", k, value{Name: k, Count: len(k)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var v value
+	if err := s.Get("config", "b", &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "b" || v.Count != 1 {
+		t.Errorf("got %+v, want b", v)
+	}
+	var missing value
+	if err := s.Get("config", "missing", &missing); err != nil {
+		t.Fatal(err)
+	}
+	if missing != (value{}) {
+		t.Errorf("got %+v for a missing key, want zero value", missing)
+	}
+	checkKeys(t, s, "config", "a", "b", "c")
+	if err := s.Delete("config", "b"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, s, "config", "a", "c")
+	checkKeys(t, s, "tokens")
+}
+
+func testSearches(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+
+	shared := testItem("shared", 100, testTime)
+	putTestItems(t, s, "2/lamp", testItem("lamp", 20, testTime), shared)
+	putTestItems(t, s, "1/bike", shared)
+	// Adding an existing search keeps its items
+	if err := s.AddSearch("1/bike"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, s, searchesBucket, "1/bike", "2/lamp")
+	checkKeys(t, s, itemsBucket, "lamp", "shared")
+	if err := s.PutItems("3/unknown", map[string]api.Item{"x": testItem("x", 1, testTime)}); err == nil {
+		t.Error("expected error putting items of an unknown search")
+	}
+
+	// Shared items are deleted with the last search referencing them
+	if err := s.DeleteSearch("2/lamp"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, s, searchesBucket, "1/bike")
+	checkKeys(t, s, itemsBucket, "shared")
+	items, err := s.Items("2/lamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 0 {
+		t.Errorf("got items %v of a deleted search", items)
+	}
+	items, err = s.Items("1/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkItem(t, items["shared"], shared)
+	if err := s.DeleteSearch("1/bike"); err != nil {
+		t.Fatal(err)
+	}
+	checkKeys(t, s, searchesBucket)
+	checkKeys(t, s, itemsBucket)
+}
+
+func testItems(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+
+	bike := testItem("bike", 100, testTime)
+	bike.GoneAt = testTime.Add(2 * time.Hour)
+	car := testItem("car", 5000, testTime)
+	putTestItems(t, s, "1/bike", bike, car)
+	items, err := s.Items("1/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("got %d items, want 2", len(items))
+	}
+	checkItem(t, items["bike"], bike)
+	checkItem(t, items["car"], car)
+
+	// Updates append the new prices and keep the seen time within its
+	// resolution
+	car.PreviousPrice, car.Price = car.Price, 4500
+	car.History = append(car.History, api.Price{Price: 4500, Time: testTime.Add(3 * time.Hour)})
+	seen := car
+	seen.SeenAt = testTime.Add(time.Minute)
+	if err := s.PutItems("1/bike", map[string]api.Item{"car": seen}); err != nil {
+		t.Fatal(err)
+	}
+	items, err = s.Items("1/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkItem(t, items["car"], car)
+	checkItem(t, items["bike"], bike)
+}
+
+func testPruneAge(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+
+	now := time.Now().UTC()
+	putTestItems(t, s, "1/bike",
+		testItem("new", 1, now),
+		testItem("recent", 2, now.Add(-2*time.Hour)),
+		testItem("old", 3, now.Add(-48*time.Hour)),
+	)
+	putTestItems(t, s, "2/lamp", testItem("other", 4, now.Add(-48*time.Hour)))
+	n, err := s.Prune("1/bike", 24*time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("pruned %d items, want 1", n)
+	}
+	items, err := s.Items("1/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := items["old"]; ok || len(items) != 2 {
+		t.Errorf("got items %v, want new and recent", items)
+	}
+	// Other searches and their items aren't pruned
+	checkKeys(t, s, itemsBucket, "new", "other", "recent")
+}
+
+func testPruneCount(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+
+	now := time.Now().UTC()
+	var items []api.Item
+	for n := 0; n < 5; n++ {
+		i := testItem(fmt.Sprintf("item%d", n), 1, now.Add(-time.Duration(n)*2*time.Hour))
+		// item3 is still listed
+		if n != 3 {
+			i.GoneAt = testTime
+		}
+		items = append(items, i)
+	}
+	putTestItems(t, s, "1/bike", items...)
+	n, err := s.Prune("1/bike", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("pruned %d items, want 3", n)
+	}
+	// Listed items are kept first, then the most recently seen
+	checkKeys(t, s, itemsBucket, "item0", "item3")
+	if n, err := s.Prune("1/bike", 0, 0); err != nil || n != 0 {
+		t.Errorf("pruned %d items (%v) without limits, want 0", n, err)
+	}
+}
+
+func testPruneUnseen(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+
+	old := time.Now().Add(-48 * time.Hour).UTC()
+	unseen := testItem("unseen", 1, time.Time{})
+	putTestItems(t, s, "1/bike", unseen, testItem("old", 2, old))
+	n, err := s.Prune("1/bike", 24*time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("pruned %d items, want 1", n)
+	}
+	checkKeys(t, s, itemsBucket, "unseen")
+}
+
+// fillStore stores a sample of every kind of data and returns the items.
+func fillStore(t *testing.T, s Store) map[string]api.Item {
+	t.Helper()
+	if err := s.Put("config", "1", "chat"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("meta", "1/bike", map[string]string{"name": "bikes"}); err != nil {
+		t.Fatal(err)
+	}
+	bike, car := testItem("bike", 100, testTime), testItem("car", 5000, testTime)
+	putTestItems(t, s, "1/bike", bike, car)
+	return map[string]api.Item{"bike": bike, "car": car}
+}
+
+// checkFilled checks the data stored by fillStore.
+func checkFilled(t *testing.T, s Store, want map[string]api.Item) {
+	t.Helper()
+	var chat string
+	if err := s.Get("config", "1", &chat); err != nil {
+		t.Fatal(err)
+	}
+	if chat != "chat" {
+		t.Errorf("got config %q, want chat", chat)
+	}
+	checkKeys(t, s, "meta", "1/bike")
+	checkKeys(t, s, searchesBucket, "1/bike")
+	items, err := s.Items("1/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(items), len(want))
+	}
+	for id, i := range want {
+		checkItem(t, items[id], i)
+	}
+}
+
+func testDumpImport(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+	want := fillStore(t, s)
+	dump, err := s.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Dumps are json encoded by the db dump command
+	data, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]*Bucket
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	// Dumps can be imported in any backend
+	for _, b := range backends {
+		dst, err := b.open(filepath.Join(t.TempDir(), "import.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.Import(decoded); err != nil {
+			t.Fatalf("%s: %v", b.name, err)
+		}
+		checkFilled(t, dst, want)
+		dst.Close()
+	}
+}
+
+func testCompact(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, _ := open(t)
+	defer s.Close()
+	want := fillStore(t, s)
+	for n := 0; n < 500; n++ {
+		if err := s.Put("queue", fmt.Sprintf("%04d", n), strings.Repeat("x", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for n := 0; n < 500; n++ {
+		if err := s.Delete("queue", fmt.Sprintf("%04d", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, after, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after > before {
+		t.Errorf("size after compacting = %d, want at most %d", after, before)
+	}
+	checkFilled(t, s, want)
+	// The store is still writable
+	if err := s.Put("config", "2", "other"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testCopy(t *testing.T, open func(t *testing.T) (Store, string)) {
+	src, _ := open(t)
+	defer src.Close()
+	want := fillStore(t, src)
+	for _, b := range backends {
+		dst, err := b.open(filepath.Join(t.TempDir(), "copy.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Copy(dst, src); err != nil {
+			t.Fatalf("%s: %v", b.name, err)
+		}
+		checkFilled(t, dst, want)
+		dst.Close()
+	}
+}
+
+func testRestore(t *testing.T, open func(t *testing.T) (Store, string)) {
+	s, path := open(t)
+	want := fillStore(t, s)
+	backup := filepath.Join(t.TempDir(), "backup.db")
+	if _, err := s.BackupFile(backup); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("config", "2", "after backup"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	if err := Restore(backup, path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".bak"); err != nil {
+		t.Errorf("previous database wasn't kept: %v", err)
+	}
+	restored, err := Open("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	checkFilled(t, restored, want)
+	checkKeys(t, restored, "config", "1")
+
+	if err := Restore(filepath.Join(t.TempDir(), "missing.db"), path); err == nil {
+		t.Error("expected error restoring a missing backup")
+	}
+}
@@ -0,0 +1,144 @@
+package wallabot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+// Admin alert policy: repeated errors are forwarded once per alertDedup and
+// at most alertBurst alerts are sent per alertWindow.
+const (
+	alertDedup  = time.Hour
+	alertWindow = 10 * time.Minute
+	alertBurst  = 5
+)
+
+// parseLevel parses a log level name.
+func parseLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return l, fmt.Errorf("invalid log level %q, valid levels are debug, info, warn and error", s)
+	}
+	return l, nil
+}
+
+// newLogger returns a logger with the given format, text or json, whose
+// errors are also sent to the admin.
+func (b *bot) newLogger(w io.Writer, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: b.level}
+	var h slog.Handler
+	switch format {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q, valid formats are text and json", format)
+	}
+	return slog.New(&alertHandler{Handler: h, alerter: &alerter{
+		bot:  b,
+		seen: cache.New(alertDedup, alertDedup),
+	}}), nil
+}
+
+// alertHandler forwards error records to the admin.
+type alertHandler struct {
+	slog.Handler
+	alerter *alerter
+	attrs   []slog.Attr
+}
+
+func (h *alertHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		h.alerter.alert(r, h.attrs)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *alertHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &alertHandler{
+		Handler: h.Handler.WithAttrs(attrs),
+		alerter: h.alerter,
+		attrs:   append(append([]slog.Attr{}, h.attrs...), attrs...),
+	}
+}
+
+func (h *alertHandler) WithGroup(name string) slog.Handler {
+	return &alertHandler{Handler: h.Handler.WithGroup(name), alerter: h.alerter, attrs: h.attrs}
+}
+
+// alerter sends deduplicated and rate limited alerts to the admin.
+type alerter struct {
+	bot        *bot
+	seen       *cache.Cache
+	lock       sync.Mutex
+	window     time.Time
+	sent       int
+	suppressed int
+}
+
+func (a *alerter) alert(r slog.Record, attrs []slog.Attr) {
+	lines := []string{fmt.Sprintf("🚨 %s", r.Message)}
+	for _, attr := range attrs {
+		lines = append(lines, fmt.Sprintf("%s: %s", attr.Key, attr.Value))
+	}
+	r.Attrs(func(attr slog.Attr) bool {
+		lines = append(lines, fmt.Sprintf("%s: %s", attr.Key, attr.Value))
+		return true
+	})
+	text := strings.Join(lines, "\n")
+	if _, ok := a.seen.Get(text); ok {
+		return
+	}
+	a.seen.SetDefault(text, struct{}{})
+
+	a.lock.Lock()
+	now := time.Now()
+	if now.Sub(a.window) > alertWindow {
+		a.window = now
+		a.sent = 0
+	}
+	if a.sent >= alertBurst {
+		a.suppressed++
+		a.lock.Unlock()
+		return
+	}
+	a.sent++
+	if a.suppressed > 0 {
+		text = fmt.Sprintf("%s\n\n(%d alerts suppressed)", text, a.suppressed)
+		a.suppressed = 0
+	}
+	a.lock.Unlock()
+
+	if a.bot.queue != nil {
+		a.bot.queue.push(&outbound{Chat: strconv.Itoa(a.bot.admin), Text: text})
+	}
+}
+
+// logLevel handles the /loglevel command with format [debug|info|warn|error]
+func (b *bot) logLevel(user int, args string) {
+	if user != b.admin {
+		b.message(user, "only the admin can change the log level")
+		return
+	}
+	if args == "" {
+		b.message(user, fmt.Sprintf("log level: %s", strings.ToLower(b.level.Level().String())))
+		return
+	}
+	l, err := parseLevel(args)
+	if err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	b.level.Set(l)
+	slog.Info("log level changed", "level", l)
+	b.message(user, fmt.Sprintf("log level set to %s", strings.ToLower(l.String())))
+}
@@ -0,0 +1,39 @@
+package wallabot
+
+import (
+	"github.com/igolaizola/wallabot/internal/metrics"
+)
+
+var (
+	cycleDuration = metrics.NewHistogram("wallabot_search_cycle_duration_seconds",
+		"Duration of a cycle running all the searches.", []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800})
+	searchDuration = metrics.NewHistogram("wallabot_search_duration_seconds",
+		"Duration of each search.", []float64{.5, 1, 2.5, 5, 10, 30, 60, 120}, "search")
+	itemsSeen = metrics.NewCounter("wallabot_items_seen_total",
+		"Items returned by searches.")
+	itemsNew = metrics.NewCounter("wallabot_items_new_total",
+		"New items found by searches.")
+	priceDrops = metrics.NewCounter("wallabot_price_drops_total",
+		"Price drops found by searches.")
+	notificationsSent = metrics.NewCounter("wallabot_notifications_sent_total",
+		"Notifications sent by notifier.", "notifier")
+	notificationsFailed = metrics.NewCounter("wallabot_notifications_failed_total",
+		"Notifications that couldn't be sent by notifier.", "notifier")
+	itemsPruned = metrics.NewCounter("wallabot_items_pruned_total",
+		"Items removed by the retention of their searches.")
+	telegramFlood = metrics.NewCounter("wallabot_telegram_flood_errors_total",
+		"Telegram 429 too many requests errors.")
+)
+
+// registerGauges registers the metrics whose values are read from the bot.
+func (b *bot) registerGauges() {
+	metrics.NewGaugeFunc("wallabot_queue_depth", "Messages waiting to be sent to telegram.", func() float64 {
+		return float64(b.queue.depth())
+	})
+	metrics.NewGaugeFunc("wallabot_db_size_bytes", "Size of the database file.", func() float64 {
+		return float64(b.db.Size())
+	})
+	metrics.NewGaugeFunc("wallabot_searches", "Number of searches.", func() float64 {
+		return float64(len(b.list()))
+	})
+}
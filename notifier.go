@@ -0,0 +1,116 @@
+package wallabot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/notify"
+)
+
+// telegramNotifier sends events as telegram messages through the queue.
+type telegramNotifier struct {
+	bot *bot
+	// chat overrides the chat of the search if set
+	chat string
+}
+
+func (n *telegramNotifier) Send(ctx context.Context, e notify.Event) error {
+	chat := e.Search.Chat
+	if n.chat != "" {
+		chat = n.chat
+	}
+	parsed := parsedArgs{
+		id:    e.Search.ID,
+		chat:  chat,
+		query: e.Search.Query,
+		name:  e.Search.Name,
+		tags:  e.Search.Tags,
+	}
+	if e.Kind == notify.KindGone {
+		return notify.ErrSkipped
+	}
+	text, ok := n.bot.template(e)
+	if !ok && e.Kind == notify.KindPriceDrop {
+		text = priceDownMessage(e.Item, parsed)
+	} else if !ok {
+		text = newAdMessage(e.Item, parsed)
+	}
+	n.bot.message(chat, text)
+	return nil
+}
+
+// notifiers creates the notifiers defined in the config.
+func (b *bot) notifiers(cfgs []notify.Config) (map[string]notify.Notifier, error) {
+	notifiers := make(map[string]notify.Notifier)
+	for _, cfg := range cfgs {
+		if _, ok := notifiers[cfg.Name]; ok {
+			return nil, fmt.Errorf("duplicated notifier %s", cfg.Name)
+		}
+		if cfg.Type == "telegram" {
+			notifiers[cfg.Name] = &telegramNotifier{bot: b, chat: cfg.URL}
+			continue
+		}
+		cfg.DB = b.db
+		secret, err := b.secret("notify_secret")
+		if err != nil {
+			return nil, err
+		}
+		cfg.Secret = secret
+		n, err := notify.New(cfg)
+		if err != nil {
+			return nil, err
+		}
+		notifiers[cfg.Name] = n
+	}
+	return notifiers, nil
+}
+
+// notify sends an event to the chat of the search and to its targets.
+func (b *bot) notify(ctx context.Context, parsed parsedArgs, i api.Item, kind notify.Kind) {
+	e := notify.Event{
+		Kind: kind,
+		Item: i,
+		Search: notify.Search{
+			ID:    parsed.id,
+			Name:  parsed.name,
+			Tags:  parsed.tags,
+			Chat:  parsed.chat,
+			Query: parsed.query,
+		},
+		Time: time.Now().UTC(),
+	}
+	// The chat of the search can be a notifier name instead of a telegram chat
+	name := parsed.chat
+	main, ok := b.getNotifier(name)
+	if !ok {
+		name = "telegram"
+		main = b.telegram
+	}
+	b.deliver(ctx, name, main, parsed, e)
+	for _, t := range parsed.targets {
+		n, ok := b.getNotifier(t)
+		if !ok {
+			slog.Error("notifier not found", "notifier", t, "search", parsed.label())
+			continue
+		}
+		b.deliver(ctx, t, n, parsed, e)
+	}
+}
+
+// deliver sends an event with a notifier and counts the result, skipped
+// events aren't counted.
+func (b *bot) deliver(ctx context.Context, name string, n notify.Notifier, parsed parsedArgs, e notify.Event) {
+	err := n.Send(ctx, e)
+	switch {
+	case errors.Is(err, notify.ErrSkipped):
+	case err != nil:
+		notificationsFailed.Inc(name)
+		slog.Error("couldn't notify", "item", e.Item.ID, "search", parsed.label(), "notifier", name, "err", err)
+	default:
+		notificationsSent.Inc(name)
+	}
+}
@@ -0,0 +1,204 @@
+package wallabot
+
+// openAPISpec describes the json api served under /api/v1/
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "wallabot api",
+    "version": "1.0.0",
+    "description": "Manage wallabot searches and read the items they found. Tokens are created with the /token telegram command."
+  },
+  "servers": [{"url": "/api/v1"}],
+  "security": [{"bearer": []}],
+  "paths": {
+    "/health": {
+      "get": {
+        "summary": "Health check",
+        "security": [],
+        "responses": {
+          "200": {"description": "All subsystems are healthy", "content": {"application/json": {"schema": {"type": "object"}}}},
+          "503": {"description": "A subsystem hasn't succeeded within its staleness threshold", "content": {"application/json": {"schema": {"type": "object"}}}}
+        }
+      }
+    },
+    "/stats": {
+      "get": {
+        "summary": "Stats of the searches of the token user and global stats",
+        "responses": {"200": {"description": "Stats", "content": {"application/json": {"schema": {"type": "object"}}}}}
+      }
+    },
+    "/searches": {
+      "get": {
+        "summary": "List the searches of the chats of the token user, all of them for the admin",
+        "responses": {"200": {"description": "Searches", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Search"}}}}}}
+      },
+      "post": {
+        "summary": "Create a search",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SearchRequest"}}}},
+        "responses": {
+          "201": {"description": "Created search", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Search"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "409": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/searches/{id}": {
+      "parameters": [{"$ref": "#/components/parameters/SearchID"}],
+      "get": {
+        "summary": "Get a search",
+        "responses": {
+          "200": {"description": "Search", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Search"}}}},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "patch": {
+        "summary": "Update a search, changing the chat or query replaces it with a new one",
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SearchRequest"}}}},
+        "responses": {
+          "200": {"description": "Updated search", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Search"}}}},
+          "400": {"$ref": "#/components/responses/Error"},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      },
+      "delete": {
+        "summary": "Delete a search and its items",
+        "responses": {
+          "200": {"description": "Deleted search", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Search"}}}},
+          "403": {"$ref": "#/components/responses/Error"},
+          "404": {"$ref": "#/components/responses/Error"}
+        }
+      }
+    },
+    "/searches/{id}/pause": {
+      "parameters": [{"$ref": "#/components/parameters/SearchID"}],
+      "post": {
+        "summary": "Pause a search",
+        "responses": {"200": {"description": "Paused search", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Search"}}}}}
+      }
+    },
+    "/searches/{id}/resume": {
+      "parameters": [{"$ref": "#/components/parameters/SearchID"}],
+      "post": {
+        "summary": "Resume a search",
+        "responses": {"200": {"description": "Resumed search", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Search"}}}}}
+      }
+    },
+    "/searches/{id}/stats": {
+      "parameters": [{"$ref": "#/components/parameters/SearchID"}],
+      "get": {
+        "summary": "Stats of a search",
+        "responses": {"200": {"description": "Stats", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Stats"}}}}}
+      }
+    },
+    "/searches/{id}/items": {
+      "parameters": [
+        {"$ref": "#/components/parameters/SearchID"},
+        {"$ref": "#/components/parameters/Since"},
+        {"$ref": "#/components/parameters/Until"},
+        {"$ref": "#/components/parameters/Min"},
+        {"$ref": "#/components/parameters/Max"},
+        {"$ref": "#/components/parameters/Text"},
+        {"$ref": "#/components/parameters/Limit"}
+      ],
+      "get": {
+        "summary": "List the items of a search, newest first",
+        "responses": {"200": {"description": "Items", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}}}}}
+      }
+    },
+    "/items": {
+      "parameters": [
+        {"name": "search", "in": "query", "schema": {"type": "string"}, "description": "Search id, name or key"},
+        {"$ref": "#/components/parameters/Since"},
+        {"$ref": "#/components/parameters/Until"},
+        {"$ref": "#/components/parameters/Min"},
+        {"$ref": "#/components/parameters/Max"},
+        {"$ref": "#/components/parameters/Text"},
+        {"$ref": "#/components/parameters/Limit"}
+      ],
+      "get": {
+        "summary": "List the items of all searches, newest first",
+        "responses": {"200": {"description": "Items", "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Item"}}}}}}
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "SearchID": {"name": "id", "in": "path", "required": true, "schema": {"type": "string"}, "description": "Search id or name"},
+      "Since": {"name": "since", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+      "Until": {"name": "until", "in": "query", "schema": {"type": "string", "format": "date-time"}},
+      "Min": {"name": "min", "in": "query", "schema": {"type": "number"}},
+      "Max": {"name": "max", "in": "query", "schema": {"type": "number"}},
+      "Text": {"name": "q", "in": "query", "schema": {"type": "string"}, "description": "Text contained in the title"},
+      "Limit": {"name": "limit", "in": "query", "schema": {"type": "integer", "default": 100}}
+    },
+    "responses": {
+      "Error": {
+        "description": "Error",
+        "content": {"application/json": {"schema": {"type": "object", "properties": {"error": {"type": "string"}}}}}
+      }
+    },
+    "schemas": {
+      "Search": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "key": {"type": "string"},
+          "name": {"type": "string"},
+          "tags": {"type": "array", "items": {"type": "string"}},
+          "targets": {"type": "array", "items": {"type": "string"}},
+          "chat": {"type": "string"},
+          "query": {"type": "string", "example": "rtx+3080:broken?code=48001&km=30&min=50&max=300"},
+          "paused": {"type": "boolean"},
+          "managed": {"type": "boolean", "description": "Defined in the config file, it can't be modified through the api"},
+          "items": {"type": "integer"},
+          "last_run": {"type": "string", "format": "date-time"},
+          "last_error": {"type": "string"}
+        }
+      },
+      "SearchRequest": {
+        "type": "object",
+        "properties": {
+          "name": {"type": "string"},
+          "tags": {"type": "array", "items": {"type": "string"}},
+          "targets": {"type": "array", "items": {"type": "string"}},
+          "chat": {"type": "string"},
+          "query": {"type": "string"},
+          "paused": {"type": "boolean"}
+        }
+      },
+      "Stats": {
+        "type": "object",
+        "properties": {
+          "items": {"type": "integer"},
+          "price_drops": {"type": "integer"},
+          "gone": {"type": "integer"},
+          "min_price": {"type": "number"},
+          "max_price": {"type": "number"},
+          "avg_price": {"type": "number"}
+        }
+      },
+      "Item": {
+        "type": "object",
+        "properties": {
+          "id": {"type": "string"},
+          "link": {"type": "string"},
+          "title": {"type": "string"},
+          "price": {"type": "number"},
+          "previous_price": {"type": "number"},
+          "image": {"type": "string"},
+          "history": {"type": "array", "items": {"type": "object", "properties": {"price": {"type": "number"}, "time": {"type": "string", "format": "date-time"}}}},
+          "created_at": {"type": "string", "format": "date-time"},
+          "seen_at": {"type": "string", "format": "date-time"},
+          "gone_at": {"type": "string", "format": "date-time"},
+          "search": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
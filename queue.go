@@ -0,0 +1,242 @@
+package wallabot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// Telegram limits, see https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	globalInterval  = time.Second / 30
+	privateInterval = time.Second
+	groupInterval   = time.Minute / 20
+	maxAttempts     = 5
+)
+
+// outbound is a message waiting to be sent.
+type outbound struct {
+	Key      string                         `json:"-"`
+	Chat     string                         `json:"chat"`
+	Text     string                         `json:"text"`
+	Preview  bool                           `json:"preview"`
+	Buttons  [][]tgbot.InlineKeyboardButton `json:"buttons,omitempty"`
+	Attempts int                            `json:"attempts"`
+}
+
+// queue sends messages respecting telegram flood limits. Pending messages are
+// persisted on the store so they aren't lost between restarts.
+type queue struct {
+	send    func(tgbot.Chattable) (tgbot.Message, error)
+	db      store.Store
+	admin   string
+	health  *health
+	lock    sync.Mutex
+	pending []*outbound
+	next    map[string]time.Time
+	global  time.Time
+	signal  chan struct{}
+	seq     uint64
+
+	globalInterval  time.Duration
+	privateInterval time.Duration
+	groupInterval   time.Duration
+	maxAttempts     int
+}
+
+func newQueue(db store.Store, admin int, send func(tgbot.Chattable) (tgbot.Message, error)) (*queue, error) {
+	q := &queue{
+		send:   send,
+		db:     db,
+		admin:  strconv.Itoa(admin),
+		next:   make(map[string]time.Time),
+		signal: make(chan struct{}, 1),
+
+		globalInterval:  globalInterval,
+		privateInterval: privateInterval,
+		groupInterval:   groupInterval,
+		maxAttempts:     maxAttempts,
+	}
+	keys, err := db.Keys("queue")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var o outbound
+		if err := db.Get("queue", k, &o); err != nil {
+			return nil, err
+		}
+		o.Key = k
+		q.pending = append(q.pending, &o)
+	}
+	return q, nil
+}
+
+// setLimits changes the rate limits and the attempts to send a message.
+func (q *queue) setLimits(global, private, group time.Duration, attempts int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.globalInterval = global
+	q.privateInterval = private
+	q.groupInterval = group
+	q.maxAttempts = attempts
+}
+
+// depth returns the number of pending messages.
+func (q *queue) depth() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.pending)
+}
+
+// push adds a message to the queue. It's persisted before it can be sent,
+// so a delivered message isn't stored again and resent after a restart.
+func (q *queue) push(o *outbound) {
+	q.lock.Lock()
+	q.seq++
+	o.Key = fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), q.seq%1000000)
+	q.lock.Unlock()
+	if err := q.db.Put("queue", o.Key, o); err != nil {
+		slog.Error("couldn't persist message", "chat", o.Chat, "err", err)
+	}
+	q.lock.Lock()
+	q.pending = append(q.pending, o)
+	q.lock.Unlock()
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run sends pending messages until the context is cancelled.
+func (q *queue) run(ctx context.Context) {
+	for {
+		o, wait := q.pop()
+		if o == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.signal:
+			case <-time.After(wait):
+			}
+			continue
+		}
+		q.deliver(o)
+	}
+}
+
+// pop returns the first message whose chat isn't rate limited, or the time
+// to wait until one is available.
+func (q *queue) pop() (*outbound, time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	now := time.Now()
+	if wait := q.global.Sub(now); wait > 0 {
+		return nil, wait
+	}
+	wait := time.Minute
+	for i, o := range q.pending {
+		if next := q.next[o.Chat]; next.After(now) {
+			if next.Sub(now) < wait {
+				wait = next.Sub(now)
+			}
+			continue
+		}
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+		q.global = now.Add(q.globalInterval)
+		q.next[o.Chat] = now.Add(q.chatInterval(o.Chat))
+		return o, 0
+	}
+	return nil, wait
+}
+
+func (q *queue) deliver(o *outbound) {
+	msg := tgbot.NewMessageToChannel(o.Chat, o.Text)
+	if len(o.Buttons) > 0 {
+		msg.ReplyMarkup = tgbot.NewInlineKeyboardMarkup(o.Buttons...)
+	}
+	msg.DisableWebPagePreview = !o.Preview
+	_, err := q.send(msg)
+	if q.health != nil {
+		if err == nil {
+			q.health.success(healthTelegram)
+		} else {
+			q.health.failure(healthTelegram, err)
+		}
+	}
+	if err == nil {
+		q.remove(o)
+		return
+	}
+
+	o.Attempts++
+	var tgErr tgbot.Error
+	isTgErr := errors.As(err, &tgErr)
+	retryAfter := time.Duration(o.Attempts) * 2 * time.Second
+	flood := isTgErr && tgErr.RetryAfter > 0
+	if flood {
+		retryAfter = time.Duration(tgErr.RetryAfter) * time.Second
+		telegramFlood.Inc()
+		// Flood errors don't count as failed attempts
+		o.Attempts--
+	}
+	q.lock.Lock()
+	attempts := q.maxAttempts
+	q.lock.Unlock()
+	if o.Attempts >= attempts || (isTgErr && permanent(tgErr)) {
+		q.remove(o)
+		// Errors are alerted to the admin, so failures sending to the admin
+		// are only warnings to avoid a loop
+		level := slog.LevelError
+		if o.Chat == q.admin {
+			level = slog.LevelWarn
+		}
+		slog.Log(context.Background(), level, "message dropped", "chat", o.Chat, "attempts", o.Attempts, "err", err)
+		return
+	}
+	slog.Warn("couldn't send message, retrying", "chat", o.Chat, "retry_after", retryAfter, "err", err)
+	if err := q.db.Put("queue", o.Key, o); err != nil {
+		slog.Error("couldn't persist message", "chat", o.Chat, "err", err)
+	}
+	q.lock.Lock()
+	q.next[o.Chat] = time.Now().Add(retryAfter)
+	if flood {
+		// Flood limits apply to the whole bot, not only to the chat
+		q.global = q.next[o.Chat]
+	}
+	q.pending = append([]*outbound{o}, q.pending...)
+	q.lock.Unlock()
+}
+
+// permanent returns true if retrying the message can't succeed, like chats
+// that don't exist or users that blocked the bot. Telegram describes these
+// errors with the text of their status code.
+func permanent(err tgbot.Error) bool {
+	return strings.HasPrefix(err.Message, "Bad Request") || strings.HasPrefix(err.Message, "Forbidden")
+}
+
+func (q *queue) remove(o *outbound) {
+	if err := q.db.Delete("queue", o.Key); err != nil {
+		slog.Error("couldn't remove message", "chat", o.Chat, "err", err)
+	}
+}
+
+// chatInterval returns the minimum interval between messages to a chat.
+// Private chats have positive ids, groups and channels negative ids or
+// usernames.
+func (q *queue) chatInterval(chat string) time.Duration {
+	if id, err := strconv.ParseInt(chat, 10, 64); err == nil && id > 0 {
+		return q.privateInterval
+	}
+	return q.groupInterval
+}
@@ -0,0 +1,238 @@
+package wallabot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// sender records the messages sent by a queue and answers with the errors
+// in order, nil once they are exhausted.
+type sender struct {
+	lock   sync.Mutex
+	errs   []error
+	texts  []string
+	called chan struct{}
+}
+
+func newSender(errs ...error) *sender {
+	return &sender{errs: errs, called: make(chan struct{}, 100)}
+}
+
+func (s *sender) send(c tgbot.Chattable) (tgbot.Message, error) {
+	s.lock.Lock()
+	defer func() {
+		s.lock.Unlock()
+		s.called <- struct{}{}
+	}()
+	msg := c.(tgbot.MessageConfig)
+	var err error
+	if len(s.errs) > 0 {
+		err, s.errs = s.errs[0], s.errs[1:]
+	}
+	if err == nil {
+		s.texts = append(s.texts, msg.Text)
+	}
+	return tgbot.Message{}, err
+}
+
+func (s *sender) sent() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.texts...)
+}
+
+func newTestQueue(t *testing.T, send func(tgbot.Chattable) (tgbot.Message, error)) (*queue, store.Store) {
+	t.Helper()
+	db, err := store.NewBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	q, err := newQueue(db, testAdmin, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return q, db
+}
+
+func checkStored(t *testing.T, db store.Store, want int) {
+	t.Helper()
+	keys, err := db.Keys("queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != want {
+		t.Errorf("got %d stored messages, want %d", len(keys), want)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	q, _ := newTestQueue(t, nil)
+	q.setLimits(0, time.Hour, 2*time.Hour, maxAttempts)
+	for _, chat := range []string{"2", "2", "-100", "-100", "3"} {
+		q.push(&outbound{Chat: chat, Text: chat})
+	}
+
+	// A message per chat is available, private and group chats have their
+	// own intervals
+	var popped []string
+	for {
+		o, wait := q.pop()
+		if o == nil {
+			if wait <= 0 || wait > time.Hour {
+				t.Errorf("wait = %s, want the private interval at most", wait)
+			}
+			break
+		}
+		popped = append(popped, o.Chat)
+	}
+	if fmt.Sprint(popped) != "[2 -100 3]" {
+		t.Errorf("popped %v, want a message of each chat", popped)
+	}
+	if next := q.next["-100"].Sub(q.next["2"]); next < 59*time.Minute {
+		t.Errorf("group chat is available %s after the private one, want an hour", next)
+	}
+
+	// The global interval applies to all chats
+	q.setLimits(time.Hour, 0, 0, maxAttempts)
+	q.next = make(map[string]time.Time)
+	if o, _ := q.pop(); o == nil {
+		t.Fatal("no message available")
+	}
+	if o, wait := q.pop(); o != nil || wait < 59*time.Minute {
+		t.Errorf("popped %v waiting %s, want the global interval", o, wait)
+	}
+}
+
+func TestQueueFlood(t *testing.T) {
+	s := newSender(tgbot.Error{Message: "Too Many Requests: retry after 60", ResponseParameters: tgbot.ResponseParameters{RetryAfter: 60}})
+	q, db := newTestQueue(t, s.send)
+	q.setLimits(0, 0, 0, maxAttempts)
+	q.push(&outbound{Chat: "2", Text: "first"})
+	q.push(&outbound{Chat: "3", Text: "second"})
+
+	o, _ := q.pop()
+	q.deliver(o)
+	// Flood errors pause all the chats and aren't failed attempts
+	if o, wait := q.pop(); o != nil || wait < 59*time.Second {
+		t.Errorf("popped %v waiting %s, want a global pause", o, wait)
+	}
+	if o.Attempts != 0 {
+		t.Errorf("attempts = %d, want 0", o.Attempts)
+	}
+	if q.depth() != 2 || q.pending[0].Text != "first" {
+		t.Errorf("the message wasn't requeued first")
+	}
+	checkStored(t, db, 2)
+}
+
+func TestQueueDrop(t *testing.T) {
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+	}{
+		{"permanent", []error{tgbot.Error{Message: "Forbidden: bot was blocked by the user"}}, 1},
+		{"attempts", []error{errors.New("timeout"), errors.New("timeout")}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSender(tt.errs...)
+			q, db := newTestQueue(t, s.send)
+			q.setLimits(0, 0, 0, 2)
+			q.push(&outbound{Chat: "2", Text: "message"})
+			for n := 0; n < tt.attempts; n++ {
+				o, _ := q.pop()
+				if o == nil {
+					// Wait for the retry of the chat
+					q.next = make(map[string]time.Time)
+					o, _ = q.pop()
+				}
+				q.deliver(o)
+			}
+			if q.depth() != 0 {
+				t.Errorf("got %d pending messages, want it dropped", q.depth())
+			}
+			checkStored(t, db, 0)
+			if len(s.sent()) != 0 {
+				t.Errorf("sent %v, want nothing", s.sent())
+			}
+		})
+	}
+}
+
+func TestQueueReplay(t *testing.T) {
+	q, db := newTestQueue(t, nil)
+	for n := 0; n < 3; n++ {
+		q.push(&outbound{Chat: "2", Text: fmt.Sprintf("message %d", n)})
+	}
+
+	// Pending messages are sent in order after a restart
+	s := newSender()
+	restarted, err := newQueue(db, testAdmin, s.send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.setLimits(0, 0, 0, maxAttempts)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		restarted.run(ctx)
+	}()
+	for n := 0; n < 3; n++ {
+		select {
+		case <-s.called:
+		case <-time.After(5 * time.Second):
+			t.Fatal("messages weren't sent")
+		}
+	}
+	cancel()
+	<-done
+	if fmt.Sprint(s.sent()) != "[message 0 message 1 message 2]" {
+		t.Errorf("sent %v, want the messages in order", s.sent())
+	}
+	checkStored(t, db, 0)
+}
+
+func TestQueuePersistBeforeSend(t *testing.T) {
+	s := newSender()
+	q, db := newTestQueue(t, s.send)
+	q.setLimits(0, 0, 0, maxAttempts)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.run(ctx)
+	}()
+
+	// Messages sent right after being pushed aren't stored again
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q.push(&outbound{Chat: fmt.Sprint(i + 10), Text: "message"})
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.called:
+		case <-time.After(5 * time.Second):
+			t.Fatal("messages weren't sent")
+		}
+	}
+	cancel()
+	<-done
+	checkStored(t, db, 0)
+}
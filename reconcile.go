@@ -0,0 +1,228 @@
+package wallabot
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// Actions of a search change.
+const (
+	actionAdd    = "add"
+	actionUpdate = "update"
+	actionPause  = "pause"
+	actionRemove = "remove"
+)
+
+// searchChange is a change needed to reconcile the configured searches with
+// the stored ones.
+type searchChange struct {
+	action string
+	parsed parsedArgs
+	config SearchConfig
+}
+
+func (c searchChange) String() string {
+	symbol := map[string]string{
+		actionAdd:    "+",
+		actionUpdate: "~",
+		actionPause:  "⏸",
+		actionRemove: "-",
+	}[c.action]
+	return fmt.Sprintf("%s %s %s", symbol, c.action, c.parsed.label())
+}
+
+// planSearches returns the changes needed to make the current searches match
+// the configured ones. Config managed searches that are no longer configured
+// are paused or removed depending on obsolete, searches created from
+// telegram or the api are left untouched.
+func planSearches(current map[string]searchInfo, searches []SearchConfig, obsolete string) ([]searchChange, error) {
+	var changes []searchChange
+	configured := make(map[string]bool)
+	for i, s := range searches {
+		parsed, err := s.parsed()
+		if err != nil {
+			return nil, fmt.Errorf("config: searches[%d].%w", i, err)
+		}
+		configured[parsed.id] = true
+		want := searchInfo{
+			Name:     parsed.name,
+			Tags:     parsed.tags,
+			Targets:  parsed.targets,
+			Paused:   s.Paused,
+			Managed:  true,
+			MaxAge:   s.MaxAge,
+			MaxItems: s.MaxItems,
+		}
+		info, ok := current[parsed.id]
+		switch {
+		case !ok:
+			changes = append(changes, searchChange{action: actionAdd, parsed: parsed, config: s})
+		case !reflect.DeepEqual(normalize(info), normalize(want)):
+			changes = append(changes, searchChange{action: actionUpdate, parsed: parsed, config: s})
+		}
+	}
+
+	var keys []string
+	for k := range current {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		info := current[k]
+		if !info.Managed || configured[k] {
+			continue
+		}
+		parsed, err := parseArgs(k, "")
+		if err != nil {
+			continue
+		}
+		parsed.name, parsed.tags, parsed.targets = info.Name, info.Tags, info.Targets
+		switch obsolete {
+		case "", actionPause:
+			if !info.Paused {
+				changes = append(changes, searchChange{action: actionPause, parsed: parsed})
+			}
+		case actionRemove:
+			changes = append(changes, searchChange{action: actionRemove, parsed: parsed})
+		default:
+			return nil, fmt.Errorf("config: obsolete_searches: invalid value %q, valid values are pause and remove", obsolete)
+		}
+	}
+	return changes, nil
+}
+
+// normalize makes empty and nil slices equal.
+func normalize(info searchInfo) searchInfo {
+	if len(info.Tags) == 0 {
+		info.Tags = nil
+	}
+	if len(info.Targets) == 0 {
+		info.Targets = nil
+	}
+	return info
+}
+
+// reconcile applies the changes needed to match the configured searches.
+func (b *bot) reconcile(searches []SearchConfig, obsolete string) error {
+	current := make(map[string]searchInfo)
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		current[k.(string)] = v.(searchInfo)
+		return true
+	})
+	changes, err := planSearches(current, searches, obsolete)
+	if err != nil {
+		return err
+	}
+	for _, c := range changes {
+		var err error
+		switch c.action {
+		case actionAdd, actionUpdate:
+			err = b.add(c.parsed)
+			if err == nil {
+				err = b.manage(c.parsed, c.config)
+			}
+		case actionPause:
+			err = b.pause(c.parsed, true)
+		case actionRemove:
+			b.stop(c.parsed)
+		}
+		if err != nil {
+			slog.Error("couldn't reconcile search", "search", c.parsed.label(), "action", c.action, "err", err)
+			continue
+		}
+		slog.Info("search reconciled", "search", c.parsed.label(), "action", c.action)
+	}
+	return nil
+}
+
+// manage marks a search as managed by the config file and applies the
+// settings of the config that aren't part of its arguments.
+func (b *bot) manage(parsed parsedArgs, s SearchConfig) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	v, ok := b.searchs.Load(parsed.id)
+	if !ok {
+		return fmt.Errorf("search %s not found", parsed.label())
+	}
+	info := v.(searchInfo)
+	info.Managed = true
+	info.Paused = s.Paused
+	info.MaxAge = s.MaxAge
+	info.MaxItems = s.MaxItems
+	if err := b.db.Put("meta", parsed.id, info); err != nil {
+		return err
+	}
+	b.searchs.Store(parsed.id, info)
+	return nil
+}
+
+// readOnly returns an error if the search is managed by the config file, so
+// it can't be modified from telegram or the api.
+func (b *bot) readOnly(parsed parsedArgs) error {
+	if v, ok := b.searchs.Load(parsed.id); ok && v.(searchInfo).Managed {
+		return errStatus(http.StatusForbidden, "search %s is managed by the config file", parsed.label())
+	}
+	return nil
+}
+
+// DryRun returns the changes that would be applied to the database to match
+// the searches of the configuration, without applying them. The database is
+// opened read-only, so it can be run next to the bot, and a missing database
+// has no searches.
+func DryRun(cfg *Config) (string, error) {
+	current, err := storedSearches(cfg.DBBackend, cfg.DB)
+	if err != nil {
+		return "", err
+	}
+	changes, err := planSearches(current, cfg.Searches, cfg.ObsoleteSearches)
+	if err != nil {
+		return "", err
+	}
+	if len(changes) == 0 {
+		return "searches are up to date", nil
+	}
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// storedSearches returns the searches stored in the database without
+// modifying it.
+func storedSearches(backend, path string) (map[string]searchInfo, error) {
+	current := make(map[string]searchInfo)
+	detected, err := store.Detect(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case detected == "":
+		return current, nil
+	case backend != "" && detected != backend:
+		return nil, fmt.Errorf("store: %s is a %s database, not %s", path, detected, backend)
+	}
+	db, err := store.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	keys, err := db.Searches()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keys {
+		var info searchInfo
+		if err := db.Get("meta", k, &info); err != nil {
+			return nil, err
+		}
+		current[k] = info
+	}
+	return current, nil
+}
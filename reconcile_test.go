@@ -0,0 +1,165 @@
+package wallabot
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+func TestReconcile(t *testing.T) {
+	b := newTestBot(t)
+	searches := []SearchConfig{
+		{Name: "bikes", Chat: "2", Query: "bike", MaxItems: 5},
+		{Name: "lamps", Chat: "3", Query: "lamp", Paused: true},
+	}
+	if err := b.reconcile(searches, ""); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]searchInfo{
+		"2/bike": {Name: "bikes", Managed: true, MaxItems: 5},
+		"3/lamp": {Name: "lamps", Managed: true, Paused: true},
+		"3/car":  {},
+	} {
+		v, ok := b.searchs.Load(key)
+		if !ok {
+			t.Fatalf("search %s not found", key)
+		}
+		if info := v.(searchInfo); info.Name != want.Name || info.Managed != want.Managed ||
+			info.Paused != want.Paused || info.MaxItems != want.MaxItems {
+			t.Errorf("search %s = %+v, want %+v", key, info, want)
+		}
+	}
+	keys, err := b.db.Searches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "2/bike,3/car,3/lamp" {
+		t.Errorf("got stored searches %v, want 2/bike, 3/car and 3/lamp", keys)
+	}
+
+	// Reconciling again changes nothing
+	current := make(map[string]searchInfo)
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		current[k.(string)] = v.(searchInfo)
+		return true
+	})
+	if changes, err := planSearches(current, searches, ""); err != nil || len(changes) != 0 {
+		t.Errorf("got changes %v (%v), want none", changes, err)
+	}
+
+	// Obsolete managed searches are paused by default
+	if err := b.reconcile(searches[1:], ""); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := b.searchs.Load("2/bike"); !ok || !v.(searchInfo).Paused {
+		t.Error("obsolete search wasn't paused")
+	}
+
+	// Or removed, searches created from telegram or the api are kept
+	if err := b.reconcile(searches[1:], actionRemove); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.searchs.Load("2/bike"); ok {
+		t.Error("obsolete search wasn't removed")
+	}
+	if _, ok := b.searchs.Load("3/car"); !ok {
+		t.Error("search not managed by the config file was removed")
+	}
+}
+
+func TestReconcileReadOnly(t *testing.T) {
+	b := newTestBot(t)
+	if err := b.reconcile([]SearchConfig{{Name: "lamps", Chat: "3", Query: "lamp"}}, ""); err != nil {
+		t.Fatal(err)
+	}
+	token := newTestToken(t, b, testBob)
+	for _, tt := range []struct {
+		method string
+		body   string
+	}{
+		{http.MethodPatch, `{"name":"lights"}`},
+		{http.MethodPatch, `{"query":"light"}`},
+		{http.MethodDelete, ""},
+	} {
+		if status := apiCall(t, b, token, tt.method, "searches/lamps", tt.body, nil); status != http.StatusForbidden {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.body, status, http.StatusForbidden)
+		}
+	}
+	v, ok := b.searchs.Load("3/lamp")
+	if !ok || v.(searchInfo).Name != "lamps" {
+		t.Error("managed search was modified")
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	cfg := &Config{
+		DB: path,
+		Searches: []SearchConfig{
+			{Chat: "2", Query: "bike"},
+			{Chat: "3", Query: "lamp"},
+		},
+	}
+
+	// A missing database has no searches and isn't created
+	report, err := DryRun(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != "+ add 2/bike\n+ add 3/lamp" {
+		t.Errorf("unexpected report %q", report)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("database was created: %v", err)
+	}
+
+	db, err := store.NewBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, info := range map[string]searchInfo{
+		"2/bike": {Managed: true},
+		"3/car":  {Managed: true},
+	} {
+		if err := db.AddSearch(key); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Put("meta", key, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The database is locked while the bot is running
+	if _, err := DryRun(cfg); err == nil {
+		t.Error("dry run of a locked database didn't fail")
+	}
+	db.Close()
+
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report, err = DryRun(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if report != "+ add 3/lamp\n⏸ pause 3/car" {
+		t.Errorf("unexpected report %q", report)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("dry run modified the database")
+	}
+
+	cfg.DBBackend = store.BackendSQLite
+	if _, err := DryRun(cfg); err == nil {
+		t.Error("dry run of a database of another backend didn't fail")
+	}
+}
@@ -0,0 +1,198 @@
+package wallabot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/notify"
+)
+
+// apply applies the reloadable settings of the configuration.
+func (b *bot) apply(ctx context.Context, cfg *Config) error {
+	cfg = cfg.withDefaults()
+
+	templates := make(map[notify.Kind]*template.Template)
+	for kind, text := range map[notify.Kind]string{
+		notify.KindNew:       cfg.NewTemplate,
+		notify.KindPriceDrop: cfg.PriceDropTemplate,
+	} {
+		if text == "" {
+			continue
+		}
+		t, err := template.New(string(kind)).Parse(text)
+		if err != nil {
+			return fmt.Errorf("couldn't parse %s template: %w", kind, err)
+		}
+		templates[kind] = t
+	}
+
+	b.cfgLock.Lock()
+	prev := b.cfg
+	b.cfg = cfg
+	b.templates = templates
+	b.cfgLock.Unlock()
+
+	if cfg.LogLevel != "" {
+		l, err := parseLevel(cfg.LogLevel)
+		if err != nil {
+			return err
+		}
+		b.level.Set(l)
+	}
+	b.health.setThresholds(cfg.SearchStale, cfg.TelegramStale)
+	b.queue.setLimits(cfg.GlobalInterval, cfg.PrivateInterval, cfg.GroupInterval, cfg.MaxAttempts)
+	b.client.SetInterval(cfg.RequestInterval)
+	b.setUsers(cfg.Users)
+
+	if prev == nil || !reflect.DeepEqual(prev.Notifiers, cfg.Notifiers) {
+		if err := b.setNotifiers(ctx, cfg.Notifiers); err != nil {
+			return err
+		}
+	}
+	return b.reconcile(cfg.Searches, cfg.ObsoleteSearches)
+}
+
+// reload applies a new configuration while running, settings that require a
+// restart are ignored with a warning.
+func (b *bot) reload(ctx context.Context, cfg *Config) {
+	b.cfgLock.RLock()
+	prev := b.cfg
+	b.cfgLock.RUnlock()
+	for key, changed := range map[string]bool{
+		"token":          cfg.Token != prev.Token,
+		"db":             cfg.DB != prev.DB,
+		"db_backend":     cfg.DBBackend != prev.DBBackend,
+		"admin":          cfg.Admin != prev.Admin,
+		"listen":         cfg.Listen != prev.Listen,
+		"public_url":     cfg.PublicURL != prev.PublicURL,
+		"tls_cert":       cfg.TLSCert != prev.TLSCert,
+		"tls_key":        cfg.TLSKey != prev.TLSKey,
+		"webhook_url":    cfg.WebhookURL != prev.WebhookURL,
+		"webhook_secret": cfg.WebhookSecret != prev.WebhookSecret,
+		"log.format":     cfg.LogFormat != prev.LogFormat,
+	} {
+		if changed {
+			slog.Warn("config key changed, restart required to apply it", "key", key)
+		}
+	}
+	if err := b.apply(ctx, cfg); err != nil {
+		slog.Error("couldn't reload config", "err", err)
+		return
+	}
+	slog.Info("config reloaded")
+}
+
+// searchInterval returns the pause between search cycles.
+func (b *bot) searchInterval() time.Duration {
+	b.cfgLock.RLock()
+	defer b.cfgLock.RUnlock()
+	return b.cfg.SearchInterval
+}
+
+// setUsers updates the users allowed to control the bot, the admin is always
+// allowed.
+func (b *bot) setUsers(users []int) {
+	allowed := map[int]bool{b.admin: true}
+	for _, u := range users {
+		allowed[u] = true
+	}
+	b.chats.Range(func(k interface{}, _ interface{}) bool {
+		if !allowed[k.(int)] {
+			slog.Info("user removed", "user", k.(int))
+			b.chats.Delete(k)
+		}
+		return true
+	})
+	for u := range allowed {
+		if _, ok := b.chats.Load(u); ok {
+			continue
+		}
+		chat := strconv.Itoa(u)
+		var stored string
+		if err := b.db.Get("config", chat, &stored); err != nil {
+			slog.Error("couldn't get chat config", "user", u, "err", err)
+		}
+		if stored != "" {
+			chat = stored
+		}
+		b.chats.Store(u, chat)
+	}
+}
+
+// setNotifiers replaces the notifiers, stopping the background work of the
+// previous ones.
+func (b *bot) setNotifiers(ctx context.Context, cfgs []notify.Config) error {
+	notifiers, err := b.notifiers(cfgs)
+	if err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	for _, n := range notifiers {
+		if r, ok := n.(notify.Runner); ok {
+			b.wg.Add(1)
+			go func() {
+				defer b.wg.Done()
+				r.Run(runCtx)
+			}()
+		}
+	}
+	b.cfgLock.Lock()
+	stop := b.stopNotifiers
+	b.notifier = notifiers
+	b.stopNotifiers = cancel
+	b.cfgLock.Unlock()
+	if stop != nil {
+		stop()
+	}
+	return nil
+}
+
+// getNotifier returns a notifier by its name.
+func (b *bot) getNotifier(name string) (notify.Notifier, bool) {
+	b.cfgLock.RLock()
+	defer b.cfgLock.RUnlock()
+	n, ok := b.notifier[name]
+	return n, ok
+}
+
+// notifyHandler forwards requests with format /notify/<name>/ to notifiers
+// that handle http requests, like unsubscribe links.
+func (b *bot) notifyHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/notify/"), "/", 2)[0]
+	n, ok := b.getNotifier(name)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h, ok := n.(http.Handler)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// template executes the configured template of an event, it returns false if
+// there is no template for its kind.
+func (b *bot) template(e notify.Event) (string, bool) {
+	b.cfgLock.RLock()
+	t, ok := b.templates[e.Kind]
+	b.cfgLock.RUnlock()
+	if !ok {
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, e); err != nil {
+		slog.Error("couldn't execute template", "kind", e.Kind, "err", err)
+		return "", false
+	}
+	return buf.String(), true
+}
@@ -0,0 +1,492 @@
+package wallabot
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// apiToken is stored in the tokens bucket using the sha256 of the token as
+// key, so tokens can't be recovered from the database.
+type apiToken struct {
+	Name      string    `json:"name"`
+	User      int       `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func tokenKey(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// newToken creates an api token for the user.
+func (b *bot) newToken(user int, name string) (string, error) {
+	tokens, err := b.tokens(user)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tokens {
+		if t.Name == name {
+			return "", fmt.Errorf("token %s already exists", name)
+		}
+	}
+	rnd := make([]byte, 24)
+	if _, err := rand.Read(rnd); err != nil {
+		return "", fmt.Errorf("couldn't generate token: %w", err)
+	}
+	token := hex.EncodeToString(rnd)
+	t := apiToken{Name: name, User: user, CreatedAt: time.Now().UTC()}
+	if err := b.db.Put("tokens", tokenKey(token), t); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// revokeToken deletes an api token of the user by name.
+func (b *bot) revokeToken(user int, name string) error {
+	keys, err := b.db.Keys("tokens")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		var t apiToken
+		if err := b.db.Get("tokens", k, &t); err != nil {
+			return err
+		}
+		if t.User == user && t.Name == name {
+			return b.db.Delete("tokens", k)
+		}
+	}
+	return fmt.Errorf("token %s not found", name)
+}
+
+// tokens returns the api tokens of the user.
+func (b *bot) tokens(user int) ([]apiToken, error) {
+	keys, err := b.db.Keys("tokens")
+	if err != nil {
+		return nil, err
+	}
+	var tokens []apiToken
+	for _, k := range keys {
+		var t apiToken
+		if err := b.db.Get("tokens", k, &t); err != nil {
+			return nil, err
+		}
+		if t.User == user {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
+
+// authenticate returns the token of the request if it is valid and its user
+// is still allowed to control the bot.
+func (b *bot) authenticate(r *http.Request) (apiToken, bool) {
+	var t apiToken
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return t, false
+	}
+	if err := b.db.Get("tokens", tokenKey(token), &t); err != nil || t.User == 0 {
+		return t, false
+	}
+	if _, ok := b.chats.Load(t.User); !ok {
+		return t, false
+	}
+	return t, true
+}
+
+// token handles the /token command with format [new|revoke <name>]
+func (b *bot) token(user int, args string) {
+	split := strings.Fields(args)
+	if len(split) == 0 {
+		tokens, err := b.tokens(user)
+		if err != nil {
+			slog.Error("couldn't get api tokens", "user", user, "err", err)
+			return
+		}
+		lines := []string{"api tokens:"}
+		for _, t := range tokens {
+			lines = append(lines, fmt.Sprintf("%s (%s)", t.Name, t.CreatedAt.Format("2006-01-02")))
+		}
+		b.message(user, strings.Join(lines, "\n"))
+		return
+	}
+	if len(split) != 2 {
+		b.message(user, "usage: /token [new|revoke <name>]")
+		return
+	}
+	switch split[0] {
+	case "new":
+		token, err := b.newToken(user, split[1])
+		if err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		b.message(user, fmt.Sprintf("api token %s created, it won't be shown again:\n\n%s", split[1], token))
+	case "revoke":
+		if err := b.revokeToken(user, split[1]); err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		b.message(user, fmt.Sprintf("api token %s revoked", split[1]))
+	default:
+		b.message(user, "usage: /token [new|revoke <name>]")
+	}
+}
+
+// searchRequest is the body to create or update a search, nil fields aren't
+// modified on updates.
+type searchRequest struct {
+	Name    *string   `json:"name"`
+	Tags    *[]string `json:"tags"`
+	Targets *[]string `json:"targets"`
+	Chat    *string   `json:"chat"`
+	Query   *string   `json:"query"`
+	Paused  *bool     `json:"paused"`
+}
+
+// apiError is returned by the api handler with the corresponding status code.
+type apiError struct {
+	status int
+	msg    string
+}
+
+func (e *apiError) Error() string {
+	return e.msg
+}
+
+func errStatus(status int, format string, a ...interface{}) error {
+	return &apiError{status: status, msg: fmt.Sprintf(format, a...)}
+}
+
+// apiHandler serves the json api under /api/v1/
+func (b *bot) apiHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/")
+	split := strings.Split(path, "/")
+
+	// Public endpoints
+	switch path {
+	case "health":
+		b.healthHandler(w, r)
+		return
+	case "openapi.json":
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, openAPISpec)
+		return
+	}
+
+	token, ok := b.authenticate(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+		return
+	}
+
+	var v interface{}
+	var err error
+	status := http.StatusOK
+	switch {
+	case path == "stats" && r.Method == http.MethodGet:
+		v, err = b.apiStats(token.User)
+	case path == "items" && r.Method == http.MethodGet:
+		v, err = b.apiItems(r, token.User, r.URL.Query().Get("search"))
+	case path == "searches" && r.Method == http.MethodGet:
+		v = b.listFor(token.User)
+	case path == "searches" && r.Method == http.MethodPost:
+		var req searchRequest
+		if err = decodeJSON(r, &req); err == nil {
+			v, err = b.createSearch(token.User, req)
+		}
+		status = http.StatusCreated
+	case len(split) == 2 && split[0] == "searches":
+		switch r.Method {
+		case http.MethodGet:
+			v, err = b.apiSearch(token.User, split[1])
+		case http.MethodPatch:
+			var req searchRequest
+			if err = decodeJSON(r, &req); err == nil {
+				v, err = b.updateSearch(token.User, split[1], req)
+			}
+		case http.MethodDelete:
+			v, err = b.apiDelete(token.User, split[1])
+		default:
+			err = errStatus(http.StatusMethodNotAllowed, "method not allowed")
+		}
+	case len(split) == 3 && split[0] == "searches":
+		switch {
+		case split[2] == "items" && r.Method == http.MethodGet:
+			v, err = b.apiItems(r, token.User, split[1])
+		case split[2] == "stats" && r.Method == http.MethodGet:
+			parsed, ok := b.findFor(token.User, split[1])
+			if !ok {
+				err = errStatus(http.StatusNotFound, "search %s not found", split[1])
+				break
+			}
+			v, err = b.searchStats(parsed.id)
+		case (split[2] == "pause" || split[2] == "resume") && r.Method == http.MethodPost:
+			paused := split[2] == "pause"
+			v, err = b.updateSearch(token.User, split[1], searchRequest{Paused: &paused})
+		default:
+			err = errStatus(http.StatusNotFound, "not found")
+		}
+	default:
+		err = errStatus(http.StatusNotFound, "not found")
+	}
+
+	if err != nil {
+		status = http.StatusInternalServerError
+		var apiErr *apiError
+		if errors.As(err, &apiErr) {
+			status = apiErr.status
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, status, v)
+}
+
+func decodeJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return errStatus(http.StatusBadRequest, "invalid body: %v", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (b *bot) apiSearch(user int, ref string) (searchView, error) {
+	parsed, ok := b.findFor(user, ref)
+	if !ok {
+		return searchView{}, errStatus(http.StatusNotFound, "search %s not found", ref)
+	}
+	view, _ := b.view(parsed.id)
+	return view, nil
+}
+
+// createSearch creates a search for the user, its chat is used if the
+// request doesn't provide one. Only the admin can use chats of other users.
+func (b *bot) createSearch(user int, req searchRequest) (searchView, error) {
+	if req.Query == nil || *req.Query == "" {
+		return searchView{}, errStatus(http.StatusBadRequest, "query is required")
+	}
+	chat := strconv.Itoa(user)
+	if v, ok := b.chats.Load(user); ok {
+		chat = v.(string)
+	}
+	if req.Chat != nil && *req.Chat != "" {
+		chat = *req.Chat
+	}
+	if !b.owns(user, chat) {
+		return searchView{}, errStatus(http.StatusForbidden, "chat %s not allowed", chat)
+	}
+	parsed, err := parseArgs(fmt.Sprintf("%s/%s", chat, *req.Query), "")
+	if err != nil {
+		return searchView{}, errStatus(http.StatusBadRequest, "%v", err)
+	}
+	if _, ok := b.searchs.Load(parsed.id); ok {
+		return searchView{}, errStatus(http.StatusConflict, "search %s already exists", parsed.id)
+	}
+	if err := applyRequest(&parsed, req); err != nil {
+		return searchView{}, err
+	}
+	if err := b.add(parsed); err != nil {
+		return searchView{}, errStatus(http.StatusBadRequest, "%v", err)
+	}
+	if req.Paused != nil && *req.Paused {
+		if err := b.pause(parsed, true); err != nil {
+			return searchView{}, err
+		}
+	}
+	view, _ := b.view(parsed.id)
+	return view, nil
+}
+
+// updateSearch modifies a search of the user, changing its chat or query
+// replaces it with a new one.
+func (b *bot) updateSearch(user int, ref string, req searchRequest) (searchView, error) {
+	parsed, ok := b.findFor(user, ref)
+	if !ok {
+		return searchView{}, errStatus(http.StatusNotFound, "search %s not found", ref)
+	}
+	if err := b.readOnly(parsed); err != nil {
+		return searchView{}, err
+	}
+	updated := parsed
+	if req.Chat != nil || req.Query != nil {
+		chat, query := parsed.chat, parsed.query
+		if req.Chat != nil {
+			chat = *req.Chat
+		}
+		if !b.owns(user, chat) {
+			return searchView{}, errStatus(http.StatusForbidden, "chat %s not allowed", chat)
+		}
+		if req.Query != nil {
+			query = *req.Query
+		}
+		var err error
+		updated, err = parseArgs(fmt.Sprintf("%s/%s", chat, query), "")
+		if err != nil {
+			return searchView{}, errStatus(http.StatusBadRequest, "%v", err)
+		}
+		updated.name, updated.tags, updated.targets = parsed.name, parsed.tags, parsed.targets
+	}
+	if err := applyRequest(&updated, req); err != nil {
+		return searchView{}, err
+	}
+	if updated.id != parsed.id {
+		if _, ok := b.searchs.Load(updated.id); ok {
+			return searchView{}, errStatus(http.StatusConflict, "search %s already exists", updated.id)
+		}
+		if err := b.replace(parsed, updated); err != nil {
+			return searchView{}, errStatus(http.StatusBadRequest, "%v", err)
+		}
+	} else if err := b.add(updated); err != nil {
+		return searchView{}, errStatus(http.StatusBadRequest, "%v", err)
+	}
+	if req.Paused != nil {
+		if err := b.pause(updated, *req.Paused); err != nil {
+			return searchView{}, err
+		}
+	}
+	view, _ := b.view(updated.id)
+	return view, nil
+}
+
+// applyRequest sets the metadata fields of the request on the search.
+func applyRequest(parsed *parsedArgs, req searchRequest) error {
+	if req.Name != nil {
+		if strings.ContainsAny(*req.Name, "/? ") {
+			return errStatus(http.StatusBadRequest, "invalid search name %q", *req.Name)
+		}
+		parsed.name = *req.Name
+	}
+	if req.Tags != nil {
+		parsed.tags = nil
+		for _, t := range *req.Tags {
+			parsed.tags = append(parsed.tags, strings.ToLower(t))
+		}
+	}
+	if req.Targets != nil {
+		parsed.targets = nil
+		for _, t := range *req.Targets {
+			parsed.targets = append(parsed.targets, strings.ToLower(t))
+		}
+	}
+	return nil
+}
+
+func (b *bot) apiDelete(user int, ref string) (searchView, error) {
+	parsed, ok := b.findFor(user, ref)
+	if !ok {
+		return searchView{}, errStatus(http.StatusNotFound, "search %s not found", ref)
+	}
+	if err := b.readOnly(parsed); err != nil {
+		return searchView{}, err
+	}
+	view, _ := b.view(parsed.id)
+	b.stop(parsed)
+	return view, nil
+}
+
+// apiItem is an item with the search it belongs to.
+type apiItem struct {
+	api.Item
+	Search string `json:"search"`
+}
+
+// apiItems returns the items of a search or all searches of the user filtered
+// by the query parameters since, until (RFC3339), min, max, q and limit.
+func (b *bot) apiItems(r *http.Request, user int, ref string) ([]apiItem, error) {
+	values := r.URL.Query()
+	f := itemFilter{
+		text:  values.Get("q"),
+		limit: 100,
+	}
+	var err error
+	for k, t := range map[string]*time.Time{"since": &f.since, "until": &f.until} {
+		if v := values.Get(k); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, errStatus(http.StatusBadRequest, "invalid %s %s", k, v)
+			}
+		}
+	}
+	for k, p := range map[string]*float64{"min": &f.min, "max": &f.max} {
+		if v := values.Get(k); v != "" {
+			if *p, err = strconv.ParseFloat(v, 64); err != nil {
+				return nil, errStatus(http.StatusBadRequest, "invalid %s %s", k, v)
+			}
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if f.limit, err = strconv.Atoi(v); err != nil {
+			return nil, errStatus(http.StatusBadRequest, "invalid limit %s", v)
+		}
+	}
+
+	var keys []string
+	if ref != "" {
+		parsed, ok := b.findFor(user, ref)
+		if !ok {
+			return nil, errStatus(http.StatusNotFound, "search %s not found", ref)
+		}
+		keys = append(keys, parsed.id)
+	} else {
+		for _, v := range b.listFor(user) {
+			keys = append(keys, v.Key)
+		}
+	}
+	list := []apiItem{}
+	for _, k := range keys {
+		items, err := b.items(k, f)
+		if err != nil {
+			return nil, err
+		}
+		id := searchID(k)
+		for _, i := range items {
+			list = append(list, apiItem{Item: i, Search: id})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	if f.limit > 0 && len(list) > f.limit {
+		list = list[:f.limit]
+	}
+	return list, nil
+}
+
+// apiStats returns stats of the searches of the user and global stats of the
+// bot.
+func (b *bot) apiStats(user int) (map[string]interface{}, error) {
+	searches := b.listFor(user)
+	var paused, items int
+	for _, s := range searches {
+		if s.Paused {
+			paused++
+		}
+		items += s.Items
+	}
+	return map[string]interface{}{
+		"searches":   len(searches),
+		"paused":     paused,
+		"items":      items,
+		"queue":      b.queue.depth(),
+		"last_cycle": time.Duration(b.elapsed.Load()).String(),
+	}, nil
+}
@@ -0,0 +1,337 @@
+package wallabot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// Users of the test bot, alice and bob use their private chats.
+const (
+	testAdmin = 1
+	testAlice = 2
+	testBob   = 3
+)
+
+func newTestBot(t *testing.T) *bot {
+	t.Helper()
+	db, err := store.NewBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	b := &bot{
+		db:     db,
+		admin:  testAdmin,
+		health: newHealth(time.Hour, time.Hour),
+	}
+	if b.queue, err = newQueue(db, testAdmin, nil); err != nil {
+		t.Fatal(err)
+	}
+	b.setUsers([]int{testAlice, testBob})
+	for _, key := range []string{"2/bike", "3/car"} {
+		parsed, err := parseArgs(key, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.add(parsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func newTestToken(t *testing.T, b *bot, user int) string {
+	t.Helper()
+	token, err := b.newToken(user, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// apiCall calls the api handler and decodes the json response into v if it
+// isn't nil.
+func apiCall(t *testing.T, b *bot, token, method, path, body string, v interface{}) int {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, "/api/v1/"+path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	b.apiHandler(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("%s %s: content type = %q, want application/json", method, path, ct)
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: couldn't decode response %q: %v", method, path, rec.Body.String(), err)
+		}
+	}
+	return rec.Code
+}
+
+func TestAPIAuth(t *testing.T) {
+	b := newTestBot(t)
+	removed := newTestToken(t, b, 4)
+
+	for name, token := range map[string]string{
+		"missing":      "",
+		"invalid":      "00",
+		"removed user": removed,
+	} {
+		var resp map[string]string
+		if status := apiCall(t, b, token, http.MethodGet, "searches", "", &resp); status != http.StatusUnauthorized {
+			t.Errorf("%s token: status = %d, want %d", name, status, http.StatusUnauthorized)
+		}
+		if resp["error"] == "" {
+			t.Errorf("%s token: missing error message", name)
+		}
+	}
+}
+
+func TestAPIPublic(t *testing.T) {
+	b := newTestBot(t)
+
+	var health struct {
+		Healthy    *bool                  `json:"healthy"`
+		Subsystems map[string]interface{} `json:"subsystems"`
+	}
+	apiCall(t, b, "", http.MethodGet, "health", "", &health)
+	if health.Healthy == nil || len(health.Subsystems) == 0 {
+		t.Errorf("unexpected health response %+v", health)
+	}
+
+	var spec struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	if status := apiCall(t, b, "", http.MethodGet, "openapi.json", "", &spec); status != http.StatusOK {
+		t.Fatalf("openapi status = %d, want %d", status, http.StatusOK)
+	}
+	if spec.OpenAPI == "" {
+		t.Error("missing openapi version")
+	}
+	for _, p := range []string{"/searches", "/searches/{id}", "/items", "/stats"} {
+		if _, ok := spec.Paths[p]; !ok {
+			t.Errorf("openapi spec doesn't describe %s", p)
+		}
+	}
+}
+
+func TestAPISearches(t *testing.T) {
+	b := newTestBot(t)
+	token := newTestToken(t, b, testAlice)
+
+	var created searchView
+	status := apiCall(t, b, token, http.MethodPost, "searches", `{"query":"lamp","name":"lamps","tags":["Home"]}`, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d", status, http.StatusCreated)
+	}
+	if created.ID != searchID("2/lamp") || created.Key != "2/lamp" || created.Chat != "2" ||
+		created.Query != "lamp" || created.Name != "lamps" || len(created.Tags) != 1 || created.Tags[0] != "home" {
+		t.Errorf("unexpected created search %+v", created)
+	}
+
+	var list []searchView
+	if status := apiCall(t, b, token, http.MethodGet, "searches", "", &list); status != http.StatusOK {
+		t.Fatalf("list status = %d, want %d", status, http.StatusOK)
+	}
+	if len(list) != 2 || list[0].Key != "2/bike" || list[1].Key != "2/lamp" {
+		t.Errorf("unexpected searches %+v", list)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "searches/lamps", "", http.StatusOK},
+		{http.MethodPost, "searches", `{"query":"lamp"}`, http.StatusConflict},
+		{http.MethodPost, "searches", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "searches", `{`, http.StatusBadRequest},
+		{http.MethodPatch, "searches/lamps", `{"name":"bad name"}`, http.StatusBadRequest},
+		{http.MethodPut, "searches/lamps", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "searches/lamps/items", "", http.StatusOK},
+		{http.MethodGet, "searches/lamps/stats", "", http.StatusOK},
+		{http.MethodPost, "searches/lamps/pause", "", http.StatusOK},
+		{http.MethodGet, "searches/unknown", "", http.StatusNotFound},
+		{http.MethodGet, "unknown", "", http.StatusNotFound},
+		{http.MethodGet, "items?limit=x", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if status := apiCall(t, b, token, tt.method, tt.path, tt.body, nil); status != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, status, tt.status)
+		}
+	}
+
+	var view searchView
+	apiCall(t, b, token, http.MethodGet, "searches/"+created.ID, "", &view)
+	if !view.Paused {
+		t.Error("search wasn't paused")
+	}
+	if status := apiCall(t, b, token, http.MethodDelete, "searches/lamps", "", &view); status != http.StatusOK {
+		t.Fatalf("delete status = %d, want %d", status, http.StatusOK)
+	}
+	if view.Key != "2/lamp" {
+		t.Errorf("deleted search = %q, want 2/lamp", view.Key)
+	}
+	if status := apiCall(t, b, token, http.MethodGet, "searches/lamps", "", nil); status != http.StatusNotFound {
+		t.Errorf("deleted search status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestAPIScope(t *testing.T) {
+	b := newTestBot(t)
+	alice := newTestToken(t, b, testAlice)
+	admin := newTestToken(t, b, testAdmin)
+	bike, car := searchID("2/bike"), searchID("3/car")
+
+	// Searches of other chats aren't visible nor can be modified
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "searches/" + car, "", http.StatusNotFound},
+		{http.MethodPatch, "searches/" + car, `{"name":"mine"}`, http.StatusNotFound},
+		{http.MethodDelete, "searches/" + car, "", http.StatusNotFound},
+		{http.MethodPost, "searches/" + car + "/pause", "", http.StatusNotFound},
+		{http.MethodGet, "searches/" + car + "/items", "", http.StatusNotFound},
+		{http.MethodGet, "searches/" + car + "/stats", "", http.StatusNotFound},
+		{http.MethodGet, "items?search=" + car, "", http.StatusNotFound},
+		{http.MethodPost, "searches", `{"query":"car","chat":"3"}`, http.StatusForbidden},
+		{http.MethodPatch, "searches/" + bike, `{"chat":"3"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if status := apiCall(t, b, alice, tt.method, tt.path, tt.body, nil); status != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, status, tt.status)
+		}
+	}
+	if _, ok := b.searchs.Load("3/car"); !ok {
+		t.Fatal("search of other chat was deleted")
+	}
+
+	var list []searchView
+	apiCall(t, b, alice, http.MethodGet, "searches", "", &list)
+	if len(list) != 1 || list[0].Key != "2/bike" {
+		t.Errorf("unexpected searches %+v", list)
+	}
+	var stats map[string]interface{}
+	apiCall(t, b, alice, http.MethodGet, "stats", "", &stats)
+	if stats["searches"] != float64(1) {
+		t.Errorf("stats searches = %v, want 1", stats["searches"])
+	}
+
+	// The admin manages all chats
+	apiCall(t, b, admin, http.MethodGet, "searches", "", &list)
+	if len(list) != 2 {
+		t.Errorf("got %d searches for the admin, want 2", len(list))
+	}
+	var view searchView
+	if status := apiCall(t, b, admin, http.MethodPatch, "searches/"+car, `{"chat":"2"}`, &view); status != http.StatusOK {
+		t.Fatalf("admin update status = %d, want %d", status, http.StatusOK)
+	}
+	if view.Key != "2/car" {
+		t.Errorf("updated search = %q, want 2/car", view.Key)
+	}
+	if status := apiCall(t, b, admin, http.MethodPost, "searches", `{"query":"boat","chat":"3"}`, nil); status != http.StatusCreated {
+		t.Errorf("admin create status = %d, want %d", status, http.StatusCreated)
+	}
+}
+
+func TestAPIUpdateReplace(t *testing.T) {
+	b := newTestBot(t)
+	token := newTestToken(t, b, testAlice)
+	bike := searchID("2/bike")
+	parsed, err := b.lookup("2/bike", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.PutItems("2/bike", map[string]api.Item{"a1": {ID: "a1", Title: "bike", Price: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	v, _ := b.searchs.Load("2/bike")
+	info := v.(searchInfo)
+	info.Paused, info.MaxAge, info.MaxItems = true, time.Hour, 5
+	b.searchs.Store("2/bike", info)
+
+	car, err := b.lookup("3/car", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	car.name = "car"
+	if err := b.add(car); err != nil {
+		t.Fatal(err)
+	}
+
+	// A failed replacement keeps the search and its items
+	for _, body := range []string{`{"query":"road+bike","targets":["unknown"]}`, `{"query":"road+bike","name":"car"}`} {
+		if status := apiCall(t, b, token, http.MethodPatch, "searches/"+bike, body, nil); status != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", body, status, http.StatusBadRequest)
+		}
+		if _, ok := b.searchs.Load("2/bike"); !ok {
+			t.Fatalf("%s: search was deleted", body)
+		}
+		if items, err := b.db.Items("2/bike"); err != nil || len(items) != 1 {
+			t.Fatalf("%s: got items %v (%v), want 1", body, items, err)
+		}
+	}
+	if _, ok := b.searchs.Load("2/road+bike"); ok {
+		t.Error("failed replacement was added")
+	}
+
+	// The replacement keeps the paused state and the retention
+	var view searchView
+	if status := apiCall(t, b, token, http.MethodPatch, "searches/"+bike, `{"query":"road+bike"}`, &view); status != http.StatusOK {
+		t.Fatalf("update status = %d, want %d", status, http.StatusOK)
+	}
+	if _, ok := b.searchs.Load(parsed.id); ok {
+		t.Error("replaced search wasn't deleted")
+	}
+	v, ok := b.searchs.Load("2/road+bike")
+	if !ok {
+		t.Fatal("replacement wasn't added")
+	}
+	if got := v.(searchInfo); !got.Paused || got.MaxAge != time.Hour || got.MaxItems != 5 {
+		t.Errorf("replacement info = %+v, want paused with the retention", got)
+	}
+}
+
+func TestAPICreatePaused(t *testing.T) {
+	b := newTestBot(t)
+	token := newTestToken(t, b, testAlice)
+
+	if status := apiCall(t, b, token, http.MethodPost, "searches", `{"query":"lamp","paused":true}`, nil); status != http.StatusCreated {
+		t.Fatalf("create status = %d, want %d", status, http.StatusCreated)
+	}
+	// Paused searches never run but are loaded on restart
+	keys, err := b.db.Searches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "2/bike,2/lamp,3/car" {
+		t.Errorf("got stored searches %v, want 2/bike, 2/lamp and 3/car", keys)
+	}
+	var info searchInfo
+	if err := b.db.Get("meta", "2/lamp", &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Paused {
+		t.Error("stored search isn't paused")
+	}
+}
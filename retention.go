@@ -0,0 +1,222 @@
+package wallabot
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pruneCheck is the interval between checks for a pending pruning job.
+const pruneCheck = time.Minute
+
+// compactRatio is the minimum fraction of free space in the database to
+// compact it after pruning.
+const compactRatio = 0.1
+
+// pruning runs the pruning job every prune interval and reports it to the
+// admin. The time of the last job is stored so restarts don't trigger extra
+// jobs.
+func (b *bot) pruning(ctx context.Context) {
+	for {
+		b.cfgLock.RLock()
+		interval := b.cfg.PruneInterval
+		b.cfgLock.RUnlock()
+		var last time.Time
+		if err := b.db.Get("config", "pruned_at", &last); err != nil {
+			slog.Error("couldn't get last pruning time", "err", err)
+		} else if time.Since(last) >= interval {
+			removed, before, after, err := b.prune()
+			if err != nil {
+				slog.Error("couldn't prune database", "err", err)
+			} else if removed > 0 || after < before {
+				b.message(b.admin, pruneText(removed, before, after))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(pruneCheck):
+		}
+	}
+}
+
+// prune removes the items exceeding the retention of each search and
+// compacts the database if enough space can be reclaimed. It returns the
+// number of items removed and the size of the database before and after.
+func (b *bot) prune() (int, int64, int64, error) {
+	if err := b.db.Put("config", "pruned_at", time.Now().UTC()); err != nil {
+		return 0, 0, 0, err
+	}
+	infos := make(map[string]searchInfo)
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		infos[k.(string)] = v.(searchInfo)
+		return true
+	})
+	var keys []string
+	for k := range infos {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	removed := 0
+	for _, k := range keys {
+		maxAge, maxItems := b.retention(infos[k])
+		if maxAge == 0 && maxItems == 0 {
+			continue
+		}
+		n, err := b.db.Prune(k, maxAge, maxItems)
+		if err != nil {
+			slog.Error("couldn't prune search", "search", infos[k].label(k), "err", err)
+			continue
+		}
+		if n > 0 {
+			itemsPruned.Add(float64(n))
+			slog.Info("search pruned", "search", infos[k].label(k), "items", n)
+		}
+		removed += n
+	}
+	size, free := b.db.Size(), b.db.Free()
+	if removed == 0 || float64(free) < compactRatio*float64(size) {
+		slog.Info("database compaction skipped", "size", size, "free", free)
+		return removed, size, size, nil
+	}
+	before, after, err := b.db.Compact()
+	if err != nil {
+		return removed, 0, 0, err
+	}
+	slog.Info("database compacted", "before", before, "after", after)
+	return removed, before, after, nil
+}
+
+// retention returns the retention of a search, its own limits override the
+// defaults.
+func (b *bot) retention(info searchInfo) (time.Duration, int) {
+	b.cfgLock.RLock()
+	maxAge, maxItems := b.cfg.RetentionMaxAge, b.cfg.RetentionMaxItems
+	b.cfgLock.RUnlock()
+	if info.MaxAge > 0 {
+		maxAge = info.MaxAge
+	}
+	if info.MaxItems > 0 {
+		maxItems = info.MaxItems
+	}
+	return maxAge, maxItems
+}
+
+// pruneCommand handles the /prune command, it runs the pruning job now.
+func (b *bot) pruneCommand(user int) {
+	if user != b.admin {
+		b.message(user, "only the admin can prune the database")
+		return
+	}
+	removed, before, after, err := b.prune()
+	if err != nil {
+		slog.Error("couldn't prune database", "err", err)
+		b.message(user, fmt.Sprintf("couldn't prune database: %v", err))
+		return
+	}
+	b.message(user, pruneText(removed, before, after))
+}
+
+// retentionCommand handles the /retention command with format
+// [maxage=<duration>] [maxitems=<n>] <search>, zero values restore the
+// defaults. Without options it shows the retention of the search.
+func (b *bot) retentionCommand(user int, chat string, args string) {
+	var maxAge *time.Duration
+	var maxItems *int
+	fields := strings.Fields(args)
+	for len(fields) > 0 {
+		f := fields[0]
+		if v, ok := strings.CutPrefix(f, "maxage="); ok {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				b.message(user, fmt.Sprintf("invalid max age %q, use a duration like 720h", v))
+				return
+			}
+			maxAge = &d
+		} else if v, ok := strings.CutPrefix(f, "maxitems="); ok {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				b.message(user, fmt.Sprintf("invalid max items %q", v))
+				return
+			}
+			maxItems = &n
+		} else {
+			break
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		b.message(user, "retention arguments not provided")
+		return
+	}
+	parsed, err := b.lookup(strings.Join(fields, " "), chat)
+	if err != nil {
+		b.message(user, err.Error())
+		return
+	}
+	if maxAge != nil || maxItems != nil {
+		if err := b.readOnly(parsed); err != nil {
+			b.message(user, err.Error())
+			return
+		}
+	}
+
+	b.lock.Lock()
+	v, ok := b.searchs.Load(parsed.id)
+	if !ok {
+		b.lock.Unlock()
+		b.message(user, fmt.Sprintf("search %s not found", parsed.label()))
+		return
+	}
+	info := v.(searchInfo)
+	if maxAge != nil || maxItems != nil {
+		if maxAge != nil {
+			info.MaxAge = *maxAge
+		}
+		if maxItems != nil {
+			info.MaxItems = *maxItems
+		}
+		if err := b.db.Put("meta", parsed.id, info); err != nil {
+			b.lock.Unlock()
+			slog.Error("couldn't update search retention", "search", parsed.label(), "err", err)
+			return
+		}
+		b.searchs.Store(parsed.id, info)
+	}
+	b.lock.Unlock()
+
+	age, items := b.retention(info)
+	ageText, itemsText := "unlimited", "unlimited"
+	if age > 0 {
+		ageText = age.String()
+	}
+	if items > 0 {
+		itemsText = strconv.Itoa(items)
+	}
+	b.message(user, fmt.Sprintf("retention of %s\n\nmax age since last seen: %s\nmax items: %s", parsed.label(), ageText, itemsText))
+}
+
+// pruneText returns the report of a pruning job.
+func pruneText(removed int, before, after int64) string {
+	if before == after {
+		return fmt.Sprintf("🧹 pruned %d items, database not compacted (%s)", removed, byteSize(before))
+	}
+	return fmt.Sprintf("🧹 pruned %d items, database compacted from %s to %s (%s reclaimed)",
+		removed, byteSize(before), byteSize(after), byteSize(before-after))
+}
+
+// byteSize formats a number of bytes.
+func byteSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
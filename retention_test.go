@@ -0,0 +1,178 @@
+package wallabot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/store"
+	"github.com/patrickmn/go-cache"
+)
+
+// failingStore is a store whose writes and item reads fail.
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) Put(bucket, key string, val interface{}) error {
+	return errors.New("disk full")
+}
+
+func (failingStore) Items(key string) (map[string]api.Item, error) {
+	return nil, errors.New("disk failure")
+}
+
+func TestPrune(t *testing.T) {
+	b := newTestBot(t)
+	b.cfg = &Config{}
+	if err := b.db.AddSearch("2/bike"); err != nil {
+		t.Fatal(err)
+	}
+	items := make(map[string]api.Item)
+	for n := 0; n < 10; n++ {
+		id := fmt.Sprintf("item%d", n)
+		items[id] = api.Item{ID: id, Title: id, SeenAt: time.Now().Add(-time.Duration(n) * time.Hour)}
+		// Only gone items are pruned to honour the max items
+		if n > 0 {
+			i := items[id]
+			i.GoneAt = time.Now()
+			items[id] = i
+		}
+	}
+	if err := b.db.PutItems("2/bike", items); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to prune, the database isn't compacted
+	removed, before, after, err := b.prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 || before != after {
+		t.Errorf("removed %d items, size %d → %d, want nothing removed nor compacted", removed, before, after)
+	}
+
+	b.cfg.RetentionMaxItems = 4
+	if removed, _, _, err = b.prune(); err != nil {
+		t.Fatal(err)
+	}
+	if removed != 6 {
+		t.Errorf("removed %d items, want 6", removed)
+	}
+}
+
+func TestPruneCommandError(t *testing.T) {
+	b := newTestBot(t)
+	b.cfg = &Config{}
+	b.db = failingStore{Store: b.db}
+
+	b.pruneCommand(testAdmin)
+	if n := b.queue.depth(); n != 1 {
+		t.Fatalf("got %d messages, want 1", n)
+	}
+	if o := b.queue.pending[0]; o.Chat != "1" || !strings.Contains(o.Text, "disk full") {
+		t.Errorf("unexpected message to %s: %q", o.Chat, o.Text)
+	}
+}
+
+// wallapopTransport serves the items of listed as the results of any
+// wallapop search.
+type wallapopTransport struct {
+	listed []string
+}
+
+func (w *wallapopTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	type object struct {
+		ID      string  `json:"id"`
+		Title   string  `json:"title"`
+		Price   float64 `json:"price"`
+		WebSlug string  `json:"web_slug"`
+	}
+	objects := []object{}
+	if r.URL.Query().Get("start") == "0" {
+		for _, id := range w.listed {
+			objects = append(objects, object{ID: id, Title: "bike " + id, Price: 100, WebSlug: "bike-" + id})
+		}
+	}
+	body, err := json.Marshal(map[string]interface{}{"search_objects": objects})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(string(body))),
+		Request:    r,
+	}, nil
+}
+
+// withWallapop prepares the bot to run searches against a fake wallapop.
+func withWallapop(t *testing.T, b *bot, listed ...string) *wallapopTransport {
+	t.Helper()
+	w := &wallapopTransport{listed: listed}
+	prev := http.DefaultTransport
+	http.DefaultTransport = w
+	t.Cleanup(func() { http.DefaultTransport = prev })
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	b.client = api.New(ctx)
+	b.client.SetInterval(0)
+	b.cache = cache.New(6*time.Hour, time.Hour)
+	b.telegram = &telegramNotifier{bot: b}
+	return w
+}
+
+func TestPruneSearch(t *testing.T) {
+	b := newTestBot(t)
+	b.cfg = &Config{RetentionMaxItems: 1}
+	w := withWallapop(t, b, "a1", "a2", "a3")
+	parsed, err := parseArgs("2/bike", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := func() {
+		t.Helper()
+		if _, err := b.search(context.Background(), parsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first search stores the listed items without notifying them
+	search()
+	// a3 is no longer listed
+	w.listed = []string{"a1", "a2"}
+	search()
+	if n := b.queue.depth(); n != 0 {
+		t.Fatalf("got %d messages, want 0", n)
+	}
+
+	removed, _, _, err := b.prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("removed %d items, want the gone one", removed)
+	}
+
+	// Listed items aren't notified again once the dedup cache expires
+	b.cache.Flush()
+	search()
+	if n := b.queue.depth(); n != 0 {
+		t.Errorf("got %d messages after pruning, want 0: %q", n, b.queue.pending[0].Text)
+	}
+	items, err := b.db.Items("2/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Errorf("got %d items, want the 2 listed", len(items))
+	}
+}
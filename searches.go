@@ -0,0 +1,94 @@
+package wallabot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/igolaizola/wallabot/internal/store"
+)
+
+// ExportSearches returns the searches stored in the database with the format
+// of the configuration file. The database is opened read-only.
+func ExportSearches(path string) ([]SearchConfig, error) {
+	db, err := store.OpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	keys, err := db.Searches()
+	if err != nil {
+		return nil, err
+	}
+	var searches []SearchConfig
+	for _, k := range keys {
+		var info searchInfo
+		if err := db.Get("meta", k, &info); err != nil {
+			return nil, err
+		}
+		split := strings.SplitN(k, "/", 2)
+		if len(split) != 2 {
+			continue
+		}
+		searches = append(searches, SearchConfig{
+			Name:     info.Name,
+			Chat:     split[0],
+			Query:    split[1],
+			Tags:     info.Tags,
+			Targets:  info.Targets,
+			Paused:   info.Paused,
+			MaxAge:   info.MaxAge,
+			MaxItems: info.MaxItems,
+		})
+	}
+	return searches, nil
+}
+
+// ImportSearches adds the searches to the database, searches that already
+// exist are skipped. It returns the number of searches added.
+func ImportSearches(path string, searches []SearchConfig) (int, error) {
+	var parsed []parsedArgs
+	for i, s := range searches {
+		p, err := s.parsed()
+		if err != nil {
+			return 0, fmt.Errorf("searches[%d].%w", i, err)
+		}
+		parsed = append(parsed, p)
+	}
+
+	db, err := store.Open("", path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	keys, err := db.Searches()
+	if err != nil {
+		return 0, err
+	}
+	exists := make(map[string]bool)
+	for _, k := range keys {
+		exists[k] = true
+	}
+	added := 0
+	for i, p := range parsed {
+		if exists[p.id] {
+			continue
+		}
+		if err := db.AddSearch(p.id); err != nil {
+			return added, err
+		}
+		info := searchInfo{
+			Name:     p.name,
+			Tags:     p.tags,
+			Targets:  p.targets,
+			Paused:   searches[i].Paused,
+			MaxAge:   searches[i].MaxAge,
+			MaxItems: searches[i].MaxItems,
+		}
+		if err := db.Put("meta", p.id, info); err != nil {
+			return added, err
+		}
+		exists[p.id] = true
+		added++
+	}
+	return added, nil
+}
@@ -0,0 +1,181 @@
+package wallabot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// serve runs the embedded http server until the context is cancelled.
+func (b *bot) serve(ctx context.Context, cfg *Config, mux *http.ServeMux) error {
+	srv := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: mux,
+	}
+	errC := make(chan error, 1)
+	go func() {
+		var err error
+		if cfg.TLSCert != "" {
+			err = srv.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			err = nil
+		}
+		errC <- err
+	}()
+	select {
+	case err := <-errC:
+		return fmt.Errorf("http server failed: %w", err)
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("couldn't shutdown http server: %w", err)
+	}
+	return <-errC
+}
+
+// updates returns the channel of telegram updates, using a webhook if it is
+// configured and long polling otherwise.
+func (b *bot) updates(ctx context.Context, cfg *Config, mux *http.ServeMux) (tgbot.UpdatesChannel, error) {
+	if cfg.WebhookURL != "" {
+		updates, err := b.webhook(ctx, cfg, mux)
+		if err == nil {
+			return updates, nil
+		}
+		slog.Warn("couldn't set webhook, falling back to polling", "err", err)
+	}
+
+	// Telegram doesn't allow polling while a webhook is set
+	if _, err := b.RemoveWebhook(); err != nil {
+		return nil, fmt.Errorf("couldn't remove webhook: %w", err)
+	}
+	u := tgbot.NewUpdate(0)
+	u.Timeout = 60
+	updates, err := b.GetUpdatesChan(u)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get update chan: %w", err)
+	}
+	return updates, nil
+}
+
+// webhookSecret matches the secret tokens allowed by telegram.
+var webhookSecret = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// reservedPaths are the routes of the http server, the webhook can't use
+// them. Paths ending with a slash are prefixes.
+var reservedPaths = []string{"/metrics", "/healthz", "/readyz", "/feeds/", "/api/v1/", "/web/", "/notify/"}
+
+// webhookPath returns the path of the webhook url, it fails if it collides
+// with the routes of the http server. The root path isn't allowed as it
+// would match every route.
+func webhookPath(webhookURL string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", fmt.Errorf("couldn't parse webhook url %s: %w", webhookURL, err)
+	}
+	path := u.Path
+	if path == "" || path == "/" {
+		return "", fmt.Errorf("webhook url %s must have a path", webhookURL)
+	}
+	for _, r := range reservedPaths {
+		if path == r || path == strings.TrimSuffix(r, "/") || (strings.HasSuffix(r, "/") && strings.HasPrefix(path, r)) {
+			return "", fmt.Errorf("webhook path %s is reserved by the http server", path)
+		}
+	}
+	return path, nil
+}
+
+// webhook registers the webhook on telegram and returns the channel where
+// received updates are sent.
+func (b *bot) webhook(ctx context.Context, cfg *Config, mux *http.ServeMux) (tgbot.UpdatesChannel, error) {
+	if cfg.Listen == "" {
+		return nil, errors.New("webhook requires a listen address")
+	}
+	path, err := webhookPath(cfg.WebhookURL)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := cfg.WebhookSecret
+	if secret == "" {
+		rnd := make([]byte, 32)
+		if _, err := rand.Read(rnd); err != nil {
+			return nil, fmt.Errorf("couldn't generate webhook secret: %w", err)
+		}
+		secret = hex.EncodeToString(rnd)
+	}
+
+	updates := make(chan tgbot.Update, b.Buffer)
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var update tgbot.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		select {
+		case updates <- update:
+		case <-r.Context().Done():
+			http.Error(w, "timeout", http.StatusServiceUnavailable)
+		case <-ctx.Done():
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+
+	// The telegram library doesn't support secret tokens, so the request is
+	// made manually.
+	if _, err := b.MakeRequest("setWebhook", url.Values{
+		"url":          {cfg.WebhookURL},
+		"secret_token": {secret},
+	}); err != nil {
+		return nil, fmt.Errorf("couldn't set webhook %s: %w", cfg.WebhookURL, err)
+	}
+	slog.Info("webhook set", "url", cfg.WebhookURL)
+	return updates, nil
+}
+
+// secret returns a random secret stored in the config bucket, generating it
+// if it doesn't exist.
+func (b *bot) secret(name string) ([]byte, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var secret string
+	if err := b.db.Get("config", name, &secret); err != nil {
+		return nil, err
+	}
+	if secret != "" {
+		return hex.DecodeString(secret)
+	}
+	rnd := make([]byte, 32)
+	if _, err := rand.Read(rnd); err != nil {
+		return nil, fmt.Errorf("couldn't generate %s: %w", name, err)
+	}
+	if err := b.db.Put("config", name, hex.EncodeToString(rnd)); err != nil {
+		return nil, err
+	}
+	return rnd, nil
+}
@@ -0,0 +1,404 @@
+package wallabot
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+// searchView is the representation of a search shared by the bot commands
+// and the http api.
+type searchView struct {
+	ID        string     `json:"id"`
+	Key       string     `json:"key"`
+	Name      string     `json:"name,omitempty"`
+	Tags      []string   `json:"tags,omitempty"`
+	Targets   []string   `json:"targets,omitempty"`
+	Chat      string     `json:"chat"`
+	Query     string     `json:"query"`
+	Paused    bool       `json:"paused"`
+	Managed   bool       `json:"managed"`
+	Items     int        `json:"items"`
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// searchID returns an url safe id of a search key.
+func searchID(key string) string {
+	h := sha1.Sum([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func (b *bot) view(key string) (searchView, bool) {
+	v, ok := b.searchs.Load(key)
+	if !ok {
+		return searchView{}, false
+	}
+	info := v.(searchInfo)
+	parsed, err := parseArgs(key, "")
+	if err != nil {
+		return searchView{}, false
+	}
+	view := searchView{
+		ID:      searchID(key),
+		Key:     key,
+		Name:    info.Name,
+		Tags:    info.Tags,
+		Targets: info.Targets,
+		Chat:    parsed.chat,
+		Query:   parsed.query,
+		Paused:  info.Paused,
+		Managed: info.Managed,
+	}
+	if v, ok := b.runs.Load(key); ok {
+		run := v.(searchRun)
+		view.Items = run.items
+		if !run.lastRun.IsZero() {
+			lastRun := run.lastRun
+			view.LastRun = &lastRun
+		}
+		view.LastError = run.lastError
+	}
+	return view, true
+}
+
+// list returns all the searches sorted by key.
+func (b *bot) list() []searchView {
+	var keys []string
+	b.searchs.Range(func(k interface{}, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+	views := []searchView{}
+	for _, k := range keys {
+		if v, ok := b.view(k); ok {
+			views = append(views, v)
+		}
+	}
+	return views
+}
+
+// owns returns true if the user can manage the searches of the chat, its
+// private chat or the one set with /chat. The admin can manage all chats.
+func (b *bot) owns(user int, chat string) bool {
+	if user == b.admin || chat == strconv.Itoa(user) {
+		return true
+	}
+	v, ok := b.chats.Load(user)
+	return ok && v.(string) == chat
+}
+
+// listFor returns the searches of the chats of the user sorted by key.
+func (b *bot) listFor(user int) []searchView {
+	views := []searchView{}
+	for _, v := range b.list() {
+		if b.owns(user, v.Chat) {
+			views = append(views, v)
+		}
+	}
+	return views
+}
+
+// findFor returns a search by id, name or key if it belongs to a chat of
+// the user.
+func (b *bot) findFor(user int, ref string) (parsedArgs, bool) {
+	parsed, ok := b.find(ref)
+	if !ok || !b.owns(user, parsed.chat) {
+		return parsedArgs{}, false
+	}
+	return parsed, true
+}
+
+// find returns a search by id, name or key.
+func (b *bot) find(ref string) (parsedArgs, bool) {
+	var found string
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		key := k.(string)
+		if key == ref || searchID(key) == ref || v.(searchInfo).Name == ref {
+			found = key
+			return false
+		}
+		return true
+	})
+	if found == "" {
+		return parsedArgs{}, false
+	}
+	parsed, err := b.lookup(found, "")
+	if err != nil {
+		return parsedArgs{}, false
+	}
+	return parsed, true
+}
+
+// itemFilter filters the items returned by items.
+type itemFilter struct {
+	since time.Time
+	until time.Time
+	min   float64
+	max   float64
+	text  string
+	limit int
+}
+
+func (f itemFilter) match(i api.Item) bool {
+	if !f.since.IsZero() && i.CreatedAt.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && i.CreatedAt.After(f.until) {
+		return false
+	}
+	if f.min > 0 && i.Price < f.min {
+		return false
+	}
+	if f.max > 0 && i.Price > f.max {
+		return false
+	}
+	if f.text != "" && !strings.Contains(strings.ToLower(i.Title), strings.ToLower(f.text)) {
+		return false
+	}
+	return true
+}
+
+// items returns the items of a search matching the filter, newest first.
+func (b *bot) items(key string, f itemFilter) ([]api.Item, error) {
+	items, err := b.db.Items(key)
+	if err != nil {
+		return nil, err
+	}
+	list := []api.Item{}
+	for _, i := range items {
+		if f.match(i) {
+			list = append(list, i)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	if f.limit > 0 && len(list) > f.limit {
+		list = list[:f.limit]
+	}
+	return list, nil
+}
+
+// add stores a new search or updates the metadata of an existing one.
+func (b *bot) add(parsed parsedArgs) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.check(parsed, parsed.id); err != nil {
+		return err
+	}
+	info := searchInfo{Name: parsed.name, Tags: parsed.tags, Targets: parsed.targets}
+	if v, ok := b.searchs.Load(parsed.id); ok {
+		info.Paused = v.(searchInfo).Paused
+		info.Managed = v.(searchInfo).Managed
+		info.MaxAge = v.(searchInfo).MaxAge
+		info.MaxItems = v.(searchInfo).MaxItems
+	}
+	return b.save(parsed.id, info)
+}
+
+// replace replaces a search with a new one with a different key, which keeps
+// its paused state and retention. The old search isn't modified if the new
+// one can't be added.
+func (b *bot) replace(old, parsed parsedArgs) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.check(parsed, old.id); err != nil {
+		return err
+	}
+	if _, ok := b.searchs.Load(parsed.id); ok {
+		return fmt.Errorf("search %s already exists", parsed.id)
+	}
+	info := searchInfo{Name: parsed.name, Tags: parsed.tags, Targets: parsed.targets}
+	if v, ok := b.searchs.Load(old.id); ok {
+		info.Paused = v.(searchInfo).Paused
+		info.MaxAge = v.(searchInfo).MaxAge
+		info.MaxItems = v.(searchInfo).MaxItems
+	}
+	// The new search is stored before the old one is stopped, so a failed
+	// write doesn't lose both
+	if err := b.save(parsed.id, info); err != nil {
+		return err
+	}
+	b.stop(old)
+	return nil
+}
+
+// check returns an error if the search can't be added, its name can be
+// reused from the search with key owner.
+func (b *bot) check(parsed parsedArgs, owner string) error {
+	if parsed.query == "" {
+		return fmt.Errorf("empty query for %s", parsed.id)
+	}
+	if parsed.name != "" {
+		var dup string
+		b.searchs.Range(func(k interface{}, v interface{}) bool {
+			if k.(string) != owner && v.(searchInfo).Name == parsed.name {
+				dup = k.(string)
+				return false
+			}
+			return true
+		})
+		if dup != "" {
+			return fmt.Errorf("name %s already used by %s", parsed.name, dup)
+		}
+	}
+	for _, t := range parsed.targets {
+		if _, ok := b.getNotifier(t); !ok {
+			return fmt.Errorf("notifier %s not found", t)
+		}
+	}
+	return nil
+}
+
+// save stores the search in the searches and meta buckets, so it's loaded
+// on restart even if it never runs, and starts running it unless paused.
+// It must be called with the lock held.
+func (b *bot) save(key string, info searchInfo) error {
+	if err := b.db.AddSearch(key); err != nil {
+		return err
+	}
+	if err := b.db.Put("meta", key, info); err != nil {
+		return err
+	}
+	b.searchs.Store(key, info)
+	b.hash.Store(sha(key), key)
+	return nil
+}
+
+// lookup finds a search by name or parses the arguments as a search key.
+func (b *bot) lookup(args string, chat string) (parsedArgs, error) {
+	name := strings.Trim(args, " ")
+	found := args
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		if v.(searchInfo).Name == name {
+			found = k.(string)
+			chat = ""
+			return false
+		}
+		return true
+	})
+	parsed, err := parseArgs(found, chat)
+	if err != nil {
+		return parsedArgs{}, err
+	}
+	if v, ok := b.searchs.Load(parsed.id); ok {
+		info := v.(searchInfo)
+		parsed.name = info.Name
+		parsed.tags = info.Tags
+		parsed.targets = info.Targets
+	}
+	return parsed, nil
+}
+
+func (b *bot) pause(parsed parsedArgs, paused bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	v, ok := b.searchs.Load(parsed.id)
+	if !ok {
+		return fmt.Errorf("search %s not found", parsed.label())
+	}
+	info := v.(searchInfo)
+	info.Paused = paused
+	if err := b.db.Put("meta", parsed.id, info); err != nil {
+		return err
+	}
+	b.searchs.Store(parsed.id, info)
+	return nil
+}
+
+// searchStats contains aggregated data of the items of a search.
+type searchStats struct {
+	Items int     `json:"items"`
+	Drops int     `json:"price_drops"`
+	Gone  int     `json:"gone"`
+	Min   float64 `json:"min_price"`
+	Max   float64 `json:"max_price"`
+	Avg   float64 `json:"avg_price"`
+}
+
+// dropped returns whether the price of an item has dropped since it was found.
+func dropped(i api.Item) bool {
+	if i.PreviousPrice > i.Price {
+		return true
+	}
+	return len(i.History) > 0 && i.History[0].Price > i.Price
+}
+
+func (b *bot) searchStats(key string) (searchStats, error) {
+	var stats searchStats
+	items, err := b.db.Items(key)
+	if err != nil {
+		return stats, err
+	}
+	var sum float64
+	for _, i := range items {
+		if dropped(i) {
+			stats.Drops++
+		}
+		if !i.GoneAt.IsZero() {
+			stats.Gone++
+		}
+		if stats.Min == 0 || i.Price < stats.Min {
+			stats.Min = i.Price
+		}
+		if i.Price > stats.Max {
+			stats.Max = i.Price
+		}
+		sum += i.Price
+	}
+	stats.Items = len(items)
+	if stats.Items > 0 {
+		stats.Avg = sum / float64(stats.Items)
+	}
+	return stats, nil
+}
+
+func (b *bot) stopAll() {
+	slog.Info("stopping all searches")
+	var keys []string
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		// Searches managed by the config file are kept
+		if !v.(searchInfo).Managed {
+			keys = append(keys, k.(string))
+		}
+		return true
+	})
+	for _, k := range keys {
+		slog.Info("stopping search", "search", k)
+		b.searchs.Delete(k)
+		b.runs.Delete(k)
+		b.listed.Delete(k)
+		b.hash.Delete(sha(k))
+		if err := b.db.DeleteSearch(k); err != nil {
+			slog.Error("couldn't delete search items", "search", k, "err", err)
+		}
+		if err := b.db.Delete("meta", k); err != nil {
+			slog.Error("couldn't delete search meta", "search", k, "err", err)
+		}
+	}
+}
+
+func (b *bot) stop(parsed parsedArgs) {
+	if _, ok := b.searchs.Load(parsed.id); ok {
+		slog.Info("stopping search", "search", parsed.label())
+		b.searchs.Delete(parsed.id)
+		b.runs.Delete(parsed.id)
+		b.listed.Delete(parsed.id)
+		b.hash.Delete(sha(parsed.id))
+		if err := b.db.DeleteSearch(parsed.id); err != nil {
+			slog.Error("couldn't delete search items", "search", parsed.label(), "err", err)
+		}
+		if err := b.db.Delete("meta", parsed.id); err != nil {
+			slog.Error("couldn't delete search meta", "search", parsed.label(), "err", err)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package wallabot
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// bundleVersion is the version of the search bundle format.
+const bundleVersion = 1
+
+// bundleLimit is the maximum size of an imported bundle file.
+const bundleLimit = 1 << 20
+
+// shareTTL is the time a shared bundle can be imported with its deep link.
+const shareTTL = 30 * 24 * time.Hour
+
+// sharePrefix is the prefix of the deep link payload of shared bundles.
+const sharePrefix = "share-"
+
+// bundle is a portable set of searches. It doesn't contain chat ids or
+// notifier targets so it can be imported by other users.
+type bundle struct {
+	Version  int            `json:"version"`
+	Searches []bundleSearch `json:"searches"`
+}
+
+type bundleSearch struct {
+	Name     string        `json:"name,omitempty"`
+	Query    string        `json:"query"`
+	Tags     []string      `json:"tags,omitempty"`
+	Paused   bool          `json:"paused,omitempty"`
+	MaxAge   time.Duration `json:"max_age,omitempty"`
+	MaxItems int           `json:"max_items,omitempty"`
+}
+
+// sharedBundle is a bundle stored to be imported with a deep link.
+type sharedBundle struct {
+	User      int       `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+	Bundle    bundle    `json:"bundle"`
+}
+
+// share handles the /share command with format [search], it sends the
+// searches of the chat, or the given one, as a deep link and a bundle file.
+func (b *bot) share(user int, chat string, args string) {
+	var keys []string
+	if args = strings.TrimSpace(args); args != "" && args != "*" {
+		parsed, err := b.lookup(args, chat)
+		if err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		if _, ok := b.searchs.Load(parsed.id); !ok {
+			b.message(user, fmt.Sprintf("search %s not found", parsed.label()))
+			return
+		}
+		keys = append(keys, parsed.id)
+	}
+	bdl := bundle{Version: bundleVersion}
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		key := k.(string)
+		if len(keys) > 0 && key != keys[0] {
+			return true
+		}
+		if len(keys) == 0 && !strings.HasPrefix(key, chat+"/") {
+			return true
+		}
+		info := v.(searchInfo)
+		bdl.Searches = append(bdl.Searches, bundleSearch{
+			Name:     info.Name,
+			Query:    strings.SplitN(key, "/", 2)[1],
+			Tags:     info.Tags,
+			Paused:   info.Paused,
+			MaxAge:   info.MaxAge,
+			MaxItems: info.MaxItems,
+		})
+		return true
+	})
+	if len(bdl.Searches) == 0 {
+		b.message(user, "no searches to share")
+		return
+	}
+	sort.Slice(bdl.Searches, func(i, j int) bool {
+		if bdl.Searches[i].Name != bdl.Searches[j].Name {
+			return bdl.Searches[i].Name < bdl.Searches[j].Name
+		}
+		return bdl.Searches[i].Query < bdl.Searches[j].Query
+	})
+
+	rnd := make([]byte, 16)
+	if _, err := rand.Read(rnd); err != nil {
+		slog.Error("couldn't generate share token", "err", err)
+		return
+	}
+	token := hex.EncodeToString(rnd)
+	if err := b.db.Put("shares", token, sharedBundle{User: user, CreatedAt: time.Now().UTC(), Bundle: bdl}); err != nil {
+		slog.Error("couldn't store shared bundle", "user", user, "err", err)
+		return
+	}
+	link := fmt.Sprintf("https://t.me/%s?start=%s%s", b.Self.UserName, sharePrefix, token)
+	b.message(user, fmt.Sprintf("%d searches shared, the link expires in %d days:\n\n%s", len(bdl.Searches), int(shareTTL.Hours()/24), link))
+
+	data, err := json.MarshalIndent(bdl, "", "  ")
+	if err != nil {
+		slog.Error("couldn't encode bundle", "err", err)
+		return
+	}
+	doc := tgbot.NewDocumentUpload(int64(user), tgbot.FileBytes{
+		Name:  "searches.wallabot.json",
+		Bytes: data,
+	})
+	doc.Caption = "import it sending this file to the bot with the caption /import"
+	if _, err := b.Send(doc); err != nil {
+		slog.Error("couldn't send bundle", "user", user, "err", err)
+	}
+}
+
+// importCommand handles the /import command, the bundle is a shared deep
+// link, its payload, a json bundle or an attached bundle file.
+func (b *bot) importCommand(user int, chat string, args string, doc *tgbot.Document) {
+	args = strings.TrimSpace(args)
+	var bdl bundle
+	switch {
+	case doc != nil:
+		data, err := b.download(doc)
+		if err != nil {
+			b.message(user, err.Error())
+			return
+		}
+		if err := json.Unmarshal(data, &bdl); err != nil {
+			b.message(user, fmt.Sprintf("invalid bundle file: %v", err))
+			return
+		}
+	case strings.HasPrefix(args, "{"):
+		if err := json.Unmarshal([]byte(args), &bdl); err != nil {
+			b.message(user, fmt.Sprintf("invalid bundle: %v", err))
+			return
+		}
+	case args != "":
+		if i := strings.Index(args, "start="); i >= 0 {
+			args = args[i+len("start="):]
+		}
+		var ok bool
+		if bdl, ok = b.sharedBundle(user, strings.TrimPrefix(args, sharePrefix)); !ok {
+			return
+		}
+	default:
+		b.message(user, "usage: /import <share link|bundle json>, or send a bundle file with the caption /import")
+		return
+	}
+	b.importBundle(user, chat, bdl)
+}
+
+// sharedBundle returns a bundle stored by /share, expired ones are deleted.
+func (b *bot) sharedBundle(user int, token string) (bundle, bool) {
+	var s sharedBundle
+	if err := b.db.Get("shares", token, &s); err != nil {
+		slog.Error("couldn't get shared bundle", "err", err)
+		return bundle{}, false
+	}
+	if s.CreatedAt.IsZero() {
+		b.message(user, "shared searches not found")
+		return bundle{}, false
+	}
+	if time.Since(s.CreatedAt) > shareTTL {
+		if err := b.db.Delete("shares", token); err != nil {
+			slog.Error("couldn't delete shared bundle", "err", err)
+		}
+		b.message(user, "shared searches link expired")
+		return bundle{}, false
+	}
+	return s.Bundle, true
+}
+
+// download returns the contents of a document sent to the bot.
+func (b *bot) download(doc *tgbot.Document) ([]byte, error) {
+	if doc.FileSize > bundleLimit {
+		return nil, fmt.Errorf("bundle file is too big (%d bytes)", doc.FileSize)
+	}
+	u, err := b.GetFileDirectURL(doc.FileID)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get bundle file: %w", err)
+	}
+	resp, err := b.Client.Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download bundle file: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("couldn't download bundle file: status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, bundleLimit+1))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't download bundle file: %w", err)
+	}
+	if len(data) > bundleLimit {
+		return nil, fmt.Errorf("bundle file is too big")
+	}
+	return data, nil
+}
+
+// importBundle adds the searches of a bundle to the chat. Existing searches
+// are skipped and names already used by other searches are dropped.
+func (b *bot) importBundle(user int, chat string, bdl bundle) {
+	if bdl.Version != bundleVersion {
+		b.message(user, fmt.Sprintf("unsupported bundle version %d", bdl.Version))
+		return
+	}
+	added := 0
+	var notes []string
+	for _, s := range bdl.Searches {
+		// The query must not contain a chat, searches are always added to
+		// the chat of the importer
+		if s.Query == "" || strings.Contains(s.Query, "/") {
+			notes = append(notes, fmt.Sprintf("invalid query %q", s.Query))
+			continue
+		}
+		if s.MaxAge < 0 || s.MaxItems < 0 {
+			notes = append(notes, fmt.Sprintf("invalid retention of %s", s.Query))
+			continue
+		}
+		parsed, err := parseArgs(s.Query, chat)
+		if err != nil {
+			notes = append(notes, err.Error())
+			continue
+		}
+		// Options in the query are ignored, notifier targets of the bundle
+		// author must not be used
+		parsed.name, parsed.tags, parsed.targets = "", s.Tags, nil
+		if _, ok := b.searchs.Load(parsed.id); ok {
+			notes = append(notes, fmt.Sprintf("%s already exists", parsed.label()))
+			continue
+		}
+		if s.Name != "" && !strings.ContainsAny(s.Name, "/? ") {
+			parsed.name = s.Name
+			b.searchs.Range(func(_ interface{}, v interface{}) bool {
+				if v.(searchInfo).Name == s.Name {
+					notes = append(notes, fmt.Sprintf("name %s already used, %s imported without name", s.Name, s.Query))
+					parsed.name = ""
+					return false
+				}
+				return true
+			})
+		}
+		if err := b.add(parsed); err != nil {
+			notes = append(notes, err.Error())
+			continue
+		}
+		if s.Paused || s.MaxAge > 0 || s.MaxItems > 0 {
+			if err := b.setOptions(parsed, s); err != nil {
+				slog.Error("couldn't update imported search", "search", parsed.label(), "err", err)
+			}
+		}
+		added++
+	}
+	text := fmt.Sprintf("imported %d of %d searches", added, len(bdl.Searches))
+	if len(notes) > 0 {
+		text = fmt.Sprintf("%s\n\n%s", text, strings.Join(notes, "\n"))
+	}
+	b.message(user, text)
+}
+
+// setOptions sets the pause state and retention of an imported search.
+func (b *bot) setOptions(parsed parsedArgs, s bundleSearch) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	v, ok := b.searchs.Load(parsed.id)
+	if !ok {
+		return fmt.Errorf("search %s not found", parsed.label())
+	}
+	info := v.(searchInfo)
+	info.Paused = s.Paused
+	info.MaxAge = s.MaxAge
+	info.MaxItems = s.MaxItems
+	if err := b.db.Put("meta", parsed.id, info); err != nil {
+		return err
+	}
+	b.searchs.Store(parsed.id, info)
+	return nil
+}
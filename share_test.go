@@ -0,0 +1,220 @@
+package wallabot
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// telegramTransport answers the requests of the telegram bot api, documents
+// are served from files.
+type telegramTransport struct {
+	files   map[string]string
+	uploads []string
+}
+
+func (tt *telegramTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	var body string
+	switch path := r.URL.Path; {
+	case strings.HasPrefix(path, "/file/"):
+		body = tt.files[path[strings.LastIndex(path, "/")+1:]]
+	case strings.HasSuffix(path, "/getFile"):
+		if err := r.ParseForm(); err != nil {
+			return nil, err
+		}
+		id := r.PostForm.Get("file_id")
+		body = `{"ok":true,"result":{"file_id":"` + id + `","file_path":"documents/` + id + `"}}`
+	default:
+		if strings.HasSuffix(path, "/sendDocument") {
+			data, err := io.ReadAll(r.Body)
+			if err != nil {
+				return nil, err
+			}
+			tt.uploads = append(tt.uploads, string(data))
+		}
+		body = `{"ok":true,"result":{"message_id":1,"date":0,"chat":{"id":1}}}`
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}, nil
+}
+
+// withTelegram sets a telegram bot api that answers with a fake transport.
+func withTelegram(b *bot) *telegramTransport {
+	tt := &telegramTransport{files: make(map[string]string)}
+	b.BotAPI = &tgbot.BotAPI{
+		Token:  "test",
+		Self:   tgbot.User{UserName: "wallabot"},
+		Client: &http.Client{Transport: tt},
+	}
+	return tt
+}
+
+// lastMessage returns the text of the last queued message to the chat.
+func lastMessage(t *testing.T, b *bot, chat string) string {
+	t.Helper()
+	b.queue.lock.Lock()
+	defer b.queue.lock.Unlock()
+	for n := len(b.queue.pending) - 1; n >= 0; n-- {
+		if o := b.queue.pending[n]; o.Chat == chat {
+			return o.Text
+		}
+	}
+	t.Fatalf("no messages to %s", chat)
+	return ""
+}
+
+var shareLink = regexp.MustCompile(`https://t\.me/wallabot\?start=(share-[0-9a-f]+)`)
+
+func TestShareImport(t *testing.T) {
+	b := newTestBot(t)
+	tt := withTelegram(b)
+	parsed, err := parseArgs("name=bikes tags=sport 2/bike", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.add(parsed); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.setOptions(parsed, bundleSearch{Paused: true, MaxAge: time.Hour, MaxItems: 10}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.share(testAlice, "2", "")
+	text := lastMessage(t, b, "2")
+	m := shareLink.FindStringSubmatch(text)
+	if m == nil {
+		t.Fatalf("no share link in %q", text)
+	}
+	if len(tt.uploads) != 1 {
+		t.Fatalf("got %d bundle files, want 1", len(tt.uploads))
+	}
+	// The bundle doesn't contain the chat of the author
+	if upload := tt.uploads[0]; !strings.Contains(upload, `"query": "bike"`) || strings.Contains(upload, "2/bike") {
+		t.Errorf("unexpected bundle file %s", upload)
+	}
+
+	// The name is already used by the search of alice
+	b.importCommand(testBob, "3", m[1], nil)
+	if text := lastMessage(t, b, "3"); !strings.Contains(text, "imported 1 of 1 searches") || !strings.Contains(text, "name bikes already used") {
+		t.Errorf("unexpected import message %q", text)
+	}
+	v, ok := b.searchs.Load("3/bike")
+	if !ok {
+		t.Fatal("search wasn't imported to the chat of bob")
+	}
+	want := searchInfo{Tags: []string{"sport"}, Paused: true, MaxAge: time.Hour, MaxItems: 10}
+	if info := v.(searchInfo); info.Name != "" || strings.Join(info.Tags, ",") != "sport" || !info.Paused ||
+		info.MaxAge != want.MaxAge || info.MaxItems != want.MaxItems {
+		t.Errorf("imported search = %+v, want %+v", info, want)
+	}
+
+	// Paused searches are stored so they are loaded on restart
+	keys, err := b.db.Searches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "2/bike,3/bike,3/car" {
+		t.Errorf("got stored searches %v, want 2/bike, 3/bike and 3/car", keys)
+	}
+	var info searchInfo
+	if err := b.db.Get("meta", "3/bike", &info); err != nil {
+		t.Fatal(err)
+	}
+	if !info.Paused || info.MaxItems != 10 {
+		t.Errorf("stored search = %+v, want %+v", info, want)
+	}
+
+	// Importing it again skips the existing search
+	b.importCommand(testBob, "3", "https://t.me/wallabot?start="+m[1], nil)
+	if text := lastMessage(t, b, "3"); !strings.Contains(text, "imported 0 of 1 searches") {
+		t.Errorf("unexpected import message %q", text)
+	}
+}
+
+func TestShareExpired(t *testing.T) {
+	b := newTestBot(t)
+	bdl := bundle{Version: bundleVersion, Searches: []bundleSearch{{Query: "lamp"}}}
+	if err := b.db.Put("shares", "old", sharedBundle{User: testAlice, CreatedAt: time.Now().Add(-shareTTL - time.Hour), Bundle: bdl}); err != nil {
+		t.Fatal(err)
+	}
+
+	b.importCommand(testBob, "3", sharePrefix+"old", nil)
+	if text := lastMessage(t, b, "3"); text != "shared searches link expired" {
+		t.Errorf("unexpected message %q", text)
+	}
+	if _, ok := b.searchs.Load("3/lamp"); ok {
+		t.Error("expired bundle was imported")
+	}
+	keys, err := b.db.Keys("shares")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Errorf("expired bundle wasn't deleted: %v", keys)
+	}
+
+	b.importCommand(testBob, "3", sharePrefix+"unknown", nil)
+	if text := lastMessage(t, b, "3"); text != "shared searches not found" {
+		t.Errorf("unexpected message %q", text)
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	b := newTestBot(t)
+	tt := withTelegram(b)
+
+	// Chats and notifier targets of the author aren't imported
+	bdl := bundle{Version: bundleVersion, Searches: []bundleSearch{
+		{Query: "2/lamp"},
+		{Query: "to=slack boat", Tags: []string{"sea"}},
+		{Query: "kayak", MaxItems: -1},
+		{Query: ""},
+	}}
+	data, err := json.Marshal(bdl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tt.files["bundle"] = string(data)
+	b.importCommand(testBob, "3", "", &tgbot.Document{FileID: "bundle", FileSize: len(data)})
+	text := lastMessage(t, b, "3")
+	if !strings.Contains(text, "imported 1 of 4 searches") || !strings.Contains(text, `invalid query "2/lamp"`) ||
+		!strings.Contains(text, "invalid retention of kayak") {
+		t.Errorf("unexpected import message %q", text)
+	}
+	var imported []string
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		if strings.HasPrefix(k.(string), "3/") && k.(string) != "3/car" {
+			imported = append(imported, k.(string))
+			if info := v.(searchInfo); len(info.Targets) > 0 {
+				t.Errorf("search %s imported with targets %v", k, info.Targets)
+			}
+		}
+		return true
+	})
+	if len(imported) != 1 || !strings.HasSuffix(imported[0], "boat") {
+		t.Errorf("got imported searches %v, want the boat one", imported)
+	}
+	if _, ok := b.searchs.Load("2/lamp"); ok {
+		t.Error("search was imported to the chat of the author")
+	}
+
+	b.importCommand(testBob, "3", `{"version":2,"searches":[{"query":"lamp"}]}`, nil)
+	if text := lastMessage(t, b, "3"); text != "unsupported bundle version 2" {
+		t.Errorf("unexpected message %q", text)
+	}
+	b.importCommand(testBob, "3", "", &tgbot.Document{FileID: "big", FileSize: bundleLimit + 1})
+	if text := lastMessage(t, b, "3"); !strings.Contains(text, "too big") {
+		t.Errorf("unexpected message %q", text)
+	}
+}
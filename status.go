@@ -0,0 +1,155 @@
+package wallabot
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// statusPageSize is the number of searches listed on each page of the status dashboard.
+const statusPageSize = 5
+
+// searchRun contains runtime counters of a search.
+type searchRun struct {
+	items     int
+	lastRun   time.Time
+	lastError string
+	errorAt   time.Time
+}
+
+// record updates the runtime counters of a search after it has been run.
+func (b *bot) record(key string, items int, err error) {
+	run := searchRun{}
+	if v, ok := b.runs.Load(key); ok {
+		run = v.(searchRun)
+	}
+	run.items = items
+	run.lastRun = time.Now()
+	if err != nil {
+		run.lastError = err.Error()
+		run.errorAt = run.lastRun
+	}
+	b.runs.Store(key, run)
+}
+
+// dashboard returns the text and buttons of the requested status page.
+func (b *bot) dashboard(page int) (string, tgbot.InlineKeyboardMarkup) {
+	var keys []string
+	b.searchs.Range(func(k interface{}, _ interface{}) bool {
+		keys = append(keys, k.(string))
+		return true
+	})
+	sort.Strings(keys)
+
+	pages := (len(keys) + statusPageSize - 1) / statusPageSize
+	if pages == 0 {
+		pages = 1
+	}
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	lines := []string{fmt.Sprintf("📋 %d searches, last cycle %s, %d queued messages",
+		len(keys), time.Duration(b.elapsed.Load()).Round(time.Millisecond), b.queue.depth())}
+	var rows [][]tgbot.InlineKeyboardButton
+	start := page * statusPageSize
+	for i := start; i < len(keys) && i < start+statusPageSize; i++ {
+		key := keys[i]
+		v, ok := b.searchs.Load(key)
+		if !ok {
+			continue
+		}
+		info := v.(searchInfo)
+		run := searchRun{}
+		if v, ok := b.runs.Load(key); ok {
+			run = v.(searchRun)
+		}
+
+		n := i + 1
+		line := fmt.Sprintf("\n%d. %s", n, info.label(key))
+		if info.Name != "" {
+			line = fmt.Sprintf("%s\n%s", line, key)
+		}
+		if len(info.Targets) > 0 {
+			line = fmt.Sprintf("%s\n➡️ %s", line, strings.Join(info.Targets, ", "))
+		}
+		if info.Paused {
+			line = fmt.Sprintf("%s\n⏸ paused", line)
+		}
+		if info.Managed {
+			line = fmt.Sprintf("%s\n🔒 managed by the config file", line)
+		}
+		lastRun := "never"
+		if !run.lastRun.IsZero() {
+			lastRun = fmt.Sprintf("%s ago", time.Since(run.lastRun).Round(time.Second))
+		}
+		line = fmt.Sprintf("%s\n📦 %d items · 🕒 %s", line, run.items, lastRun)
+		if run.lastError != "" {
+			line = fmt.Sprintf("%s\n⚠️ %s ago: %s", line, time.Since(run.errorAt).Round(time.Second), run.lastError)
+		}
+		lines = append(lines, line)
+
+		h := sha(key)
+		stats := tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("📊 %d", n), fmt.Sprintf("/stats %s", h))
+		if info.Managed {
+			rows = append(rows, tgbot.NewInlineKeyboardRow(stats))
+			continue
+		}
+		pause := tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("⏸ %d", n), fmt.Sprintf("/pause %s", h))
+		if info.Paused {
+			pause = tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("▶️ %d", n), fmt.Sprintf("/resume %s", h))
+		}
+		rows = append(rows, tgbot.NewInlineKeyboardRow(
+			pause,
+			tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("⏹ %d", n), fmt.Sprintf("/stop %s", h)),
+			stats,
+			tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("✏️ %d", n), fmt.Sprintf("/edit %s", h)),
+		))
+	}
+
+	nav := tgbot.NewInlineKeyboardRow(
+		tgbot.NewInlineKeyboardButtonData("«", fmt.Sprintf("/status %d", page-1)),
+		tgbot.NewInlineKeyboardButtonData(fmt.Sprintf("%d/%d", page+1, pages), fmt.Sprintf("/status %d", page)),
+		tgbot.NewInlineKeyboardButtonData("»", fmt.Sprintf("/status %d", page+1)),
+	)
+	rows = append(rows, nav)
+	return strings.Join(lines, "\n"), tgbot.NewInlineKeyboardMarkup(rows...)
+}
+
+// status sends a new status dashboard to the user.
+func (b *bot) status(user int) {
+	text, markup := b.dashboard(0)
+	b.queue.push(&outbound{
+		Chat:    strconv.Itoa(user),
+		Text:    text,
+		Buttons: markup.InlineKeyboard,
+	})
+}
+
+// pageKey returns the key of the current page of a status dashboard message.
+func pageKey(msg *tgbot.Message) string {
+	return fmt.Sprintf("%d/%d", msg.Chat.ID, msg.MessageID)
+}
+
+// refresh edits a status dashboard message with the current data.
+func (b *bot) refresh(msg *tgbot.Message) {
+	page := 0
+	if v, ok := b.pages.Get(pageKey(msg)); ok {
+		page = v.(int)
+	}
+	text, markup := b.dashboard(page)
+	edit := tgbot.NewEditMessageText(msg.Chat.ID, msg.MessageID, text)
+	edit.ReplyMarkup = &markup
+	edit.DisableWebPagePreview = true
+	if _, err := b.Send(edit); err != nil && !strings.Contains(err.Error(), "message is not modified") {
+		slog.Error("couldn't refresh status", "chat", msg.Chat.ID, "err", err)
+	}
+}
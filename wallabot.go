@@ -6,38 +6,161 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	tgbot "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/metrics"
+	"github.com/igolaizola/wallabot/internal/notify"
 	"github.com/igolaizola/wallabot/internal/store"
 	"github.com/patrickmn/go-cache"
 )
 
 type bot struct {
 	*tgbot.BotAPI
-	db      *store.Store
+	db      store.Store
 	searchs sync.Map
+	runs    sync.Map
+	pages   *cache.Cache
 	admin   int
 	client  *api.Client
 	wg      sync.WaitGroup
-	elapsed time.Duration
 	cache   *cache.Cache
-	hash    map[string]string
+	drafts  *cache.Cache
+	logins  *cache.Cache
+	hash    sync.Map
+	chats   sync.Map
+	lock    sync.Mutex
+	queue   *queue
+	health  *health
+	level   *slog.LevelVar
+	// listed contains the ids of the items listed by each search since the
+	// bot started, only they can be notified as gone
+	listed sync.Map
+	// elapsed is the duration of the last search cycle in nanoseconds, read
+	// by the status command and the api
+	elapsed atomic.Int64
+
+	publicURL string
+
+	telegram notify.Notifier
+
+	cfgLock       sync.RWMutex
+	cfg           *Config
+	templates     map[notify.Kind]*template.Template
+	notifier      map[string]notify.Notifier
+	stopNotifiers context.CancelFunc
 }
 
-func Run(ctx context.Context, token, dbPath string, admin int, users []int) error {
-	db, err := store.New(dbPath)
+// Config contains the configuration of the bot.
+type Config struct {
+	// Token is the telegram bot token
+	Token string
+	// DB is the database file path
+	DB string
+	// DBBackend is the storage backend, bolt or sqlite, detected from the
+	// database file if empty
+	DBBackend string
+	// Admin is the chat id of the admin that controls the bot
+	Admin int
+	// Users are the chat ids of the users allowed to control the bot
+	Users []int
+	// Listen is the address of the embedded http server, disabled if empty
+	Listen string
+	// PublicURL is the base url where the http server is reachable, used to
+	// generate links like feed urls
+	PublicURL string
+	// TLSCert and TLSKey are the files used to serve https
+	TLSCert string
+	TLSKey  string
+	// WebhookURL is the public url telegram sends updates to, long polling
+	// is used if empty. It must have a path other than the root
+	WebhookURL string
+	// WebhookSecret is used to verify webhook requests, 1-256 characters of
+	// A-Z, a-z, 0-9, _ and -. A random one is generated if empty
+	WebhookSecret string
+	// Notifiers are additional destinations searchs can send events to
+	Notifiers []notify.Config
+	// SearchStale is the time without a successful search cycle after which
+	// the bot is unhealthy, disabled if zero
+	SearchStale time.Duration
+	// TelegramStale is the time without reaching telegram after which the
+	// bot is unhealthy, disabled if zero
+	TelegramStale time.Duration
+	// LogLevel is the minimum level logged: debug, info, warn or error
+	LogLevel string
+	// LogFormat is the log output format: text or json
+	LogFormat string
+	// SearchInterval is the pause between search cycles
+	SearchInterval time.Duration
+	// RequestInterval is the minimum interval between wallapop requests
+	RequestInterval time.Duration
+	// GlobalInterval, PrivateInterval and GroupInterval are the minimum
+	// intervals between telegram messages overall, to a private chat and to
+	// a group or channel
+	GlobalInterval  time.Duration
+	PrivateInterval time.Duration
+	GroupInterval   time.Duration
+	// MaxAttempts is the number of attempts to send a telegram message
+	MaxAttempts int
+	// NewTemplate and PriceDropTemplate are text templates for the telegram
+	// messages, executed with the notify event, the defaults are used if
+	// empty
+	NewTemplate       string
+	PriceDropTemplate string
+	// BackupDir is the directory where scheduled backups are written,
+	// disabled if empty
+	BackupDir string
+	// BackupInterval is the interval between scheduled backups
+	BackupInterval time.Duration
+	// BackupKeep is the number of scheduled backups kept in BackupDir
+	BackupKeep int
+	// RetentionMaxAge and RetentionMaxItems are the default retention of the
+	// items of a search, items not seen within the max age and the least
+	// recently seen gone ones exceeding the max items are pruned. Each limit
+	// is disabled if zero
+	RetentionMaxAge   time.Duration
+	RetentionMaxItems int
+	// PruneInterval is the interval between pruning jobs, which also compact
+	// the database
+	PruneInterval time.Duration
+	// Searches are declared searches, reconciled with the database when the
+	// bot starts or the configuration is reloaded
+	Searches []SearchConfig
+	// ObsoleteSearches is what to do with config managed searches that are
+	// no longer configured: pause (default) or remove
+	ObsoleteSearches string
+	// Reload receives new configurations to apply while running, settings
+	// like the token, db or listen address require a restart
+	Reload <-chan *Config
+}
+
+func Run(ctx context.Context, cfg *Config) error {
+	admin := cfg.Admin
+	level := &slog.LevelVar{}
+	if cfg.LogLevel != "" {
+		l, err := parseLevel(cfg.LogLevel)
+		if err != nil {
+			return err
+		}
+		level.Set(l)
+	}
+	db, err := store.Open(cfg.DBBackend, cfg.DB)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	botAPI, err := tgbot.NewBotAPI(token)
+	botAPI, err := tgbot.NewBotAPI(cfg.Token)
 	if err != nil {
 		return fmt.Errorf("couldn't create bot api: %w", err)
 	}
@@ -52,44 +175,85 @@ func Run(ctx context.Context, token, dbPath string, admin int, users []int) erro
 		client: api.New(ctx),
 		admin:  admin,
 		cache:  cach,
-		hash:   make(map[string]string),
-	}
+		drafts: cache.New(10*time.Minute, time.Minute),
+		logins: cache.New(5*time.Minute, time.Minute),
+		pages:  cache.New(24*time.Hour, time.Hour),
 
-	users = append(users, admin)
-	userChats := make(map[int]string)
-	for _, u := range users {
-		userChats[u] = strconv.Itoa(u)
-		var chat string
-		if err := db.Get("config", strconv.Itoa(u), &chat); err != nil {
-			bot.log(fmt.Errorf("couldn't get config for %d: %w", u, err))
-			continue
-		}
-		if chat != "" {
-			userChats[u] = chat
-		}
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		health:    newHealth(cfg.SearchStale, cfg.TelegramStale),
+		level:     level,
+	}
+	logger, err := bot.newLogger(os.Stderr, cfg.LogFormat)
+	if err != nil {
+		return err
 	}
+	slog.SetDefault(logger)
+	bot.queue, err = newQueue(db, admin, botAPI.Send)
+	if err != nil {
+		return fmt.Errorf("couldn't create queue: %w", err)
+	}
+	bot.queue.health = bot.health
+	bot.registerGauges()
+	bot.wg.Add(1)
+	go func() {
+		defer bot.wg.Done()
+		bot.queue.run(ctx)
+	}()
+	bot.telegram = &telegramNotifier{bot: bot}
 
-	bot.log(fmt.Sprintf("wallabot started, bot %s", bot.Self.UserName))
-	defer bot.log(fmt.Sprintf("wallabot stoped, bot %s", bot.Self.UserName))
+	slog.Info("wallabot started", "bot", bot.Self.UserName)
+	defer slog.Info("wallabot stopped", "bot", bot.Self.UserName)
 	defer bot.wg.Wait()
 
-	keys, err := db.Keys("db")
+	keys, err := db.Searches()
 	if err != nil {
-		bot.log(fmt.Errorf("couldn't get keys: %w", err))
+		slog.Error("couldn't get search keys", "err", err)
 	}
 	for _, k := range keys {
 		if _, err := parseArgs(k, ""); err != nil {
-			bot.log(fmt.Errorf("couldn't parse key %s: %w", k, err))
+			slog.Error("couldn't parse search key", "search", k, "err", err)
 			continue
 		}
-		bot.searchs.Store(k, struct{}{})
-		bot.hash[sha(k)] = k
-		bot.log(fmt.Sprintf("loaded from db: %s", k))
+		var info searchInfo
+		if err := db.Get("meta", k, &info); err != nil {
+			slog.Error("couldn't get search meta", "search", k, "err", err)
+		}
+		bot.searchs.Store(k, info)
+		bot.hash.Store(sha(k), k)
+		slog.Info("search loaded", "search", info.label(k))
+	}
+	if err := bot.apply(ctx, cfg); err != nil {
+		return err
+	}
+	if cfg.Reload != nil {
+		bot.wg.Add(1)
+		go func() {
+			defer bot.wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case c := <-cfg.Reload:
+					bot.reload(ctx, c)
+				}
+			}
+		}()
 	}
 
 	bot.wg.Add(1)
 	go func() {
-		defer log.Println("search routine finished")
+		defer bot.wg.Done()
+		bot.backups(ctx)
+	}()
+	bot.wg.Add(1)
+	go func() {
+		defer bot.wg.Done()
+		bot.pruning(ctx)
+	}()
+
+	bot.wg.Add(1)
+	go func() {
+		defer slog.Info("search routine finished")
 		defer bot.wg.Done()
 		for {
 			start := time.Now()
@@ -99,45 +263,90 @@ func Run(ctx context.Context, token, dbPath string, admin int, users []int) erro
 				return true
 			})
 			sort.Strings(keys)
+			var ran, failed int
 			for _, k := range keys {
-				log.Println(fmt.Sprintf("searching: %s", k))
+				slog.Debug("searching", "search", k)
 				select {
 				case <-ctx.Done():
 					return
 				default:
 				}
-				if _, ok := bot.searchs.Load(k); !ok {
+				v, ok := bot.searchs.Load(k)
+				if !ok {
+					continue
+				}
+				info := v.(searchInfo)
+				if info.Paused {
 					continue
 				}
 				parsed, err := parseArgs(k, "")
 				if err != nil {
-					bot.log(fmt.Errorf("couldn't parse key %s: %w", k, err))
+					slog.Error("couldn't parse search key", "search", k, "err", err)
 					continue
 				}
-				bot.search(ctx, parsed)
+				parsed.name = info.Name
+				parsed.tags = info.Tags
+				parsed.targets = info.Targets
+				searchStart := time.Now()
+				n, err := bot.search(ctx, parsed)
+				searchDuration.Since(searchStart, info.label(k))
+				ran++
+				if err != nil {
+					failed++
+					bot.health.failure(healthSearch, err)
+					slog.Error("search failed", "search", info.label(k), "err", err)
+				}
+				bot.record(k, n, err)
+			}
+			// A cycle succeeds unless all its searches failed
+			if ran == 0 || failed < ran {
+				bot.health.success(healthSearch)
 			}
-			bot.elapsed = time.Since(start)
+			bot.elapsed.Store(int64(time.Since(start)))
+			cycleDuration.Since(start)
 
 			select {
 			case <-ctx.Done():
 				return
-			case <-time.After(5 * time.Second):
+			case <-time.After(bot.searchInterval()):
 			}
 		}
 	}()
 
-	u := tgbot.NewUpdate(0)
-	u.Timeout = 60
-	updates, err := bot.GetUpdatesChan(u)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", bot.healthHandler)
+	mux.HandleFunc("/readyz", bot.readyHandler)
+	mux.HandleFunc("/feeds/", bot.feedHandler)
+	mux.HandleFunc("/api/v1/", bot.apiHandler)
+	mux.Handle("/web/", bot.webHandler())
+	mux.HandleFunc("/notify/", bot.notifyHandler)
+	if cfg.Listen != "" {
+		bot.wg.Add(1)
+		go func() {
+			defer bot.wg.Done()
+			if err := bot.serve(ctx, cfg, mux); err != nil {
+				slog.Error("http server failed", "err", err)
+			}
+		}()
+	}
+
+	updates, err := bot.updates(ctx, cfg, mux)
 	if err != nil {
-		bot.log(fmt.Errorf("couldn't get update chan: %w", err))
+		slog.Error("couldn't get telegram updates", "err", err)
 		return err
 	}
+	bot.health.setReady()
+	bot.wg.Add(1)
+	go func() {
+		defer bot.wg.Done()
+		bot.monitor(ctx)
+	}()
 	for {
 		var update tgbot.Update
 		select {
 		case <-ctx.Done():
-			log.Println("stopping bot")
+			slog.Info("stopping bot")
 			return nil
 		case update = <-updates:
 		}
@@ -145,19 +354,24 @@ func Run(ctx context.Context, token, dbPath string, admin int, users []int) erro
 		var command string
 		var args string
 		var user int
+		var document *tgbot.Document
+		callback := update.CallbackQuery != nil
 
 		// Extract command from callback
 		if update.CallbackQuery != nil {
 			user = int(update.CallbackQuery.From.ID)
 			data := update.CallbackQuery.Data
 			if _, err := bot.AnswerCallbackQuery(tgbot.NewCallback(update.CallbackQuery.ID, "")); err != nil {
-				bot.log(err)
+				slog.Error("couldn't answer callback query", "user", user, "err", err)
 				continue
 			}
 			split := strings.SplitN(data, " ", 2)
 			command = strings.TrimPrefix(split[0], "/")
 			if len(split) > 1 {
-				args = bot.hash[split[1]]
+				args = split[1]
+				if k, ok := bot.hash.Load(split[1]); ok {
+					args = k.(string)
+				}
 			}
 		}
 
@@ -170,13 +384,24 @@ func Run(ctx context.Context, token, dbPath string, admin int, users []int) erro
 			if update.Message.IsCommand() {
 				command = update.Message.Command()
 				args = update.Message.CommandArguments()
+			} else if update.Message.Text != "" {
+				// Text messages are used as input for the search builder
+				command = "input"
+				args = update.Message.Text
+			} else if doc := update.Message.Document; doc != nil &&
+				(strings.HasPrefix(update.Message.Caption, "/import") || strings.HasSuffix(doc.FileName, ".wallabot.json")) {
+				// Search bundle files are imported
+				command = "import"
+				document = doc
 			}
 		}
 
 		// Check if user is valid
-		if _, ok := userChats[user]; !ok {
+		v, ok := bot.chats.Load(user)
+		if !ok {
 			continue
 		}
+		userChat := v.(string)
 
 		if command == "" {
 			continue
@@ -185,12 +410,12 @@ func Run(ctx context.Context, token, dbPath string, admin int, users []int) erro
 		switch command {
 		case "chat":
 			if args == "" {
-				bot.message(user, fmt.Sprintf("current chat id for searchs: %s", userChats[user]))
+				bot.message(user, fmt.Sprintf("current chat id for searchs: %s", userChat))
 				break
 			}
-			userChats[user] = args
+			bot.chats.Store(user, args)
 			if err := db.Put("config", strconv.Itoa(user), args); err != nil {
-				bot.log(fmt.Errorf("couldn't get config for %d: %w", u, err))
+				slog.Error("couldn't store chat config", "user", user, "err", err)
 			}
 			bot.message(user, fmt.Sprintf("chat id for searchs updated: %s", args))
 		case "search":
@@ -198,71 +423,263 @@ func Run(ctx context.Context, token, dbPath string, admin int, users []int) erro
 				bot.message(user, "search arguments not provided")
 				continue
 			}
-			parsed, err := parseArgs(args, userChats[user])
+			parsed, err := parseArgs(args, userChat)
+			if err == nil {
+				err = bot.readOnly(parsed)
+			}
+			if err == nil {
+				err = bot.add(parsed)
+			}
 			if err != nil {
 				bot.message(user, err.Error())
-			} else {
-				bot.searchs.Store(parsed.id, struct{}{})
-				bot.hash[sha(parsed.id)] = parsed.id
+				continue
 			}
-			bot.message(user, fmt.Sprintf("searching %s", parsed.id))
+			bot.message(user, fmt.Sprintf("searching %s", parsed.label()))
 		case "status":
-			bot.message(user, "status info:")
-			bot.searchs.Range(func(k interface{}, _ interface{}) bool {
-				key := k.(string)
-				data := fmt.Sprintf("/stop %s", sha(key))
-				btns := []tgbot.InlineKeyboardButton{
-					tgbot.NewInlineKeyboardButtonData("stop", data),
-				}
-				bot.messageOpts(user, fmt.Sprintf("%s", key), false, btns)
-				return true
-			})
-			bot.log(fmt.Sprintf("elapsed: %s", bot.elapsed))
+			if !callback || update.CallbackQuery.Message == nil {
+				bot.status(user)
+				break
+			}
+			page, _ := strconv.Atoi(args)
+			if page < 0 {
+				page = 0
+			}
+			bot.pages.SetDefault(pageKey(update.CallbackQuery.Message), page)
 		case "stop":
 			if args == "" {
 				bot.message(user, "stop arguments not provided")
 				continue
 			}
-			parsed, err := parseArgs(args, userChats[user])
+			parsed, err := bot.lookup(args, userChat)
 			if err != nil {
 				bot.message(user, err.Error())
+				continue
 			}
 			if parsed.query == "*" {
 				bot.stopAll()
-				bot.message(user, "stopped all")
+				bot.message(user, "stopped all searches not managed by the config file")
 			} else {
+				if err := bot.readOnly(parsed); err != nil {
+					bot.message(user, err.Error())
+					continue
+				}
 				bot.stop(parsed)
-				bot.message(user, fmt.Sprintf("stopped %s", parsed.id))
+				if !callback {
+					bot.message(user, fmt.Sprintf("stopped %s", parsed.label()))
+				}
+			}
+		case "pause", "resume":
+			if args == "" {
+				bot.message(user, fmt.Sprintf("%s arguments not provided", command))
+				continue
+			}
+			parsed, err := bot.lookup(args, userChat)
+			if err != nil {
+				bot.message(user, err.Error())
+				continue
+			}
+			if err := bot.readOnly(parsed); err != nil {
+				bot.message(user, err.Error())
+				continue
+			}
+			if err := bot.pause(parsed, command == "pause"); err != nil {
+				bot.message(user, err.Error())
+				continue
+			}
+			if !callback {
+				bot.message(user, fmt.Sprintf("%sd %s", command, parsed.label()))
+			}
+		case "stats":
+			if args == "" {
+				bot.message(user, "stats arguments not provided")
+				continue
+			}
+			parsed, err := bot.lookup(args, userChat)
+			if err != nil {
+				bot.message(user, err.Error())
+				continue
 			}
+			bot.stats(user, parsed)
+		case "new":
+			bot.build(user, userChat, args)
+		case "edit":
+			if args == "" {
+				bot.message(user, "edit arguments not provided")
+				continue
+			}
+			parsed, err := bot.lookup(args, userChat)
+			if err == nil {
+				err = bot.readOnly(parsed)
+			}
+			if err != nil {
+				bot.message(user, err.Error())
+				continue
+			}
+			bot.edit(user, parsed)
+		case "input":
+			bot.input(user, args)
+		case "feed":
+			if args == "" {
+				bot.feeds(user, "chat", userChat, userChat)
+				continue
+			}
+			parsed, err := bot.lookup(args, userChat)
+			if err != nil {
+				bot.message(user, err.Error())
+				continue
+			}
+			if _, ok := bot.searchs.Load(parsed.id); !ok {
+				bot.message(user, fmt.Sprintf("search %s not found", parsed.label()))
+				continue
+			}
+			bot.feeds(user, "search", parsed.id, parsed.label())
+		case "token":
+			bot.token(user, args)
+		case "web":
+			bot.webLink(user)
+		case "loglevel":
+			bot.logLevel(user, args)
 		case "export":
 			bot.export(user)
+		case "exportitems":
+			bot.exportItems(user, userChat, args)
+		case "backup":
+			bot.backup(user)
+		case "share":
+			bot.share(user, userChat, args)
+		case "import":
+			bot.importCommand(user, userChat, args, document)
+		case "start":
+			// Deep links of shared searches
+			if strings.HasPrefix(args, sharePrefix) {
+				bot.importCommand(user, userChat, args, nil)
+			}
+		case "retention":
+			bot.retentionCommand(user, userChat, args)
+		case "prune":
+			bot.pruneCommand(user)
 		case "batch":
 			split := strings.Split(args, "\n")
 			for _, s := range split {
-				parsed, err := parseArgs(s, userChats[user])
+				parsed, err := parseArgs(s, userChat)
+				if err == nil {
+					err = bot.readOnly(parsed)
+				}
+				if err == nil {
+					err = bot.add(parsed)
+				}
 				if err != nil {
 					bot.message(user, err.Error())
-				} else {
-					bot.searchs.Store(parsed.id, struct{}{})
-					bot.hash[sha(parsed.id)] = parsed.id
+					continue
 				}
-				bot.message(user, fmt.Sprintf("searching %s", parsed.id))
+				bot.message(user, fmt.Sprintf("searching %s", parsed.label()))
+			}
+		}
+
+		// Refresh status dashboard after its buttons are used
+		if callback && update.CallbackQuery.Message != nil {
+			switch command {
+			case "status", "pause", "resume", "stop":
+				bot.refresh(update.CallbackQuery.Message)
 			}
 		}
 	}
 }
 
+// searchInfo contains the metadata of a search that isn't part of its key.
+type searchInfo struct {
+	Name    string   `json:"name,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	Paused  bool     `json:"paused,omitempty"`
+	// Managed searches are defined in the config file and read-only
+	Managed bool `json:"managed,omitempty"`
+	// MaxAge and MaxItems override the default retention if not zero
+	MaxAge   time.Duration `json:"max_age,omitempty"`
+	MaxItems int           `json:"max_items,omitempty"`
+}
+
+// label returns the name of the search if available or its key otherwise.
+func (i searchInfo) label(key string) string {
+	text := key
+	if i.Name != "" {
+		text = i.Name
+	}
+	if len(i.Tags) > 0 {
+		text = fmt.Sprintf("%s [%s]", text, strings.Join(i.Tags, ", "))
+	}
+	return text
+}
+
 type parsedArgs struct {
-	id    string
-	chat  string
-	query string
+	id      string
+	chat    string
+	query   string
+	name    string
+	tags    []string
+	targets []string
+}
+
+func (p parsedArgs) label() string {
+	return searchInfo{Name: p.name, Tags: p.tags}.label(p.id)
 }
 
+// line returns the search in the format accepted by parseArgs.
+func (p parsedArgs) line() string {
+	var opts []string
+	if p.name != "" {
+		opts = append(opts, fmt.Sprintf("name=%s", p.name))
+	}
+	if len(p.tags) > 0 {
+		opts = append(opts, fmt.Sprintf("tags=%s", strings.Join(p.tags, ",")))
+	}
+	if len(p.targets) > 0 {
+		opts = append(opts, fmt.Sprintf("to=%s", strings.Join(p.targets, ",")))
+	}
+	return strings.Join(append(opts, p.id), " ")
+}
+
+// parseArgs parses search arguments with format
+// [name=<name>] [tags=<tag>,<tag>] [to=<notifier>,<notifier>] [chat/]query
 func parseArgs(args string, chat string) (parsedArgs, error) {
+	var name string
+	var tags, targets []string
+	fields := strings.Split(strings.Trim(args, " "), " ")
+	for len(fields) > 0 {
+		f := fields[0]
+		if strings.HasPrefix(f, "name=") {
+			name = strings.TrimPrefix(f, "name=")
+			if name == "" || strings.ContainsAny(name, "/?") {
+				return parsedArgs{}, fmt.Errorf("invalid search name %q", name)
+			}
+		} else if strings.HasPrefix(f, "tags=") {
+			for _, t := range strings.Split(strings.TrimPrefix(f, "tags="), ",") {
+				if t == "" {
+					continue
+				}
+				tags = append(tags, strings.ToLower(t))
+			}
+		} else if strings.HasPrefix(f, "to=") {
+			for _, t := range strings.Split(strings.TrimPrefix(f, "to="), ",") {
+				if t == "" {
+					continue
+				}
+				targets = append(targets, strings.ToLower(t))
+			}
+		} else if f != "" {
+			break
+		}
+		fields = fields[1:]
+	}
+	args = strings.Join(fields, " ")
+
 	split := strings.Split(args, "/")
 	p := parsedArgs{
-		chat:  chat,
-		query: split[0],
+		chat:    chat,
+		query:   split[0],
+		name:    name,
+		tags:    tags,
+		targets: targets,
 	}
 	switch len(split) {
 	case 1:
@@ -276,111 +693,120 @@ func parseArgs(args string, chat string) (parsedArgs, error) {
 	return p, nil
 }
 
-func (b *bot) search(ctx context.Context, parsed parsedArgs) {
+// search runs a search, notifies new items and returns the number of items tracked.
+func (b *bot) search(ctx context.Context, parsed parsedArgs) (int, error) {
 	if parsed.query == "" {
-		return
+		return 0, nil
 	}
 
-	items := make(map[string]api.Item)
-	if err := b.db.Get("db", parsed.id, &items); err != nil {
-		b.log(err)
+	items, err := b.db.Items(parsed.id)
+	if err != nil {
+		slog.Error("couldn't get search items", "search", parsed.label(), "err", err)
 		items = make(map[string]api.Item)
 	}
 	if len(items) == 0 {
-		// store search with empty items on db
-		if err := b.db.Put("db", parsed.id, items); err != nil {
-			b.log(err)
-			return
-		}
+		// The first search stores the listed items without notifying them
 		if err := b.client.Search(parsed.query, items, func(api.Item) error { return nil }); err != nil {
-			b.log(err)
-			return
+			return len(items), err
 		}
 	}
-	if err := b.client.Search(parsed.query, items, func(i api.Item) error {
+	start := time.Now().UTC()
+	searchErr := b.client.Search(parsed.query, items, func(i api.Item) error {
 		cacheID := fmt.Sprintf("%s/%s/%.2f-%.2f", parsed.chat, i.ID, i.Price, i.PreviousPrice)
 		if _, ok := b.cache.Get(cacheID); ok {
 			return nil
 		}
-		text := newAdMessage(i, parsed.chat)
+		kind := notify.KindNew
 		if i.PreviousPrice > i.Price {
-			text = priceDownMessage(i, parsed.chat)
+			kind = notify.KindPriceDrop
+			priceDrops.Inc()
+		} else {
+			itemsNew.Inc()
 		}
-		b.message(parsed.chat, text)
+		b.notify(ctx, parsed, i, kind)
 		b.cache.Set(cacheID, struct{}{}, cache.DefaultExpiration)
 		return nil
-	}); err != nil {
-		b.log(err)
-	}
+	})
 	if len(items) == 0 {
-		return
+		return 0, searchErr
+	}
+	v, _ := b.listed.LoadOrStore(parsed.id, make(map[string]bool))
+	listed := v.(map[string]bool)
+	for id, i := range items {
+		if !i.SeenAt.Before(start) {
+			itemsSeen.Inc()
+			listed[id] = true
+		}
+	}
+	// Items not seen during a complete search are gone. Only items listed
+	// since the bot started are considered, the stored seen time isn't
+	// precise enough and the history stored by previous versions must not be
+	// notified as gone after an upgrade.
+	if searchErr == nil && ctx.Err() == nil {
+		for id, i := range items {
+			if !i.SeenAt.Before(start) || !i.GoneAt.IsZero() || !listed[id] {
+				continue
+			}
+			delete(listed, id)
+			i.GoneAt = time.Now().UTC()
+			items[id] = i
+			b.notify(ctx, parsed, i, notify.KindGone)
+		}
 	}
 	if _, ok := b.searchs.Load(parsed.id); !ok {
-		return
+		return len(items), searchErr
 	}
-	if err := b.db.Put("db", parsed.id, items); err != nil {
-		b.log(err)
-		return
+	if err := b.db.PutItems(parsed.id, items); err != nil {
+		return len(items), err
 	}
+	return len(items), searchErr
 }
 
-func (b *bot) stopAll() {
-	b.log("stopping all")
-	var keys []string
-	b.searchs.Range(func(k interface{}, _ interface{}) bool {
-		keys = append(keys, k.(string))
-		return true
-	})
-	for _, k := range keys {
-		b.log(fmt.Sprintf("stopping %s", k))
-		b.searchs.Delete(k)
-		delete(b.hash, sha(k))
-		if err := b.db.Delete("db", k); err != nil {
-			b.log(err)
-		}
+func (b *bot) stats(user int, parsed parsedArgs) {
+	if _, ok := b.searchs.Load(parsed.id); !ok {
+		b.message(user, fmt.Sprintf("search %s not found", parsed.label()))
+		return
 	}
-}
-func (b *bot) stop(parsed parsedArgs) {
-	if _, ok := b.searchs.Load(parsed.id); ok {
-		b.log(fmt.Sprintf("stopping %s", parsed.id))
-		b.searchs.Delete(parsed.id)
-		delete(b.hash, sha(parsed.id))
-		if err := b.db.Delete("db", parsed.id); err != nil {
-			b.log(err)
-		}
+	stats, err := b.searchStats(parsed.id)
+	if err != nil {
+		slog.Error("couldn't get search stats", "search", parsed.label(), "err", err)
+		return
 	}
+	b.message(user, fmt.Sprintf("stats for %s\n\nitems: %d\nprice drops: %d\ngone: %d\nmin price: %.2f€\nmax price: %.2f€\navg price: %.2f€",
+		parsed.label(), stats.Items, stats.Drops, stats.Gone, stats.Min, stats.Max, stats.Avg))
 }
 
 func (b *bot) export(user int) {
-	var keys []string
-	b.searchs.Range(func(k interface{}, _ interface{}) bool {
-		keys = append(keys, k.(string))
+	var lines []string
+	b.searchs.Range(func(k interface{}, v interface{}) bool {
+		info := v.(searchInfo)
+		lines = append(lines, parsedArgs{id: k.(string), name: info.Name, tags: info.Tags, targets: info.Targets}.line())
 		return true
 	})
-	sort.Strings(keys)
-	b.message(user, fmt.Sprintf("/batch %s", strings.Join(keys, "\n")))
+	sort.Strings(lines)
+	b.message(user, fmt.Sprintf("/batch %s", strings.Join(lines, "\n")))
 }
 
 func (b *bot) messageOpts(chat interface{}, text string, preview bool, btns []tgbot.InlineKeyboardButton) {
-	var msg tgbot.MessageConfig
+	o := &outbound{
+		Text:    text,
+		Preview: preview,
+	}
 	switch v := chat.(type) {
 	case string:
-		msg = tgbot.NewMessageToChannel(v, text)
+		o.Chat = v
 	case int64:
-		msg = tgbot.NewMessage(v, text)
+		o.Chat = strconv.FormatInt(v, 10)
 	case int:
-		msg = tgbot.NewMessage(int64(v), text)
+		o.Chat = strconv.Itoa(v)
 	default:
-		b.log(fmt.Sprintf("invalid type for message: %T", chat))
+		slog.Error("invalid chat type for message", "type", fmt.Sprintf("%T", chat))
+		return
 	}
 	if len(btns) > 0 {
-		msg.ReplyMarkup = tgbot.NewInlineKeyboardMarkup(btns)
-	}
-	msg.DisableWebPagePreview = !preview
-	if _, err := b.Send(msg); err != nil {
-		b.log(fmt.Errorf("couldn't send message to %v: %w", chat, err))
+		o.Buttons = [][]tgbot.InlineKeyboardButton{btns}
 	}
-	<-time.After(100 * time.Millisecond)
+	b.queue.push(o)
 }
 
 func (b *bot) message(chat interface{}, text string) {
@@ -397,7 +823,7 @@ func (b *bot) printChatID(msg *tgbot.Message) {
 			if m.ID == b.Self.ID {
 				admins, err := b.GetChatAdministrators(msg.Chat.ChatConfig())
 				if err != nil {
-					b.log(fmt.Errorf("couldn'r get admins for chat id %d: %w", msg.Chat.ID, err))
+					slog.Error("couldn't get chat admins", "chat", msg.Chat.ID, "err", err)
 					return
 				}
 				for _, a := range admins {
@@ -408,31 +834,32 @@ func (b *bot) printChatID(msg *tgbot.Message) {
 	}
 }
 
-func (b *bot) log(obj interface{}) {
-	text := fmt.Sprintf("%s", obj)
-	log.Println(text)
-	if _, err := b.Send(tgbot.NewMessage(int64(b.admin), text)); err != nil {
-		log.Println(fmt.Errorf("couldn't send error to admin %d: %w", b.admin, err))
-	}
-	<-time.After(100 * time.Millisecond)
+func newAdMessage(i api.Item, parsed parsedArgs) string {
+	return fmt.Sprintf("‼️ NUEVO ANUNCIO\n\n%s\n\n✅ Precio: %.2f€\n\n🔗 %s%s",
+		i.Title, i.Price, i.Link, messageBottom(parsed))
 }
 
-func newAdMessage(i api.Item, chat string) string {
-	bottom := ""
-	if strings.HasPrefix(chat, "@") {
-		bottom = fmt.Sprintf("\n\n📣 Más anuncios en %s", chat)
-	}
-	return fmt.Sprintf("‼️ NUEVO ANUNCIO\n\n%s\n\n✅ Precio: %.2f€\n\n🔗 %s%s",
-		i.Title, i.Price, i.Link, bottom)
+func priceDownMessage(i api.Item, parsed parsedArgs) string {
+	return fmt.Sprintf("⚡️ BAJADA DE PRECIO\n\n%s\n\n✅ Precio: %.2f€\n🚫 Anterior: %.2f€\n\n🔗 %s%s",
+		i.Title, i.Price, i.PreviousPrice, i.Link, messageBottom(parsed))
 }
 
-func priceDownMessage(i api.Item, chat string) string {
+func messageBottom(parsed parsedArgs) string {
+	var parts []string
+	if parsed.name != "" {
+		parts = append(parts, fmt.Sprintf("🔎 %s", parsed.name))
+	}
+	if len(parsed.tags) > 0 {
+		parts = append(parts, fmt.Sprintf("#%s", strings.Join(parsed.tags, " #")))
+	}
 	bottom := ""
-	if strings.HasPrefix(chat, "@") {
-		bottom = fmt.Sprintf("\n\n📣 Más anuncios en %s", chat)
+	if len(parts) > 0 {
+		bottom = fmt.Sprintf("\n\n%s", strings.Join(parts, " "))
 	}
-	return fmt.Sprintf("⚡️ BAJADA DE PRECIO\n\n%s\n\n✅ Precio: %.2f€\n🚫 Anterior: %.2f€\n\n🔗 %s%s",
-		i.Title, i.Price, i.PreviousPrice, i.Link, bottom)
+	if strings.HasPrefix(parsed.chat, "@") {
+		bottom = fmt.Sprintf("%s\n\n📣 Más anuncios en %s", bottom, parsed.chat)
+	}
+	return bottom
 }
 
 func sha(s string) string {
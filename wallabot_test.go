@@ -0,0 +1,95 @@
+package wallabot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+	"github.com/igolaizola/wallabot/internal/notify"
+)
+
+// eventRecorder is a notifier that records the events it receives.
+type eventRecorder struct {
+	events []notify.Event
+}
+
+func (r *eventRecorder) Send(ctx context.Context, e notify.Event) error {
+	r.events = append(r.events, e)
+	return nil
+}
+
+func TestSearchGone(t *testing.T) {
+	b := newTestBot(t)
+	w := withWallapop(t, b, "a1", "a2")
+	rec := &eventRecorder{}
+	b.notifier = map[string]notify.Notifier{"rec": rec}
+	parsed, err := parseArgs("to=rec 2/bike", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Items stored before a restart, a3 is no longer listed
+	before := time.Now().UTC().Add(-30 * time.Minute)
+	stored := make(map[string]api.Item)
+	for _, id := range []string{"a1", "a2", "a3"} {
+		stored[id] = api.Item{ID: id, Title: "bike " + id, Price: 100, PreviousPrice: -1, SeenAt: before,
+			History: []api.Price{{Price: 100, Time: before}}, CreatedAt: before}
+	}
+	if err := b.db.PutItems("2/bike", stored); err != nil {
+		t.Fatal(err)
+	}
+	search := func() {
+		t.Helper()
+		if _, err := b.search(context.Background(), parsed); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Items not listed since the bot started aren't notified as gone
+	search()
+	if len(rec.events) != 0 {
+		t.Fatalf("got events %+v, want none", rec.events)
+	}
+
+	// Items listed since the bot started are notified as gone even if their
+	// stored seen time is older
+	w.listed = []string{"a1"}
+	search()
+	if len(rec.events) != 1 || rec.events[0].Kind != notify.KindGone || rec.events[0].Item.ID != "a2" {
+		t.Fatalf("got events %+v, want a2 gone", rec.events)
+	}
+	search()
+	if len(rec.events) != 1 {
+		t.Errorf("got %d events, gone items are notified once", len(rec.events))
+	}
+	items, err := b.db.Items("2/bike")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if items["a2"].GoneAt.IsZero() || !items["a3"].GoneAt.IsZero() {
+		t.Errorf("got gone times a2 %s and a3 %s, want only a2", items["a2"].GoneAt, items["a3"].GoneAt)
+	}
+}
+
+func TestMessageBottom(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"2/bike", ""},
+		{"name=bikes 2/bike", "\n\n🔎 bikes"},
+		{"name=bikes tags=sport,road 2/bike", "\n\n🔎 bikes #sport #road"},
+		{"tags=sport,road 2/bike", "\n\n#sport #road"},
+		{"tags=sport @bikes/bike", "\n\n#sport\n\n📣 Más anuncios en @bikes"},
+	}
+	for _, tt := range tests {
+		parsed, err := parseArgs(tt.query, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := messageBottom(parsed); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package wallabot
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/igolaizola/wallabot/internal/api"
+)
+
+//go:embed web
+var webFS embed.FS
+
+const (
+	sessionCookie   = "wallabot_session"
+	sessionDuration = 7 * 24 * time.Hour
+	webItems        = 30
+)
+
+var webFuncs = template.FuncMap{
+	"price": func(p float64) string {
+		return fmt.Sprintf("%.2f€", p)
+	},
+	"ago": func(t time.Time) string {
+		d := time.Since(t)
+		switch {
+		case d < time.Minute:
+			return "just now"
+		case d < time.Hour:
+			return fmt.Sprintf("%dm ago", int(d.Minutes()))
+		case d < 48*time.Hour:
+			return fmt.Sprintf("%dh ago", int(d.Hours()))
+		default:
+			return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+		}
+	},
+	"join":  strings.Join,
+	"chart": priceChart,
+	"actions": func(csrf string, s searchView) map[string]interface{} {
+		return map[string]interface{}{"CSRF": csrf, "Search": s}
+	},
+}
+
+// webTemplates are the page templates, each one parsed with the layout.
+var webTemplates = func() map[string]*template.Template {
+	tmpls := make(map[string]*template.Template)
+	for _, page := range []string{"login", "index", "search"} {
+		tmpls[page] = template.Must(template.New(page).Funcs(webFuncs).ParseFS(webFS,
+			"web/templates/layout.html", fmt.Sprintf("web/templates/%s.html", page)))
+	}
+	return tmpls
+}()
+
+// webPage is the data passed to the page templates.
+type webPage struct {
+	Title    string
+	Error    string
+	CSRF     string
+	Chat     string
+	Searches []searchView
+	Search   searchView
+	Stats    searchStats
+	Items    []api.Item
+}
+
+// webHandler serves the web dashboard under /web/
+func (b *bot) webHandler() http.Handler {
+	static, _ := fs.Sub(webFS, "web/static")
+	mux := http.NewServeMux()
+	mux.Handle("/web/static/", http.StripPrefix("/web/static/", http.FileServer(http.FS(static))))
+	mux.HandleFunc("/web/login", b.webLogin)
+	mux.HandleFunc("/web/", b.webPages)
+	return mux
+}
+
+// webLogin logs in the user with an api token or a one-time login code.
+func (b *bot) webLogin(w http.ResponseWriter, r *http.Request) {
+	user := 0
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("code") != "":
+		code := r.URL.Query().Get("code")
+		v, ok := b.logins.Get(code)
+		if !ok {
+			b.render(w, http.StatusUnauthorized, "login", webPage{Title: "login", Error: "login link expired"})
+			return
+		}
+		b.logins.Delete(code)
+		user = v.(int)
+	case r.Method == http.MethodPost:
+		var t apiToken
+		token := r.FormValue("token")
+		if token != "" {
+			_ = b.db.Get("tokens", tokenKey(token), &t)
+		}
+		user = t.User
+	default:
+		b.render(w, http.StatusOK, "login", webPage{Title: "login"})
+		return
+	}
+	if _, ok := b.chats.Load(user); !ok {
+		b.render(w, http.StatusUnauthorized, "login", webPage{Title: "login", Error: "invalid token"})
+		return
+	}
+	value, err := b.session(user, time.Now().Add(sessionDuration))
+	if err != nil {
+		b.webError(w, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/web/",
+		MaxAge:   int(sessionDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(b.publicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/web/", http.StatusSeeOther)
+}
+
+// webPages serves the pages that require a session.
+func (b *bot) webPages(w http.ResponseWriter, r *http.Request) {
+	user, csrf, ok := b.webSession(r)
+	if !ok {
+		http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+		return
+	}
+	if r.Method == http.MethodPost {
+		if !hmac.Equal([]byte(r.FormValue("csrf")), []byte(csrf)) {
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
+			return
+		}
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/web"), "/")
+	split := strings.Split(path, "/")
+	page := webPage{CSRF: csrf}
+	var err error
+	switch {
+	case path == "" && r.Method == http.MethodGet:
+		page.Searches = b.listFor(user)
+		page.Chat = strconv.Itoa(user)
+		if v, ok := b.chats.Load(user); ok {
+			page.Chat = v.(string)
+		}
+		b.render(w, http.StatusOK, "index", page)
+		return
+	case path == "logout" && r.Method == http.MethodPost:
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/web/", MaxAge: -1})
+		http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+		return
+	case path == "search" && r.Method == http.MethodPost:
+		var view searchView
+		view, err = b.createSearch(user, formRequest(r))
+		if err == nil {
+			http.Redirect(w, r, "/web/search/"+view.ID, http.StatusSeeOther)
+			return
+		}
+	case len(split) == 2 && split[0] == "search" && r.Method == http.MethodGet:
+		parsed, ok := b.findFor(user, split[1])
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		page.Search, _ = b.view(parsed.id)
+		page.Title = page.Search.Name
+		if page.Title == "" {
+			page.Title = page.Search.Query
+		}
+		if page.Stats, err = b.searchStats(parsed.id); err != nil {
+			break
+		}
+		if page.Items, err = b.items(parsed.id, itemFilter{limit: webItems}); err != nil {
+			break
+		}
+		b.render(w, http.StatusOK, "search", page)
+		return
+	case len(split) == 3 && split[0] == "search" && r.Method == http.MethodPost:
+		var view searchView
+		switch split[2] {
+		case "pause", "resume":
+			paused := split[2] == "pause"
+			view, err = b.updateSearch(user, split[1], searchRequest{Paused: &paused})
+		case "update":
+			view, err = b.updateSearch(user, split[1], formRequest(r))
+		case "delete":
+			if _, err = b.apiDelete(user, split[1]); err == nil {
+				http.Redirect(w, r, "/web/", http.StatusSeeOther)
+				return
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		if err == nil {
+			// Pause and resume go back to the page where they were used
+			target := "/web/search/" + view.ID
+			if split[2] != "update" && r.Referer() != "" {
+				target = r.Referer()
+			}
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	b.webError(w, err)
+}
+
+// formRequest returns the search request of a submitted form.
+func formRequest(r *http.Request) searchRequest {
+	var req searchRequest
+	for field, dst := range map[string]**string{
+		"query": &req.Query,
+		"name":  &req.Name,
+		"chat":  &req.Chat,
+	} {
+		if _, ok := r.PostForm[field]; ok {
+			v := strings.TrimSpace(r.PostFormValue(field))
+			*dst = &v
+		}
+	}
+	for field, dst := range map[string]**[]string{
+		"tags":    &req.Tags,
+		"targets": &req.Targets,
+	} {
+		if _, ok := r.PostForm[field]; ok {
+			values := []string{}
+			for _, v := range strings.Split(r.PostFormValue(field), ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+			*dst = &values
+		}
+	}
+	return req
+}
+
+func (b *bot) render(w http.ResponseWriter, status int, page string, data webPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := webTemplates[page].ExecuteTemplate(w, "layout", data); err != nil {
+		slog.Error("couldn't render web page", "page", page, "err", err)
+	}
+}
+
+func (b *bot) webError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if apiErr, ok := err.(*apiError); ok {
+		status = apiErr.status
+	} else {
+		slog.Error("web request failed", "err", err)
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// session returns a signed session cookie value for the user.
+func (b *bot) session(user int, expiry time.Time) (string, error) {
+	secret, err := b.secret("web_secret")
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%d|%d", user, expiry.Unix())
+	return payload + "|" + webMAC(secret, payload), nil
+}
+
+// webSession validates the session cookie and returns its user and csrf
+// token.
+func (b *bot) webSession(r *http.Request) (int, string, bool) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return 0, "", false
+	}
+	split := strings.Split(c.Value, "|")
+	if len(split) != 3 {
+		return 0, "", false
+	}
+	secret, err := b.secret("web_secret")
+	if err != nil {
+		return 0, "", false
+	}
+	payload := split[0] + "|" + split[1]
+	if !hmac.Equal([]byte(webMAC(secret, payload)), []byte(split[2])) {
+		return 0, "", false
+	}
+	user, err := strconv.Atoi(split[0])
+	if err != nil {
+		return 0, "", false
+	}
+	expiry, err := strconv.ParseInt(split[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiry {
+		return 0, "", false
+	}
+	if _, ok := b.chats.Load(user); !ok {
+		return 0, "", false
+	}
+	return user, webMAC(secret, "csrf|"+c.Value), true
+}
+
+func webMAC(secret []byte, payload string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webLink sends the user a one-time link to log into the web dashboard.
+func (b *bot) webLink(user int) {
+	if b.publicURL == "" {
+		b.message(user, "public url not configured")
+		return
+	}
+	rnd := make([]byte, 16)
+	if _, err := rand.Read(rnd); err != nil {
+		slog.Error("couldn't generate login code", "err", err)
+		return
+	}
+	code := hex.EncodeToString(rnd)
+	b.logins.SetDefault(code, user)
+	b.message(user, fmt.Sprintf("log into the dashboard within 5 minutes, the link can only be used once:\n\n%s/web/login?code=%s", b.publicURL, code))
+}
+
+// priceChart returns an inline svg with the price history of an item.
+func priceChart(i api.Item) template.HTML {
+	if len(i.History) < 2 {
+		return ""
+	}
+	const width, height, pad = 240.0, 60.0, 4.0
+	min, max := i.History[0].Price, i.History[0].Price
+	for _, p := range i.History {
+		if p.Price < min {
+			min = p.Price
+		}
+		if p.Price > max {
+			max = p.Price
+		}
+	}
+	// Extend the chart to now, prices are kept until they change
+	history := append(append([]api.Price{}, i.History...), api.Price{Price: i.History[len(i.History)-1].Price, Time: time.Now()})
+	if !i.GoneAt.IsZero() {
+		history[len(history)-1].Time = i.GoneAt
+	}
+	start := history[0].Time
+	span := history[len(history)-1].Time.Sub(start).Seconds()
+	if span <= 0 {
+		span = 1
+	}
+	var points []string
+	for n, p := range history {
+		x := pad + (width-2*pad)*p.Time.Sub(start).Seconds()/span
+		y := height / 2
+		if max > min {
+			y = pad + (height-2*pad)*(max-p.Price)/(max-min)
+		}
+		// Step chart, the previous price holds until the change
+		if n > 0 {
+			points = append(points, fmt.Sprintf("%.1f,%s", x, strings.Split(points[len(points)-1], ",")[1]))
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg viewBox="0 0 %.0f %.0f" width="100%%" height="%.0f"><title>%.2f€ - %.2f€</title><polyline fill="none" stroke="#13c1ac" stroke-width="2" points="%s"/></svg>`,
+		width, height, height, min, max, strings.Join(points, " ")))
+}
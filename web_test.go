@@ -0,0 +1,116 @@
+package wallabot
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// webCall calls the web handler with a session of the user, posted forms
+// include its csrf token.
+func webCall(t *testing.T, b *bot, user int, method, path string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	value, err := b.session(user, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := &http.Cookie{Name: sessionCookie, Value: value}
+	body := strings.NewReader("")
+	if form != nil {
+		check := httptest.NewRequest(http.MethodGet, "/web/", nil)
+		check.AddCookie(cookie)
+		_, csrf, ok := b.webSession(check)
+		if !ok {
+			t.Fatal("invalid session")
+		}
+		form.Set("csrf", csrf)
+		body = strings.NewReader(form.Encode())
+	}
+	req := httptest.NewRequest(method, path, body)
+	req.AddCookie(cookie)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	rec := httptest.NewRecorder()
+	b.webHandler().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebScope(t *testing.T) {
+	b := newTestBot(t)
+	car := searchID("3/car")
+
+	rec := webCall(t, b, testAlice, http.MethodGet, "/web/", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("index status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, searchID("2/bike")) || strings.Contains(body, car) {
+		t.Errorf("index doesn't list only the searches of the user:\n%s", body)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		form   url.Values
+		status int
+	}{
+		{http.MethodGet, "/web/search/" + car, nil, http.StatusNotFound},
+		{http.MethodPost, "/web/search/" + car + "/pause", url.Values{}, http.StatusNotFound},
+		{http.MethodPost, "/web/search/" + car + "/update", url.Values{"name": {"mine"}}, http.StatusNotFound},
+		{http.MethodPost, "/web/search/" + car + "/delete", url.Values{}, http.StatusNotFound},
+		{http.MethodPost, "/web/search", url.Values{"query": {"boat"}, "chat": {"3"}}, http.StatusForbidden},
+		{http.MethodPost, "/web/search", url.Values{"query": {"boat"}}, http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		if rec := webCall(t, b, testAlice, tt.method, tt.path, tt.form); rec.Code != tt.status {
+			t.Errorf("%s %s: status = %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+	v, ok := b.searchs.Load("3/car")
+	if !ok {
+		t.Fatal("search of other chat was deleted")
+	}
+	if info := v.(searchInfo); info.Paused || info.Name != "" {
+		t.Errorf("search of other chat was modified: %+v", info)
+	}
+	if _, ok := b.searchs.Load("2/boat"); !ok {
+		t.Error("search wasn't created in the chat of the user")
+	}
+
+	// The admin manages all chats
+	if rec := webCall(t, b, testAdmin, http.MethodGet, "/web/search/"+car, nil); rec.Code != http.StatusOK {
+		t.Errorf("admin search page status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := webCall(t, b, testAdmin, http.MethodPost, "/web/search/"+car+"/delete", url.Values{}); rec.Code != http.StatusSeeOther {
+		t.Errorf("admin delete status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	if _, ok := b.searchs.Load("3/car"); ok {
+		t.Error("search wasn't deleted by the admin")
+	}
+}
+
+func TestWebSession(t *testing.T) {
+	b := newTestBot(t)
+
+	rec := httptest.NewRecorder()
+	b.webHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/web/", nil))
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/web/login" {
+		t.Errorf("status = %d, location = %q, want redirect to login", rec.Code, rec.Header().Get("Location"))
+	}
+
+	value, err := b.session(testAlice, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/web/search/"+searchID("2/bike")+"/delete", strings.NewReader("csrf=bad"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+	rec = httptest.NewRecorder()
+	b.webHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("invalid csrf status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}